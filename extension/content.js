@@ -10,12 +10,30 @@ class ErrorCodes {
 	static FileIsTooBig = 7
 	static FileFormatNotSupported = 8
     static TransientError = 9
+    static FileIsCorrupted = 13
+    static SVGContainsUnsafeContent = 14
+    static SimilarMacroExists = 15
+    static AliasTargetNotFound = 16
+    static JobNotFound = 17
+    static NameContainsInvalidCharacters = 18
+    static NameIsTooShort = 19
+    static NameIsTooLong = 20
+    static NameIsReserved = 21
+    static NamespaceAccessDenied = 22
+    static InvalidNamespace = 23
+    static AuthenticationRequired = 24
+    static ImageFetchFailed = 31
 }
 
 Object.freeze(ErrorCodes); 
 
 const gVersion = "1.0.4"
 
+// GitHub token set in the options page, it lets the server show the macros of the user organizations
+let gGithubToken = '';
+// the token is only sent to the macros API
+const gAPIOrigin = 'https://us-central1-github-macros.cloudfunctions.net/';
+
 const numberOfTopUsagesToDisplay = 10;
 const maxTopUsagesToStore = 1;
 const maxSuggestionsFreshnessDuration = 60 * 60 * 24 * 1000;
@@ -27,6 +45,8 @@ const tooltipsCache = new Map();
 const targetIdToTarget = new Map();
 const macroTopUsages = [];
 const nameToTopUsage = new Map();
+// names of the macros the user starred, loaded from the personal query
+const favoriteMacros = new Set();
 
 const gGifIconSrc = chrome.runtime.getURL('img/icons/gif.png');
 const gMagGlassIconSrc = chrome.runtime.getURL('img/icons/mglass-4x.png');
@@ -135,6 +155,10 @@ ajax = function(settings) {
         settings['complete'] = catchAndLog(settings['complete']);
     }
 
+    if (gGithubToken && String(settings['url']).startsWith(gAPIOrigin)) {
+        settings['headers'] = { ...settings['headers'], Authorization: `token ${gGithubToken}` };
+    }
+
     catchAndLog(() => $.ajax(settings))()
 }
 
@@ -412,7 +436,7 @@ createSingleMacro = function(targetId, item) {
     const image = document.createElement('img');
     image.style.width = '100%';
     image.style.height = '100%';
-    image.src = item['url'];
+    image.src = item['preview_url'] || item['thumbnail_url'] || item['url'];
     image.title = item['name'];
     image.style.display = 'block';
 
@@ -443,12 +467,53 @@ createSingleMacro = function(targetId, item) {
 
     div.appendChild(image)
 
+    if (gGithubToken) {
+        div.appendChild(createFavoriteToggle(item));
+    }
+
     const target = targetIdToTarget.get(targetId);
     tooltipsCache.get(target)['intersection_observer'].observe(div);
 
     return div
 }
 
+createFavoriteToggle = function(item) {
+    const toggle = document.createElement('text');
+    toggle.style.position = 'absolute';
+    toggle.style.top = '2px';
+    toggle.style.right = '4px';
+    toggle.style.color = '#FFD700';
+    toggle.style.userSelect = 'none';
+    toggle.style.cursor = 'pointer';
+
+    const render = () => {
+        toggle.innerText = favoriteMacros.has(item['name']) ? '\u2605' : '\u2606';
+    };
+
+    toggle.onclick = catchAndLog(function(e) {
+        // don't select the macro
+        e.stopPropagation();
+
+        const star = !favoriteMacros.has(item['name']);
+        if (star) {
+            favoriteMacros.add(item['name']);
+        } else {
+            favoriteMacros.delete(item['name']);
+        }
+
+        render();
+
+        ajaxPost(
+            `https://us-central1-github-macros.cloudfunctions.net/${star ? 'star' : 'unstar'}/`,
+            {name: item['name'], version: gVersion},
+        );
+    });
+
+    render();
+
+    return toggle;
+}
+
 addMacroToUI = function(targetId, macro, addAtTheEnd) {
     meta = tooltipsCache.get(targetIdToTarget.get(targetId))
 
@@ -855,7 +920,8 @@ initAddNewMacroLogic = function(targetId) {
     getElement(targetId, 'addNewMacroCloseButton').onclick = catchAndLog(() => {hideAddMacroUI(targetId);});
 
     getElement(targetId, 'addNewMacroButton').onclick = catchAndLog(() => {addNewMacro(targetId);});
-    
+
+    initNewMacroNameInput(targetId);
 }
 
 processSystemMessage = function(systemMessage) {
@@ -947,18 +1013,52 @@ errCodeToHTML = function(targetId, errCode) {
         case ErrorCodes.URLHostnameNotSupported:
             return "Only Github URLs are allowed. Drop the image into the comment box and get its URL from the Preview tab";
         case ErrorCodes.FileIsTooBig:
-            return `Image exceeds 10Mb. Please reduce its size and try again. You can use <a target="_blank" href="https://ezgif.com/optimize">this</a> website to do it`;
+            return `Image exceeds 10Mb. Please reduce its size and try again. You can use <a target="_blank" href="https://ezgif.com/optimize">this</a> website to do it, or enable image optimization in the extension options`;
         case ErrorCodes.FileFormatNotSupported:
-            return "URL is not a valid supported image (jpeg/png/gif/bmp)";
+            return "URL is not a valid supported image (jpeg/png/apng/gif/bmp/webp/svg/avif)";
         case ErrorCodes.TransientError:
+        case ErrorCodes.JobNotFound:
             return "Something went wrong, please try again later";
+        case ErrorCodes.FileIsCorrupted:
+            return "Image is corrupted or truncated";
+        case ErrorCodes.SVGContainsUnsafeContent:
+            return "SVG images with scripts or external references are not supported by this server";
+        case ErrorCodes.SimilarMacroExists:
+            return "This image already exists under a different name";
+        case ErrorCodes.AliasTargetNotFound:
+            return "The macro to alias doesn't exist";
+        case ErrorCodes.NameContainsInvalidCharacters:
+            return "Name can only contain letters, digits, '_' and '-', and must start with a letter or a digit";
+        case ErrorCodes.NameIsTooShort:
+            return `Name must be at least ${gMinNameLength} characters long`;
+        case ErrorCodes.NameIsTooLong:
+            return `Name can't be longer than ${gMaxNameLength} characters`;
+        case ErrorCodes.NameIsReserved:
+            return "This name is reserved, please choose another one";
+        case ErrorCodes.NamespaceAccessDenied:
+            return "Only members of the organization can use its macros. Set a GitHub token with the read:org scope in the extension options";
+        case ErrorCodes.InvalidNamespace:
+            return "Organization name is not valid";
+        case ErrorCodes.AuthenticationRequired:
+            return "Organization macros need a GitHub token with the read:org scope, set it in the extension options";
+        case ErrorCodes.ImageFetchFailed:
+            return "The image URL responded with an error, make sure it's publicly accessible";
     }
 }
 
-addNewMacroShowErrorMessage = function(targetId, errCode) {
+suggestionsToHTML = function(suggestions) {
+    if (!suggestions || suggestions.length == 0) {
+        return '';
+    }
+
+    // suggested names are validated by the server to only contain letters, digits, '_' and '-'
+    return `<br>Available names: ${suggestions.join(', ')}`;
+}
+
+addNewMacroShowErrorMessage = function(targetId, errCode, suggestions) {
     getElement(targetId, 'addNewMacroSpinner').style.display = 'none';
     getElement(targetId, 'addNewMacroError').style.display = 'flex';
-    getElement(targetId, 'addNewMacroErrorMessage').innerHTML = errCodeToHTML(targetId, errCode);
+    getElement(targetId, 'addNewMacroErrorMessage').innerHTML = errCodeToHTML(targetId, errCode) + suggestionsToHTML(suggestions);
 
     addingNewMacro = false;
 }
@@ -968,15 +1068,40 @@ addNewMacroShowSuccessMessage = function(targetId) {
     getElement(targetId, 'addNewMacroSuccess').style.display = 'flex';
 }
 
+const gMinNameLength = 2;
+const gMaxNameLength = 32;
+
 validateInput = function(macroName, macroURL) {
     if (macroName === '') {
         return ErrorCodes.EmptyName
     }
 
-    if (macroName.includes(' ')) {
+    if (/\s/.test(macroName)) {
         return ErrorCodes.NameContainsSpaces
     }
 
+    // private macros are prefixed by their organization, "org/name"
+    const separatorIndex = macroName.indexOf('/');
+    if (separatorIndex >= 0) {
+        if (!/^[A-Za-z0-9][A-Za-z0-9-]{0,38}$/.test(macroName.slice(0, separatorIndex))) {
+            return ErrorCodes.InvalidNamespace
+        }
+
+        macroName = macroName.slice(separatorIndex + 1);
+    }
+
+    if (!/^[A-Za-z0-9][A-Za-z0-9_-]*$/.test(macroName)) {
+        return ErrorCodes.NameContainsInvalidCharacters
+    }
+
+    if (macroName.length < gMinNameLength) {
+        return ErrorCodes.NameIsTooShort
+    }
+
+    if (macroName.length > gMaxNameLength) {
+        return ErrorCodes.NameIsTooLong
+    }
+
     if (macroURL === '') {
         return ErrorCodes.EmptyURL
     }
@@ -999,7 +1124,72 @@ isGitHubMediaLink = function(macroURL) {
     }
 }
 
+const gAddStatusPollIntervalMs = 1000;
+// a job that isn't done by then is assumed lost, e.g. its worker crashed
+const gAddStatusPollTimeoutMs = 3 * 60 * 1000;
+
+onAddNewMacroDone = function(targetId, macroName, origURL, githubURL, response) {
+    if (response['code'] != ErrorCodes.Success) {
+        addNewMacroShowErrorMessage(targetId, response['code'], response['suggestions']);
+        return
+    }
+
+    addNewMacroShowSuccessMessage(targetId);
+
+    const newMacro = response['data'];
+
+    macroNameToUrl.set(macroName, githubURL || origURL);
+
+    getElement(targetId, 'macroSearchInput').value = "";
+    getElement(targetId, 'macrosSection').scrollTop = 0;
+
+    addMacroToUI(targetId, newMacro, false);
+    addingNewMacro = false;
+}
+
+pollAddNewMacroStatus = function(targetId, macroName, origURL, githubURL, jobID, deadline) {
+    if (Date.now() > deadline) {
+        addNewMacroShowErrorMessage(targetId, ErrorCodes.TransientError);
+        return
+    }
+
+    const url = new URL('https://us-central1-github-macros.cloudfunctions.net/add_status/')
+    url.searchParams.append('id', jobID)
+
+    ajax({
+        url: url,
+        success: function(responseText) {
+            const response = JSON.parse(responseText);
+            if (response['code'] == ErrorCodes.JobNotFound || response['done']) {
+                onAddNewMacroDone(targetId, macroName, origURL, githubURL, response);
+                return
+            }
+
+            setTimeout(
+                catchAndLog(() => pollAddNewMacroStatus(targetId, macroName, origURL, githubURL, jobID, deadline)),
+                gAddStatusPollIntervalMs,
+            );
+        },
+        fail: function() {
+            addNewMacroShowErrorMessage(targetId, ErrorCodes.TransientError);
+        },
+    })
+}
+
+// images exceeding the size limit are optimized only when the user opted in
+// from the options page
 fireAddNewMacroRequest = function(targetId, macroName, origURL, githubURL) {
+    chrome.storage.local.get(
+        ['optimize_images'],
+        catchAndLog(
+            function(items) {
+                sendAddNewMacroRequest(targetId, macroName, origURL, githubURL, items['optimize_images'] === true);
+            }
+        ),
+    );
+}
+
+sendAddNewMacroRequest = function(targetId, macroName, origURL, githubURL, optimize) {
     ajax({
         url: "https://us-central1-github-macros.cloudfunctions.net/add/",
         type: 'POST',
@@ -1007,6 +1197,7 @@ fireAddNewMacroRequest = function(targetId, macroName, origURL, githubURL) {
             name: macroName,
             url: origURL,
             github_url: githubURL,
+            optimize: optimize,
             version: gVersion,
         },
         success: function(responseText) {
@@ -1016,26 +1207,70 @@ fireAddNewMacroRequest = function(targetId, macroName, origURL, githubURL) {
                 return
             }
 
-            addNewMacroShowSuccessMessage(targetId);
-
-            const newMacro = response['data'];
-
-            macroNameToUrl.set(macroName, githubURL || origURL);
-
-            getElement(targetId, 'macroSearchInput').value = "";
-            getElement(targetId, 'macrosSection').scrollTop = 0;
-            
-            addMacroToUI(targetId, newMacro, false);
+            // the macro is added in the background, poll until the job is done
+            pollAddNewMacroStatus(
+                targetId, macroName, origURL, githubURL, response['job_id'], Date.now() + gAddStatusPollTimeoutMs,
+            );
         },
         fail: function() {
             addNewMacroShowErrorMessage(targetId, ErrorCodes.TransientError); 
         },
-        complete: function() {
-            addingNewMacro = false;
-        }
     })
 }
 
+const gNameAvailabilityDelayMs = 300;
+
+checkNameAvailability = function(targetId, macroName) {
+    const url = new URL('https://us-central1-github-macros.cloudfunctions.net/query/')
+    url.searchParams.append('type', 'available')
+    url.searchParams.append('text', macroName)
+
+    ajax({
+        url: url,
+        success: function(responseText) {
+            // the name was edited or submitted while the request was in flight
+            if (addingNewMacro || getElement(targetId, 'newMacroName').value !== macroName) {
+                return
+            }
+
+            const response = JSON.parse(responseText);
+            if (response['code'] != ErrorCodes.Success) {
+                addNewMacroShowErrorMessage(targetId, response['code'], response['suggestions']);
+            }
+        },
+    })
+}
+
+initNewMacroNameInput = function(targetId) {
+    const nameInput = getElement(targetId, 'newMacroName');
+
+    let inputTimerId = null;
+
+    nameInput.addEventListener('input', catchAndLog(function() {
+        if (inputTimerId != null) {
+            clearTimeout(inputTimerId);
+            inputTimerId = null;
+        }
+
+        if (addingNewMacro) {
+            return
+        }
+
+        getElement(targetId, 'addNewMacroError').style.display = 'none';
+
+        const macroName = nameInput.value;
+        if (macroName === '') {
+            return
+        }
+
+        // wait for the user to stop typing before checking the name
+        inputTimerId = setTimeout(
+            catchAndLog(() => checkNameAvailability(targetId, macroName)),
+            gNameAvailabilityDelayMs,
+        );
+    }));
+}
+
 validateImageBeforeAdd = function(targetId, macroName, origURL, githubURL) {
     if (!githubURL) {
         fireAddNewMacroRequest(targetId, macroName, origURL, githubURL);
@@ -1270,7 +1505,41 @@ updateTopUsages = function(macro) {
     chrome.storage.sync.set({'top_usages': JSON.stringify(macroTopUsages)});
 }
 
+// loads the favorites and recently used macros of the user, so they're shown before the suggestions
+loadPersonalMacros = function(onComplete) {
+    if (!gGithubToken) {
+        onComplete();
+        return
+    }
+
+    const url = new URL('https://us-central1-github-macros.cloudfunctions.net/query/')
+    url.searchParams.append('type', 'personal')
+
+    ajax({
+        url: url,
+        success: function(responseText) {
+            const response = JSON.parse(responseText);
+            if (response['code'] != ErrorCodes.Success) {
+                return
+            }
+
+            for (const name of response['favorites']) {
+                favoriteMacros.add(name);
+            }
+
+            updateCacheWithNewContent('', {'data': response['data']});
+        },
+        complete: function() {
+            onComplete();
+        },
+    });
+}
+
 loadSuggestionsFromStorage = function() {
+    loadPersonalMacros(loadSuggestionsFromStorageAfterPersonal);
+}
+
+loadSuggestionsFromStorageAfterPersonal = function() {
     chrome.storage.sync.get(
         ['suggestions', 'suggestions_freshness', 'top_usages'],
         catchAndLog(
@@ -1337,14 +1606,28 @@ checkIfClearCacheIsNeeded = function(onComplete) {
     );
 }
 
+loadGithubToken = function(onComplete) {
+    chrome.storage.local.get(
+        ['github_token'],
+        catchAndLog(
+            function(items) {
+                gGithubToken = items['github_token'] || '';
+                onComplete();
+            }
+        ),
+    );
+}
+
 window.onload = catchAndLog(
     function() {
         checkIfClearCacheIsNeeded(
-            () => {
-                initKeyboardListeners();
-                loadSuggestionsFromStorage()
-                processGithubMacroImages();
-            }
+            () => loadGithubToken(
+                () => {
+                    initKeyboardListeners();
+                    loadSuggestionsFromStorage()
+                    processGithubMacroImages();
+                }
+            )
         )
     },
 )
@@ -6,4 +6,25 @@ button.onclick = function() {
     'system_message': '',
     'top_usages': '',
   });
-}
\ No newline at end of file
+}
+
+const tokenInput = document.getElementById("githubTokenInput");
+chrome.storage.local.get(['github_token'], function(items) {
+  tokenInput.value = items['github_token'] || '';
+});
+
+const saveTokenButton = document.getElementById("saveGithubTokenButton");
+saveTokenButton.onclick = function() {
+  chrome.storage.local.set({'github_token': tokenInput.value.trim()});
+  // cached suggestions may hold macros of organizations the new token can't see
+  chrome.storage.sync.set({'suggestions': '', 'suggestions_freshness': ''});
+}
+
+const optimizeInput = document.getElementById("optimizeImagesInput");
+chrome.storage.local.get(['optimize_images'], function(items) {
+  optimizeInput.checked = items['optimize_images'] === true;
+});
+
+optimizeInput.onchange = function() {
+  chrome.storage.local.set({'optimize_images': optimizeInput.checked});
+}
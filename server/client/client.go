@@ -0,0 +1,202 @@
+// Package client is a Go SDK of the Github Macros API.
+//
+//	c := client.New(client.WithToken(os.Getenv("GITHUB_TOKEN")))
+//
+//	macro, err := c.Get(ctx, "lgtm")
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+//
+// Requests answered with TransientError are retried with exponential backoff,
+// as are failed queries.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL    = "https://us-central1-github-macros.cloudfunctions.net"
+	DefaultMaxRetries = 3
+
+	cDefaultTimeout  = time.Minute
+	cRetryBaseDelay  = 500 * time.Millisecond
+	cRetryMaxDelay   = 10 * time.Second
+	cMaxResponseSize = 10 * 1024 * 1024
+)
+
+// Client calls the API at BaseURL. Token is the GitHub token of the user,
+// it's only needed for organization macros and personal data.
+type Client struct {
+	BaseURL    string
+	Token      string
+	HTTPClient *http.Client
+	MaxRetries int
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL sets the URL the API functions are served under, for self
+// hosted servers.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		if baseURL != "" {
+			c.BaseURL = strings.TrimRight(baseURL, "/")
+		}
+	}
+}
+
+// WithToken sets the GitHub token requests are authenticated with.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.Token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client requests are sent with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.HTTPClient = httpClient
+	}
+}
+
+// WithMaxRetries sets how many times a transient failure is retried, zero
+// disables retries.
+func WithMaxRetries(maxRetries int) Option {
+	return func(c *Client) {
+		c.MaxRetries = maxRetries
+	}
+}
+
+// New returns a client of the public API, unless configured otherwise.
+func New(options ...Option) *Client {
+	c := &Client{
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{Timeout: cDefaultTimeout},
+		MaxRetries: DefaultMaxRetries,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+func (c *Client) endpoint(function string) string {
+	return c.BaseURL + "/" + function + "/"
+}
+
+// send sends a single request and returns the response body.
+func (c *Client) send(ctx context.Context, method, function string, params url.Values) ([]byte, error) {
+	var (
+		req *http.Request
+		err error
+	)
+
+	if method == http.MethodGet {
+		req, err = http.NewRequestWithContext(ctx, method, c.endpoint(function)+"?"+params.Encode(), http.NoBody)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, method, c.endpoint(function), strings.NewReader(params.Encode()))
+		if req != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=UTF-8")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Token != "" {
+		req.Header.Set("Authorization", "token "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, cMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{Path: req.URL.Path, StatusCode: resp.StatusCode}
+	}
+
+	return body, nil
+}
+
+// isRetryable reports whether the request should be sent again. Only
+// TransientError responses are retried for mutations, the server may have
+// applied a mutation that failed in any other way.
+func isRetryable(method string, body []byte, err error) bool {
+	if err == nil {
+		var response struct {
+			Code ErrorCode `json:"code"`
+		}
+
+		return json.Unmarshal(body, &response) == nil && response.Code == TransientError
+	}
+
+	if method != http.MethodGet || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return errors.Is(err, ErrTransient)
+	}
+
+	// network errors
+	return true
+}
+
+func retryDelay(attempt int) time.Duration {
+	delay := cRetryBaseDelay << uint(attempt)
+	if delay > cRetryMaxDelay || delay <= 0 {
+		return cRetryMaxDelay
+	}
+
+	return delay
+}
+
+// call sends the request, retrying transient failures with exponential
+// backoff, and returns the last response body.
+func (c *Client) call(ctx context.Context, method, function string, params url.Values) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.send(ctx, method, function, params)
+		if attempt >= c.MaxRetries || !isRetryable(method, body, err) {
+			return body, err
+		}
+
+		timer := time.NewTimer(retryDelay(attempt))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// callJSON sends the request and decodes the response into result.
+func (c *Client) callJSON(ctx context.Context, method, function string, params url.Values, result interface{}) error {
+	body, err := c.call(ctx, method, function, params)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,274 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers every request with the next response of its path, the
+// last one is repeated.
+type fakeServer struct {
+	mu        sync.Mutex
+	responses map[string][]fakeResponse
+	requests  []*http.Request
+}
+
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+func newFakeServer(t *testing.T, responses map[string][]fakeResponse) (*fakeServer, *Client) {
+	t.Helper()
+
+	fake := &fakeServer{responses: responses}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, r)
+		pending := fake.responses[r.URL.Path]
+
+		if len(pending) == 0 {
+			fake.mu.Unlock()
+			http.NotFound(w, r)
+
+			return
+		}
+
+		response := pending[0]
+		if len(pending) > 1 {
+			fake.responses[r.URL.Path] = pending[1:]
+		}
+		fake.mu.Unlock()
+
+		w.WriteHeader(response.status)
+		fmt.Fprint(w, response.body)
+	}))
+	t.Cleanup(server.Close)
+
+	return fake, New(WithBaseURL(server.URL+"/"), WithToken("secret"), WithMaxRetries(1))
+}
+
+func (f *fakeServer) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+
+	for _, r := range f.requests {
+		if r.URL.Path == path {
+			count++
+		}
+	}
+
+	return count
+}
+
+func ok(body string) fakeResponse {
+	return fakeResponse{status: http.StatusOK, body: body}
+}
+
+const cLGTM = `{"name":"lgtm","url":"https://example.com/lgtm.gif","width":10,"height":5}`
+
+func TestNew(t *testing.T) {
+	c := New()
+	if c.BaseURL != DefaultBaseURL || c.MaxRetries != DefaultMaxRetries || c.Token != "" {
+		t.Errorf("New() = %+v, want the public API without a token", c)
+	}
+
+	c = New(WithBaseURL(""), WithMaxRetries(0))
+	if c.BaseURL != DefaultBaseURL || c.MaxRetries != 0 {
+		t.Errorf("New(WithBaseURL(\"\")) = %+v, want the default base URL and no retries", c)
+	}
+
+	if got := New(WithBaseURL("http://localhost:8080//")).endpoint("query"); got != "http://localhost:8080/query/" {
+		t.Errorf("endpoint() = %q, want http://localhost:8080/query/", got)
+	}
+}
+
+func TestGet(t *testing.T) {
+	fake, c := newFakeServer(t, map[string][]fakeResponse{
+		"/query/": {ok(`{"code":0,"data":[` + cLGTM + `]}`), ok(`{"code":0,"data":[]}`), ok(`{"code":24,"data":[]}`)},
+	})
+	ctx := context.Background()
+
+	macro, err := c.Get(ctx, "lgtm")
+	if err != nil || macro.Name != "lgtm" || macro.Markdown() != "![github-macros-lgtm](https://example.com/lgtm.gif)" {
+		t.Fatalf("Get() = %+v, %v, want lgtm", macro, err)
+	}
+
+	request := fake.requests[0]
+	if request.Method != http.MethodGet || request.Form.Get("type") != "get" || request.Form.Get("text") != "lgtm" {
+		t.Errorf("Get() sent %s %s, want a get query of lgtm", request.Method, request.URL)
+	}
+
+	if got := request.Header.Get("Authorization"); got != "token secret" {
+		t.Errorf("Get() sent Authorization %q, want the token", got)
+	}
+
+	if _, err := c.Get(ctx, "lgtm"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of a missing macro = %v, want %v", err, ErrNotFound)
+	}
+
+	var apiErr *Error
+	if _, err := c.Get(ctx, "acme/lgtm"); !errors.As(err, &apiErr) || apiErr.Code != AuthenticationRequired {
+		t.Errorf("Get() answered with code 24 = %v, want %v", err, AuthenticationRequired)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	fake, c := newFakeServer(t, map[string][]fakeResponse{
+		"/query/":  {{status: http.StatusServiceUnavailable}, ok(`{"code":0,"data":[` + cLGTM + `]}`)},
+		"/report/": {{status: http.StatusServiceUnavailable}, ok("OK")},
+		"/usage/":  {{status: http.StatusBadRequest}},
+	})
+	ctx := context.Background()
+
+	// failed queries are retried
+	if _, err := c.Get(ctx, "lgtm"); err != nil || fake.count("/query/") != 2 {
+		t.Errorf("Get() = %v after %d requests, want success after 2", err, fake.count("/query/"))
+	}
+
+	// a mutation may have been applied, it's only retried when the server says so
+	if err := c.Report(ctx, "lgtm"); !errors.Is(err, ErrTransient) || fake.count("/report/") != 1 {
+		t.Errorf("Report() = %v after %d requests, want %v after 1", err, fake.count("/report/"), ErrTransient)
+	}
+
+	var httpErr *HTTPError
+	if err := c.RecordUsage(ctx, "lgtm", true); !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("RecordUsage() = %v, want status 400", err)
+	}
+
+	if usage := fake.requests[len(fake.requests)-1]; usage.Method != http.MethodPost || usage.PostForm.Get("trigger") != "direct" {
+		t.Errorf("RecordUsage() sent %s %v, want a direct usage", usage.Method, usage.PostForm)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	fake, c := newFakeServer(t, map[string][]fakeResponse{
+		"/add/": {ok(`{"code":9}`), ok(`{"code":0,"job_id":"job-1"}`)},
+		"/add_status/": {
+			ok(`{"code":0,"stage":"fetching"}`),
+			ok(`{"code":0,"done":true,"data":` + cLGTM + `,"original_size":200,"final_size":100}`),
+		},
+	})
+
+	result, err := c.Add(context.Background(), &AddRequest{Name: "lgtm", URL: "https://example.com/lgtm.gif", Optimize: true})
+	if err != nil {
+		t.Fatalf("Add() = %v", err)
+	}
+
+	if result.Macro.Name != "lgtm" || result.OriginalSize != 200 || result.FinalSize != 100 {
+		t.Errorf("Add() = %+v, want the optimized lgtm", result)
+	}
+
+	// the TransientError response was retried
+	if fake.count("/add/") != 2 || fake.count("/add_status/") != 2 {
+		t.Errorf("Add() sent %d adds and %d polls, want 2 and 2", fake.count("/add/"), fake.count("/add_status/"))
+	}
+
+	if form := fake.requests[0].PostForm; form.Get("optimize") != "true" || form.Get("alias_of") != "" {
+		t.Errorf("Add() sent %v, want optimize and no alias", form)
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	_, c := newFakeServer(t, map[string][]fakeResponse{
+		"/add/": {ok(`{"code":3,"suggestions":["lgtm2"]}`)},
+	})
+
+	_, err := c.Add(context.Background(), &AddRequest{Name: "lgtm", URL: "https://example.com/lgtm.gif"})
+
+	var apiErr *Error
+	if !errors.Is(err, ErrNameTaken) || !errors.As(err, &apiErr) || len(apiErr.Suggestions) != 1 {
+		t.Errorf("Add() of a taken name = %v, want %v with the suggestions", err, ErrNameTaken)
+	}
+}
+
+func TestSearchAll(t *testing.T) {
+	fake, c := newFakeServer(t, map[string][]fakeResponse{
+		"/query/": {
+			ok(`{"code":0,"data":[` + cLGTM + `,` + cLGTM + `],"has_more":true,"next_cursor":"c1"}`),
+			ok(`{"code":0,"data":[` + cLGTM + `],"has_more":false}`),
+		},
+	})
+	ctx := context.Background()
+	it := c.SearchAll("lg")
+
+	count := 0
+
+	for {
+		_, err := it.Next(ctx)
+		if errors.Is(err, Done) {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+
+		count++
+	}
+
+	if count != 3 || fake.count("/query/") != 2 {
+		t.Errorf("SearchAll() returned %d macros in %d pages, want 3 in 2", count, fake.count("/query/"))
+	}
+
+	if cursor := fake.requests[1].Form.Get("cursor"); cursor != "c1" {
+		t.Errorf("second page requested with cursor %q, want c1", cursor)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+	}{
+		{&Error{Code: NameIsReserved}, ErrInvalidName},
+		{&Error{Code: FileIsTooBig}, ErrInvalidImage},
+		{&Error{Code: CollectionNotFound}, ErrNotFound},
+		{&Error{Code: NamespaceAccessDenied}, ErrPermissionDenied},
+		{&Error{Code: TransientError}, ErrTransient},
+		{&HTTPError{StatusCode: http.StatusTooManyRequests}, ErrTransient},
+		{&HTTPError{StatusCode: http.StatusBadGateway}, ErrTransient},
+		{&HTTPError{StatusCode: http.StatusNotFound}, ErrNotFound},
+	}
+
+	for _, test := range tests {
+		if !errors.Is(test.err, test.kind) {
+			t.Errorf("errors.Is(%v, %v) = false", test.err, test.kind)
+		}
+	}
+
+	if errors.Is(&HTTPError{StatusCode: http.StatusBadRequest}, ErrTransient) {
+		t.Errorf("status 400 is transient")
+	}
+
+	if got := ErrorCode(99).String(); got != "error code 99" {
+		t.Errorf("ErrorCode(99).String() = %q", got)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{cRetryBaseDelay, 2 * cRetryBaseDelay, 4 * cRetryBaseDelay} {
+		if got := retryDelay(attempt); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	for _, attempt := range []int{10, 62, 100} {
+		if got := retryDelay(attempt); got != cRetryMaxDelay {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempt, got, cRetryMaxDelay)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is the numeric code the server responds with, the values match
+// the ones of the server package.
+type ErrorCode int
+
+const (
+	Success                       ErrorCode = 0
+	EmptyName                     ErrorCode = 1
+	NameContainsSpaces            ErrorCode = 2
+	NameAlreadyExist              ErrorCode = 3
+	EmptyURL                      ErrorCode = 4
+	InvalidURL                    ErrorCode = 5
+	URLHostnameNotSupported       ErrorCode = 6
+	FileIsTooBig                  ErrorCode = 7
+	FileFormatNotSupported        ErrorCode = 8
+	TransientError                ErrorCode = 9
+	MissingMandatoryFields        ErrorCode = 10
+	InfraFailure                  ErrorCode = 11
+	PermanentError                ErrorCode = 12
+	FileIsCorrupted               ErrorCode = 13
+	SVGContainsUnsafeContent      ErrorCode = 14
+	SimilarMacroExists            ErrorCode = 15
+	AliasTargetNotFound           ErrorCode = 16
+	JobNotFound                   ErrorCode = 17
+	NameContainsInvalidCharacters ErrorCode = 18
+	NameIsTooShort                ErrorCode = 19
+	NameIsTooLong                 ErrorCode = 20
+	NameIsReserved                ErrorCode = 21
+	NamespaceAccessDenied         ErrorCode = 22
+	InvalidNamespace              ErrorCode = 23
+	AuthenticationRequired        ErrorCode = 24
+	MacroNotFound                 ErrorCode = 25
+	CollectionNotFound            ErrorCode = 26
+	InvalidCollectionName         ErrorCode = 27
+	InvalidCollectionDescription  ErrorCode = 28
+	TooManyMacros                 ErrorCode = 29
+	InvalidRequest                ErrorCode = 30
+	ImageFetchFailed              ErrorCode = 31
+)
+
+var errorMessages = map[ErrorCode]string{
+	EmptyName:                     "name is empty",
+	NameContainsSpaces:            "name can't contain spaces",
+	NameAlreadyExist:              "name is already taken",
+	EmptyURL:                      "URL is empty",
+	InvalidURL:                    "URL is not valid",
+	URLHostnameNotSupported:       "URL hostname is not supported",
+	FileIsTooBig:                  "image is too big",
+	FileFormatNotSupported:        "URL is not a supported image",
+	TransientError:                "something went wrong, please try again later",
+	MissingMandatoryFields:        "mandatory fields are missing",
+	InfraFailure:                  "something went wrong, please try again later",
+	PermanentError:                "the request can't be completed",
+	FileIsCorrupted:               "image is corrupted or truncated",
+	SVGContainsUnsafeContent:      "SVG images with scripts or external references are not supported by this server",
+	SimilarMacroExists:            "this image already exists under a different name",
+	AliasTargetNotFound:           "the macro to alias doesn't exist",
+	JobNotFound:                   "the add job doesn't exist",
+	NameContainsInvalidCharacters: "name can only contain letters, digits, '_' and '-', and must start with a letter or a digit",
+	NameIsTooShort:                "name is too short",
+	NameIsTooLong:                 "name is too long",
+	NameIsReserved:                "name is reserved",
+	NamespaceAccessDenied:         "only members of the organization can use its macros",
+	InvalidNamespace:              "organization name is not valid",
+	AuthenticationRequired:        "a GitHub token is required",
+	MacroNotFound:                 "macro doesn't exist",
+	CollectionNotFound:            "collection doesn't exist",
+	InvalidCollectionName:         "collection name is not valid",
+	InvalidCollectionDescription:  "collection description is not valid",
+	TooManyMacros:                 "too many macros",
+	InvalidRequest:                "the request is malformed",
+	ImageFetchFailed:              "the image URL responded with an error",
+}
+
+func (c ErrorCode) String() string {
+	if message, ok := errorMessages[c]; ok {
+		return message
+	}
+
+	return fmt.Sprintf("error code %d", int(c))
+}
+
+// Error is returned when the server responds with a code other than Success.
+// Suggestions are the available names offered for NameAlreadyExist, Similar
+// the existing macros of SimilarMacroExists and SupportedFormats the image
+// formats listed with FileFormatNotSupported.
+type Error struct {
+	Code             ErrorCode
+	Suggestions      []string
+	Similar          []*Macro
+	SupportedFormats []string
+}
+
+func (e *Error) Error() string {
+	return e.Code.String()
+}
+
+// Is reports whether target is the kind of the error code.
+func (e *Error) Is(target error) bool {
+	return errorKinds[e.Code] == target
+}
+
+// HTTPError is returned when the server responds with a status other than
+// 200, server errors and throttling are transient.
+type HTTPError struct {
+	Path       string
+	StatusCode int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s failed: status %d", e.Path, e.StatusCode)
+}
+
+func (e *HTTPError) Is(target error) bool {
+	if target == ErrTransient {
+		return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+	}
+
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// The error kinds group the codes a caller usually handles the same way,
+// they are matched with errors.Is:
+//
+//	if errors.Is(err, client.ErrNameTaken) { ... }
+var (
+	ErrInvalidName      = errors.New("invalid macro name")
+	ErrNameTaken        = errors.New("macro name is taken")
+	ErrInvalidImage     = errors.New("invalid image")
+	ErrSimilarExists    = errors.New("similar macro exists")
+	ErrNotFound         = errors.New("not found")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrTransient        = errors.New("transient error")
+)
+
+var errorKinds = map[ErrorCode]error{
+	EmptyName:                     ErrInvalidName,
+	NameContainsSpaces:            ErrInvalidName,
+	NameContainsInvalidCharacters: ErrInvalidName,
+	NameIsTooShort:                ErrInvalidName,
+	NameIsTooLong:                 ErrInvalidName,
+	NameIsReserved:                ErrInvalidName,
+	InvalidNamespace:              ErrInvalidName,
+	NameAlreadyExist:              ErrNameTaken,
+	EmptyURL:                      ErrInvalidImage,
+	InvalidURL:                    ErrInvalidImage,
+	URLHostnameNotSupported:       ErrInvalidImage,
+	FileIsTooBig:                  ErrInvalidImage,
+	FileFormatNotSupported:        ErrInvalidImage,
+	FileIsCorrupted:               ErrInvalidImage,
+	SVGContainsUnsafeContent:      ErrInvalidImage,
+	ImageFetchFailed:              ErrInvalidImage,
+	SimilarMacroExists:            ErrSimilarExists,
+	AliasTargetNotFound:           ErrNotFound,
+	JobNotFound:                   ErrNotFound,
+	MacroNotFound:                 ErrNotFound,
+	CollectionNotFound:            ErrNotFound,
+	NamespaceAccessDenied:         ErrPermissionDenied,
+	AuthenticationRequired:        ErrPermissionDenied,
+	TransientError:                ErrTransient,
+}
@@ -0,0 +1,180 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	cQueryTypeSearch       = "search"
+	cQueryTypeGet          = "get"
+	cQueryTypeSuggestion   = "suggestion"
+	cQueryTypeAutocomplete = "autocomplete"
+	cUsageTriggerClick     = "click"
+	cUsageTriggerDirect    = "direct"
+	cAddPollInterval       = time.Second
+)
+
+// query runs a query of queryType and returns its page of results.
+func (c *Client) query(ctx context.Context, queryType, text string, page int, cursor string) (*Page, error) {
+	params := url.Values{
+		"type": {queryType},
+		"text": {text},
+		"page": {strconv.Itoa(page)},
+	}
+
+	if cursor != "" {
+		params.Set("cursor", cursor)
+	}
+
+	var result struct {
+		Page
+		Code ErrorCode `json:"code"`
+	}
+
+	if err := c.callJSON(ctx, http.MethodGet, "query", params, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Code != Success {
+		return nil, &Error{Code: result.Code}
+	}
+
+	return &result.Page, nil
+}
+
+// Search returns a page of the macros whose name contains text, most used first.
+func (c *Client) Search(ctx context.Context, text string, page int) (*Page, error) {
+	return c.query(ctx, cQueryTypeSearch, text, page, "")
+}
+
+// SearchAfter returns the page of the search following the page whose
+// NextCursor is cursor.
+func (c *Client) SearchAfter(ctx context.Context, text, cursor string) (*Page, error) {
+	return c.query(ctx, cQueryTypeSearch, text, 0, cursor)
+}
+
+// Suggestions returns a page of the most used macros.
+func (c *Client) Suggestions(ctx context.Context, page int) (*Page, error) {
+	return c.query(ctx, cQueryTypeSuggestion, "", page, "")
+}
+
+// SuggestionsAfter returns the page of the suggestions following the page
+// whose NextCursor is cursor.
+func (c *Client) SuggestionsAfter(ctx context.Context, cursor string) (*Page, error) {
+	return c.query(ctx, cQueryTypeSuggestion, "", 0, cursor)
+}
+
+// Autocomplete returns the most used macros whose name starts with prefix.
+// It's answered from an index in the server memory, so it's cheap enough to
+// call on every keystroke.
+func (c *Client) Autocomplete(ctx context.Context, prefix string) ([]*Macro, error) {
+	result, err := c.query(ctx, cQueryTypeAutocomplete, prefix, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Macros, nil
+}
+
+// Get returns the macro called name, an error of MacroNotFound if there's none.
+func (c *Client) Get(ctx context.Context, name string) (*Macro, error) {
+	result, err := c.query(ctx, cQueryTypeGet, name, 0, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Macros) == 0 {
+		return nil, &Error{Code: MacroNotFound}
+	}
+
+	return result.Macros[0], nil
+}
+
+func (r *AddRequest) form() url.Values {
+	form := url.Values{
+		"name": {r.Name},
+		"url":  {r.URL},
+	}
+
+	if r.GithubURL != "" {
+		form.Set("github_url", r.GithubURL)
+	}
+
+	if r.AliasOf != "" {
+		form.Set("alias_of", r.AliasOf)
+	}
+
+	if r.AllowSimilar {
+		form.Set("allow_similar", "true")
+	}
+
+	if r.Optimize {
+		form.Set("optimize", "true")
+	}
+
+	return form
+}
+
+// Add adds the macro and waits for the add job to be done.
+func (c *Client) Add(ctx context.Context, request *AddRequest) (*AddResult, error) {
+	var response addResponse
+	if err := c.callJSON(ctx, http.MethodPost, "add", request.form(), &response); err != nil {
+		return nil, err
+	}
+
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+
+	ticker := time.NewTicker(cAddPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		var status addResponse
+		if err := c.callJSON(ctx, http.MethodGet, "add_status", url.Values{"id": {response.JobID}}, &status); err != nil {
+			return nil, err
+		}
+
+		if err := status.err(); err != nil {
+			return nil, err
+		}
+
+		if status.Done {
+			return &AddResult{
+				Macro:        status.Data,
+				OriginalSize: status.OriginalSize,
+				FinalSize:    status.FinalSize,
+			}, nil
+		}
+	}
+}
+
+// RecordUsage counts a usage of the macro. direct is set when the macro was
+// typed by its name rather than picked.
+func (c *Client) RecordUsage(ctx context.Context, name string, direct bool) error {
+	trigger := cUsageTriggerClick
+	if direct {
+		trigger = cUsageTriggerDirect
+	}
+
+	_, err := c.call(ctx, http.MethodPost, "usage", url.Values{"name": {name}, "trigger": {trigger}})
+
+	return err
+}
+
+// Report reports that the image of the macro is broken.
+func (c *Client) Report(ctx context.Context, name string) error {
+	_, err := c.call(ctx, http.MethodPost, "report", url.Values{"name": {name}})
+
+	return err
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"errors"
+)
+
+// Done is returned by MacroIterator.Next when there are no more macros.
+var Done = errors.New("no more macros")
+
+// MacroIterator walks the results of a query page by page, following
+// next_cursor until the last page. Unlike page numbers, cursors don't skip
+// macros added between pages, though a macro whose usages change between pages
+// may be returned twice or not at all.
+type MacroIterator struct {
+	fetch   func(ctx context.Context, cursor string) (*Page, error)
+	macros  []*Macro
+	cursor  string
+	hasMore bool
+	err     error
+}
+
+func newMacroIterator(fetch func(ctx context.Context, cursor string) (*Page, error)) *MacroIterator {
+	return &MacroIterator{fetch: fetch, hasMore: true}
+}
+
+// Next returns the next macro, fetching the next page when the current one is
+// exhausted. It returns Done once all the macros were returned.
+func (it *MacroIterator) Next(ctx context.Context) (*Macro, error) {
+	for len(it.macros) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+
+		if !it.hasMore {
+			return nil, Done
+		}
+
+		page, err := it.fetch(ctx, it.cursor)
+		if err != nil {
+			it.err = err
+			return nil, err
+		}
+
+		it.macros = page.Macros
+		it.hasMore = page.HasMore && page.NextCursor != "" && page.NextCursor != it.cursor
+		it.cursor = page.NextCursor
+	}
+
+	macro := it.macros[0]
+	it.macros = it.macros[1:]
+
+	return macro, nil
+}
+
+// SearchAll iterates over all the macros whose name contains text.
+func (c *Client) SearchAll(text string) *MacroIterator {
+	return newMacroIterator(func(ctx context.Context, cursor string) (*Page, error) {
+		return c.SearchAfter(ctx, text, cursor)
+	})
+}
+
+// SuggestionsAll iterates over all the suggested macros, most used first.
+func (c *Client) SuggestionsAll() *MacroIterator {
+	return newMacroIterator(c.SuggestionsAfter)
+}
@@ -0,0 +1,88 @@
+package client
+
+import "fmt"
+
+// Macro is a macro as returned by the API, it mirrors the server MacroRow.
+// The thumbnail and the animated preview are optional smaller variants, their
+// URLs are empty when they weren't generated. LoopCount is 0 for animations
+// looping forever, -1 for ones played once and -2 for static images or when
+// it's unknown.
+type Macro struct {
+	Name            string `json:"name"`
+	URL             string `json:"url"`
+	URLSize         int64  `json:"url_size"`
+	Width           int64  `json:"width"`
+	Height          int64  `json:"height"`
+	GithubURL       string `json:"github_url"`
+	Frames          int64  `json:"frames"`
+	DurationMs      int64  `json:"duration_ms"`
+	LoopCount       int64  `json:"loop_count"`
+	ThumbnailURL    string `json:"thumbnail_url"`
+	ThumbnailWidth  int64  `json:"thumbnail_width"`
+	ThumbnailHeight int64  `json:"thumbnail_height"`
+	PreviewURL      string `json:"preview_url"`
+	PreviewWidth    int64  `json:"preview_width"`
+	PreviewHeight   int64  `json:"preview_height"`
+}
+
+// Markdown returns the markdown snippet of the macro, the same one the
+// extension inserts into comments.
+func (m *Macro) Markdown() string {
+	return fmt.Sprintf("![github-macros-%s](%s)", m.Name, m.URL)
+}
+
+// Page is a single page of search or suggestion results. NextPage is only
+// set when HasMore is.
+type Page struct {
+	Macros     []*Macro `json:"data"`
+	HasMore    bool     `json:"has_more"`
+	NextPage   int      `json:"next_page"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// AddRequest describes a macro to add. AliasOf makes the macro an alias of an
+// existing one, AllowSimilar adds it even if a similar image exists and
+// Optimize shrinks images exceeding the size limit instead of rejecting them.
+type AddRequest struct {
+	Name         string
+	URL          string
+	GithubURL    string
+	AliasOf      string
+	AllowSimilar bool
+	Optimize     bool
+}
+
+// AddResult is the macro that was added. OriginalSize and FinalSize are set
+// when the image was optimized.
+type AddResult struct {
+	Macro        *Macro
+	OriginalSize int64
+	FinalSize    int64
+}
+
+// addResponse is the response of add and add_status.
+type addResponse struct {
+	Code             ErrorCode `json:"code"`
+	JobID            string    `json:"job_id"`
+	Stage            string    `json:"stage"`
+	Done             bool      `json:"done"`
+	Data             *Macro    `json:"data"`
+	OriginalSize     int64     `json:"original_size"`
+	FinalSize        int64     `json:"final_size"`
+	Suggestions      []string  `json:"suggestions"`
+	Similar          []*Macro  `json:"similar"`
+	SupportedFormats []string  `json:"supported_formats"`
+}
+
+func (r *addResponse) err() error {
+	if r.Code == Success {
+		return nil
+	}
+
+	return &Error{
+		Code:             r.Code,
+		Suggestions:      r.Suggestions,
+		Similar:          r.Similar,
+		SupportedFormats: r.SupportedFormats,
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	cCacheDirName    = "ghm"
+	cDefaultCacheTTL = time.Hour
+)
+
+// cache keeps the API results on disk, one JSON file per request, so
+// repeated lookups of the same macro don't go over the network.
+type cache struct {
+	dir string
+	ttl time.Duration
+}
+
+// newCache returns the cache under the user cache directory, or nil when
+// there is none or ttl is zero, in which case nothing is cached.
+func newCache(ttl time.Duration) *cache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil
+	}
+
+	return &cache{dir: filepath.Join(userCacheDir, cCacheDirName), ttl: ttl}
+}
+
+func (c *cache) path(key ...string) string {
+	digest := sha256.Sum256([]byte(strings.Join(key, "\x00")))
+
+	return filepath.Join(c.dir, hex.EncodeToString(digest[:])+".json")
+}
+
+// load decodes the cached value of key into value, returning false when it
+// isn't cached or has expired.
+func (c *cache) load(value interface{}, key ...string) bool {
+	if c == nil {
+		return false
+	}
+
+	path := c.path(key...)
+
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > c.ttl {
+		return false
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	return json.Unmarshal(data, value) == nil
+}
+
+// store caches value under key. Failing to cache isn't an error, the next
+// lookup just goes to the API.
+func (c *cache) store(value interface{}, key ...string) {
+	if c == nil {
+		return
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return
+	}
+
+	_ = os.WriteFile(c.path(key...), data, 0o600)
+}
+
+// clear removes every cached result.
+func (c *cache) clear() error {
+	if c == nil {
+		return nil
+	}
+
+	return os.RemoveAll(c.dir)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	c := &cache{dir: filepath.Join(t.TempDir(), cCacheDirName), ttl: time.Hour}
+
+	var value []string
+	if c.load(&value, "get", "lgtm") {
+		t.Fatalf("load() of an empty cache succeeded")
+	}
+
+	c.store([]string{"lgtm"}, "get", "lgtm")
+
+	if !c.load(&value, "get", "lgtm") || len(value) != 1 || value[0] != "lgtm" {
+		t.Errorf("load() = %q, want the stored value", value)
+	}
+
+	// keys are hashed as a whole, "get" "lgtm" isn't "getl" "gtm"
+	if c.load(&value, "getl", "gtm") {
+		t.Errorf("load() of a different key succeeded")
+	}
+
+	expired := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(c.path("get", "lgtm"), expired, expired); err != nil {
+		t.Fatalf("failed to age the cached file: %v", err)
+	}
+
+	if c.load(&value, "get", "lgtm") {
+		t.Errorf("load() of an expired value succeeded")
+	}
+
+	if err := c.clear(); err != nil {
+		t.Fatalf("clear() = %v", err)
+	}
+
+	if _, err := os.Stat(c.dir); !os.IsNotExist(err) {
+		t.Errorf("clear() left %s behind: %v", c.dir, err)
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	if c := newCache(0); c != nil {
+		t.Fatalf("newCache(0) = %+v, want nil", c)
+	}
+
+	var c *cache
+
+	c.store("lgtm", "get", "lgtm")
+
+	var value string
+	if c.load(&value, "get", "lgtm") {
+		t.Errorf("load() of a nil cache succeeded")
+	}
+
+	if err := c.clear(); err != nil {
+		t.Errorf("clear() of a nil cache = %v", err)
+	}
+}
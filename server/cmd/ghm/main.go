@@ -0,0 +1,256 @@
+// Command ghm searches, adds and prints Github Macros from the command line.
+//
+// Usage:
+//
+//	ghm search <text> [--page N] [--markdown]
+//	ghm get <name> [--markdown]
+//	ghm add <name> <url>
+//	ghm report <name>
+//	ghm clear-cache
+//
+// The markdown snippets it prints can be passed to `gh pr comment --body`.
+// API errors exit with the numeric code the server responded with, usage
+// errors and any other failure exit with 125.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/avishail/github-macros/server/client"
+)
+
+const (
+	cBaseURLEnv  = "GHM_BASE_URL"
+	cTokenEnv    = "GITHUB_TOKEN"
+	cExitFailure = 125
+)
+
+const usage = `usage: ghm [flags] <command> [arguments]
+
+commands:
+  search <text> [--page N] [--markdown]   search macros by name
+  get <name> [--markdown]                 print a macro
+  add <name> <url>                        add a macro of the image at url
+  report <name>                           report that the image of a macro is broken
+  clear-cache                             remove the cached results
+
+flags:
+`
+
+type app struct {
+	client *client.Client
+	cache  *cache
+	stdout io.Writer
+}
+
+// parseArgs parses flags that may appear before, between or after the
+// positional arguments, so `ghm get lgtm --markdown` works.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (a *app) cacheKey(kind string, args ...string) []string {
+	return append([]string{a.client.BaseURL, a.client.Token, kind}, args...)
+}
+
+func (a *app) printMacro(macro *client.Macro, markdown bool) {
+	if markdown {
+		fmt.Fprintln(a.stdout, macro.Markdown())
+		return
+	}
+
+	fmt.Fprintf(a.stdout, "%s\t%s\t%dx%d\n", macro.Name, macro.URL, macro.Width, macro.Height)
+}
+
+func (a *app) search(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	page := flags.Int("page", 0, "page of the results")
+	markdown := flags.Bool("markdown", false, "print markdown snippets")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	var result *client.Page
+
+	key := a.cacheKey("search", positional[0], fmt.Sprint(*page))
+	if !a.cache.load(&result, key...) {
+		if result, err = a.client.Search(ctx, positional[0], *page); err != nil {
+			return err
+		}
+
+		a.cache.store(result, key...)
+	}
+
+	for _, macro := range result.Macros {
+		a.printMacro(macro, *markdown)
+	}
+
+	if result.HasMore {
+		fmt.Fprintf(os.Stderr, "more results with --page %d\n", result.NextPage)
+	}
+
+	return nil
+}
+
+func (a *app) get(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	markdown := flags.Bool("markdown", false, "print the markdown snippet")
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if len(positional) != 1 {
+		return errUsage
+	}
+
+	var macro *client.Macro
+
+	key := a.cacheKey("get", positional[0])
+	if !a.cache.load(&macro, key...) {
+		if macro, err = a.client.Get(ctx, positional[0]); err != nil {
+			return err
+		}
+
+		a.cache.store(macro, key...)
+	}
+
+	a.printMacro(macro, *markdown)
+
+	// a printed snippet is about to be pasted, count it like a pick in the extension
+	if *markdown {
+		if err := a.client.RecordUsage(ctx, macro.Name, false); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to record usage: %v\n", err)
+		}
+	}
+
+	return nil
+}
+
+func (a *app) add(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	result, err := a.client.Add(ctx, &client.AddRequest{Name: args[0], URL: args[1]})
+	if err != nil {
+		return err
+	}
+
+	macro := result.Macro
+
+	a.cache.store(macro, a.cacheKey("get", macro.Name)...)
+	a.printMacro(macro, true)
+
+	return nil
+}
+
+func (a *app) report(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	return a.client.Report(ctx, args[0])
+}
+
+var errUsage = errors.New("invalid arguments")
+
+func (a *app) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "search":
+		return a.search(ctx, args)
+	case "get":
+		return a.get(ctx, args)
+	case "add":
+		return a.add(ctx, args)
+	case "report":
+		return a.report(ctx, args)
+	case "clear-cache":
+		return a.cache.clear()
+	default:
+		return errUsage
+	}
+}
+
+// exitCode maps err to the exit status of the command.
+func exitCode(err error) int {
+	var apiErr *client.Error
+
+	switch {
+	case errors.As(err, &apiErr):
+		fmt.Fprintf(os.Stderr, "ghm: %v (code %d)\n", apiErr, int(apiErr.Code))
+
+		if len(apiErr.Suggestions) > 0 {
+			fmt.Fprintf(os.Stderr, "available names: %s\n", strings.Join(apiErr.Suggestions, ", "))
+		}
+
+		return int(apiErr.Code)
+	case errors.Is(err, errUsage), errors.Is(err, flag.ErrHelp):
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+
+		return cExitFailure
+	default:
+		fmt.Fprintf(os.Stderr, "ghm: %v\n", err)
+
+		return cExitFailure
+	}
+}
+
+func main() {
+	baseURL := flag.String("base-url", os.Getenv(cBaseURLEnv), "base URL of the API (env "+cBaseURLEnv+")")
+	token := flag.String("token", os.Getenv(cTokenEnv), "GitHub token, needed for organization macros (env "+cTokenEnv+")")
+	cacheTTL := flag.Duration("cache-ttl", cDefaultCacheTTL, "how long results are cached, 0 disables the cache")
+	timeout := flag.Duration("timeout", 2*time.Minute, "deadline of the whole command")
+
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(cExitFailure)
+	}
+
+	c := client.New(client.WithBaseURL(*baseURL), client.WithToken(*token))
+
+	a := &app{client: c, cache: newCache(*cacheTTL), stdout: os.Stdout}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	if err := a.run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		cancel()
+		os.Exit(exitCode(err))
+	}
+}
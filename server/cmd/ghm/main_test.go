@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/avishail/github-macros/server/client"
+)
+
+// fakeAPI serves the query and usage functions, counting the requests of
+// each.
+type fakeAPI struct {
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (f *fakeAPI) count(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.requests[path]
+}
+
+func newTestApp(t *testing.T, cacheTTL time.Duration) (*app, *fakeAPI, *bytes.Buffer) {
+	t.Helper()
+
+	fake := &fakeAPI{requests: map[string]int{}}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mu.Lock()
+		fake.requests[r.URL.Path]++
+		fake.mu.Unlock()
+
+		switch r.URL.Path {
+		case "/query/":
+			if r.URL.Query().Get("text") == "missing" {
+				fmt.Fprint(w, `{"code":0,"data":[]}`)
+				return
+			}
+
+			fmt.Fprint(w, `{"code":0,"data":[{"name":"lgtm","url":"https://example.com/lgtm.gif","width":10,"height":5}]}`)
+		case "/usage/", "/report/":
+			fmt.Fprint(w, `{"code":0}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	var stdout bytes.Buffer
+
+	a := &app{
+		client: client.New(client.WithBaseURL(server.URL), client.WithMaxRetries(0)),
+		stdout: &stdout,
+	}
+
+	if cacheTTL > 0 {
+		a.cache = &cache{dir: t.TempDir(), ttl: cacheTTL}
+	}
+
+	return a, fake, &stdout
+}
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args       []string
+		positional []string
+		page       int
+		markdown   bool
+	}{
+		{[]string{"lgtm"}, []string{"lgtm"}, 0, false},
+		{[]string{"lgtm", "--markdown"}, []string{"lgtm"}, 0, true},
+		{[]string{"--page", "2", "lgtm", "--markdown"}, []string{"lgtm"}, 2, true},
+		{[]string{"ship", "--page=3", "it"}, []string{"ship", "it"}, 3, false},
+		{[]string{}, nil, 0, false},
+	}
+
+	for _, test := range tests {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		page := flags.Int("page", 0, "")
+		markdown := flags.Bool("markdown", false, "")
+
+		positional, err := parseArgs(flags, test.args)
+		if err != nil {
+			t.Errorf("parseArgs(%q) = %v", test.args, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(positional, test.positional) || *page != test.page || *markdown != test.markdown {
+			t.Errorf("parseArgs(%q) = %q, page %d, markdown %v, want %q, page %d, markdown %v",
+				test.args, positional, *page, *markdown, test.positional, test.page, test.markdown)
+		}
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(&bytes.Buffer{})
+
+	if _, err := parseArgs(flags, []string{"lgtm", "--unknown"}); err == nil {
+		t.Errorf("parseArgs() with an unknown flag succeeded")
+	}
+}
+
+func TestGet(t *testing.T) {
+	a, fake, stdout := newTestApp(t, time.Hour)
+	ctx := context.Background()
+
+	if err := a.run(ctx, "get", []string{"lgtm"}); err != nil {
+		t.Fatalf("get = %v", err)
+	}
+
+	if got, want := stdout.String(), "lgtm\thttps://example.com/lgtm.gif\t10x5\n"; got != want {
+		t.Errorf("get printed %q, want %q", got, want)
+	}
+
+	stdout.Reset()
+
+	// the second lookup is answered from the cache, the printed snippet counts a usage
+	if err := a.run(ctx, "get", []string{"lgtm", "--markdown"}); err != nil {
+		t.Fatalf("get --markdown = %v", err)
+	}
+
+	if got, want := stdout.String(), "![github-macros-lgtm](https://example.com/lgtm.gif)\n"; got != want {
+		t.Errorf("get --markdown printed %q, want %q", got, want)
+	}
+
+	if fake.count("/query/") != 1 || fake.count("/usage/") != 1 {
+		t.Errorf("get sent %d queries and %d usages, want 1 and 1", fake.count("/query/"), fake.count("/usage/"))
+	}
+
+	if err := a.run(ctx, "get", []string{"missing"}); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("get of a missing macro = %v, want %v", err, client.ErrNotFound)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	a, fake, stdout := newTestApp(t, 0)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := a.run(ctx, "search", []string{"lg", "--page", "1"}); err != nil {
+			t.Fatalf("search = %v", err)
+		}
+	}
+
+	if got, want := stdout.String(), "lgtm\thttps://example.com/lgtm.gif\t10x5\n"; got != want+want {
+		t.Errorf("search printed %q, want %q twice", got, want)
+	}
+
+	// nothing is cached without a cache
+	if fake.count("/query/") != 2 {
+		t.Errorf("search sent %d queries, want 2", fake.count("/query/"))
+	}
+}
+
+func TestRunUsageErrors(t *testing.T) {
+	a, fake, _ := newTestApp(t, 0)
+	ctx := context.Background()
+
+	tests := []struct {
+		command string
+		args    []string
+	}{
+		{"search", nil},
+		{"search", []string{"a", "b"}},
+		{"get", nil},
+		{"add", []string{"lgtm"}},
+		{"report", nil},
+		{"remove", []string{"lgtm"}},
+	}
+
+	for _, test := range tests {
+		if err := a.run(ctx, test.command, test.args); !errors.Is(err, errUsage) {
+			t.Errorf("run(%s %q) = %v, want %v", test.command, test.args, err, errUsage)
+		}
+	}
+
+	if err := a.run(ctx, "report", []string{"lgtm"}); err != nil || fake.count("/report/") != 1 {
+		t.Errorf("report = %v with %d requests, want 1 request", err, fake.count("/report/"))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	stderr := os.Stderr
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", os.DevNull, err)
+	}
+
+	os.Stderr = devNull
+
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		devNull.Close()
+	})
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&client.Error{Code: client.NameAlreadyExist, Suggestions: []string{"lgtm2"}}, int(client.NameAlreadyExist)},
+		{fmt.Errorf("get: %w", &client.Error{Code: client.MacroNotFound}), int(client.MacroNotFound)},
+		{errUsage, cExitFailure},
+		{flag.ErrHelp, cExitFailure},
+		{errors.New("connection refused"), cExitFailure},
+	}
+
+	for _, test := range tests {
+		if got := exitCode(test.err); got != test.want {
+			t.Errorf("exitCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
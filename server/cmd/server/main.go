@@ -0,0 +1,138 @@
+// Command server is a self hosted Github Macros server. It serves the
+// functions of package p over HTTP under their Cloud Functions names, the v1
+// API under /api (and /v1), and the gRPC MacroService on a separate port.
+//
+// Configuration is read from the environment, or from a .env file:
+//
+//	PORT       - HTTP port (default 8080)
+//	GRPC_PORT  - gRPC port (default 9090)
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/avishail/github-macros/server/p"
+	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
+)
+
+const (
+	cDefaultHTTPPort = "8080"
+	cDefaultGRPCPort = "9090"
+	cShutdownTimeout = 30 * time.Second
+)
+
+// functions maps the Cloud Functions names to their handlers.
+var functions = map[string]http.HandlerFunc{
+	"add":          p.Add,
+	"add_status":   p.AddStatus,
+	"client_error": p.ClientError,
+	"collection":   p.Collection,
+	"expand":       p.Expand,
+	"query":        p.Query,
+	"report":       p.Report,
+	"star":         p.Star,
+	"unstar":       p.Unstar,
+	"usage":        p.Usage,
+	"webhook":      p.Webhook,
+}
+
+func getEnv(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+func newHTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	for name, handler := range functions {
+		// the extension calls the functions with a trailing slash
+		mux.Handle("/"+name, handler)
+		mux.Handle("/"+name+"/", handler)
+	}
+
+	mux.Handle("/api/", http.StripPrefix("/api", http.HandlerFunc(p.API)))
+	mux.HandleFunc("/v1/", p.API)
+	mux.HandleFunc("/openapi.json", p.API)
+
+	return mux
+}
+
+func main() {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("failed to load .env file: %v", err)
+	}
+
+	httpServer := &http.Server{
+		Addr:              ":" + getEnv("PORT", cDefaultHTTPPort),
+		Handler:           newHTTPHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	grpcListener, err := net.Listen("tcp", ":"+getEnv("GRPC_PORT", cDefaultGRPCPort))
+	if err != nil {
+		log.Fatalf("failed to listen for gRPC: %v", err)
+	}
+
+	grpcServer := p.NewGRPCServer()
+
+	go func() {
+		if err := p.LoadAutocompleteIndex(context.Background()); err != nil {
+			log.Printf("failed to load the autocomplete index: %v", err)
+		}
+	}()
+
+	go func() {
+		log.Printf("serving gRPC on %s", grpcListener.Addr())
+
+		if err := grpcServer.Serve(grpcListener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			log.Fatalf("gRPC server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		log.Printf("serving HTTP on %s", httpServer.Addr)
+
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	log.Printf("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), cShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down the HTTP server: %v", err)
+	}
+
+	grpcServer.GracefulStop()
+
+	if err := p.WaitForPendingJobs(ctx); err != nil {
+		log.Printf("failed to wait for pending add jobs: %v", err)
+	}
+
+	if err := p.WaitForPendingWebhooks(ctx); err != nil {
+		log.Printf("failed to wait for pending webhook deliveries: %v", err)
+	}
+
+	if err := p.FlushUsages(ctx); err != nil {
+		log.Printf("failed to flush usages: %v", err)
+	}
+}
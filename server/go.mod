@@ -4,8 +4,8 @@ go 1.16
 
 require (
 	cloud.google.com/go/bigquery v1.25.0
-	cloud.google.com/go/storage v1.18.2 // indirect
-	github.com/google/uuid v1.3.0 // indirect
+	cloud.google.com/go/storage v1.18.2
+	github.com/google/uuid v1.3.0
 	github.com/joho/godotenv v1.4.0
 	google.golang.org/api v0.63.0
 )
@@ -22,5 +22,6 @@ require (
 	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f // indirect
 	golang.org/x/sys v0.0.0-20211216021012-1d35b9e2eb4e // indirect
 	google.golang.org/genproto v0.0.0-20211223182754-3ac035c7e7cb // indirect
-	google.golang.org/grpc v1.43.0 // indirect
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,6 @@
+-- Animated media metadata recorded by Add. Existing rows keep NULL values and
+-- are read as a single static frame.
+ALTER TABLE `github-macros.macros.macros`
+  ADD COLUMN IF NOT EXISTS frames INT64,
+  ADD COLUMN IF NOT EXISTS duration_ms INT64,
+  ADD COLUMN IF NOT EXISTS loop_count INT64;
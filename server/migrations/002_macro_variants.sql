@@ -0,0 +1,9 @@
+-- Smaller variants of the macro media generated by Add, empty for macros
+-- added before they existed or when no media store is configured.
+ALTER TABLE `github-macros.macros.macros`
+  ADD COLUMN IF NOT EXISTS thumbnail_url STRING,
+  ADD COLUMN IF NOT EXISTS thumbnail_width INT64,
+  ADD COLUMN IF NOT EXISTS thumbnail_height INT64,
+  ADD COLUMN IF NOT EXISTS preview_url STRING,
+  ADD COLUMN IF NOT EXISTS preview_width INT64,
+  ADD COLUMN IF NOT EXISTS preview_height INT64;
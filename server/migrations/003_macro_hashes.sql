@@ -0,0 +1,5 @@
+-- Content hashes used by Add to detect duplicates. phash holds the 64 bits of
+-- the perceptual hash and is NULL for media that can't be rasterized.
+ALTER TABLE `github-macros.macros.macros`
+  ADD COLUMN IF NOT EXISTS sha256 STRING,
+  ADD COLUMN IF NOT EXISTS phash INT64;
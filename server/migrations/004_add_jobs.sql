@@ -0,0 +1,11 @@
+-- Status of the add jobs processed through Pub/Sub. Every stage change is
+-- appended as a new row, the latest row of a job is its current status.
+CREATE TABLE IF NOT EXISTS `github-macros.macros.add_jobs` (
+  id STRING NOT NULL,
+  stage STRING NOT NULL,
+  done BOOL NOT NULL,
+  response STRING,
+  update_time TIMESTAMP NOT NULL
+)
+PARTITION BY DATE(update_time)
+OPTIONS (partition_expiration_days = 7);
@@ -0,0 +1,5 @@
+-- Organization namespaces. Macros of a namespace are only visible to members
+-- of the GitHub organization and their name is prefixed by it ("org/name").
+-- Public macros keep a NULL namespace.
+ALTER TABLE `github-macros.macros.macros`
+  ADD COLUMN IF NOT EXISTS namespace STRING;
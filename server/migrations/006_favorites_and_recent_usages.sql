@@ -0,0 +1,13 @@
+-- Per user lists served by the personal query. Users are identified by their
+-- lower cased GitHub login and macros by their full "org/name" reference.
+CREATE TABLE IF NOT EXISTS `github-macros.macros.favorites` (
+  user_login STRING NOT NULL,
+  macro_name STRING NOT NULL,
+  create_time TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS `github-macros.macros.recent_usages` (
+  user_login STRING NOT NULL,
+  macro_name STRING NOT NULL,
+  use_time TIMESTAMP NOT NULL
+);
@@ -0,0 +1,23 @@
+-- Collections of macros. Collections are private to their owner until
+-- published, then anyone can subscribe to them.
+CREATE TABLE IF NOT EXISTS `github-macros.macros.collections` (
+  id STRING NOT NULL,
+  owner_login STRING NOT NULL,
+  name STRING NOT NULL,
+  description STRING,
+  published BOOL NOT NULL,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS `github-macros.macros.collection_macros` (
+  collection_id STRING NOT NULL,
+  macro_name STRING NOT NULL,
+  add_time TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS `github-macros.macros.collection_subscriptions` (
+  collection_id STRING NOT NULL,
+  user_login STRING NOT NULL,
+  create_time TIMESTAMP NOT NULL
+);
@@ -4,51 +4,28 @@ package p
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/google/uuid"
 )
 
-var supportedTypes = map[string]bool{
-	"image/gif":  true,
-	"image/png":  true,
-	"image/bmp":  true,
-	"image/jpeg": true,
-}
-
-const (
-	Success                 = 0
-	EmptyName               = 1
-	NameContainsSpaces      = 2
-	NameAlreadyExist        = 3
-	EmptyURL                = 4
-	InvalidURL              = 5
-	URLHostnameNotSupported = 6
-	FileIsTooBig            = 7
-	FileFormatNotSupported  = 8
-	TransientError          = 9
-	MissingMandatoryFields  = 10
-	InfraFailure            = 11
-	PermanentError          = 12
-)
-
-type ErrorCode = int
-
-func validateFileSizeAndType(fileSize int64, fileType string, maxSize int64) ErrorCode {
-	if fileSize > maxSize {
-		return FileIsTooBig
-	}
-
-	if _, ok := supportedTypes[fileType]; !ok {
-		return FileFormatNotSupported
-	}
-
-	return Success
+// addResult is the outcome of an add request. Similar is set along with
+// SimilarMacroExists and lists the existing macros with the same media.
+// OriginalSize and FinalSize are set when the media was optimized to fit
+// the size limit. Suggestions is set along with NameAlreadyExist and lists
+// available names similar to the requested one.
+type addResult struct {
+	Macro        *MacroRow
+	Similar      []*MacroRow
+	Suggestions  []string
+	OriginalSize int64
+	FinalSize    int64
 }
 
 func isGithubMedia(macroURL string) bool {
@@ -57,151 +34,344 @@ func isGithubMedia(macroURL string) bool {
 		return false
 	}
 
-	return strings.HasSuffix(u.Hostname(), "githubusercontent.com")
+	hostname := u.Hostname()
+
+	return hostname == "githubusercontent.com" || strings.HasSuffix(hostname, ".githubusercontent.com")
 }
 
-func executaAdd(r *http.Request) (*MacroRow, ErrorCode) {
-	ctx := context.Background()
+// addRequest is an add request going through the stages of the pipeline,
+// each stage fills in what the next ones need.
+type addRequest struct {
+	form      url.Values
+	name      string
+	namespace string
+	url       string
+	githubURL string
+	// fetched is replaced by the optimized or sanitized copy of the media,
+	// which is then hosted as generatedObject instead of the original one
+	fetched         *fetchedMedia
+	generatedObject string
+	decoded         *decodedImage
+	originalSize    int64
+	finalSize       int64
+}
 
-	client, err := bigquery.NewClient(ctx, "github-macros")
+// executaAdd runs the add pipeline for the request fields in form, calling
+// onStage whenever it moves to the next stage.
+func executaAdd(ctx context.Context, form url.Values, onStage func(stage string)) (*addResult, ErrorCode) {
+	client, err := newBigQueryClient(ctx)
 	if err != nil {
 		log.Panicf("failed to get bigquery client: %v", err)
 	}
 
 	defer client.Close()
 
-	macroName := r.Form.Get("name")
-	macroURL := r.Form.Get("url")
-	macroGithubURL := r.Form.Get("github_url")
+	namespace, _ := splitMacroReference(form.Get("name"))
+	request := &addRequest{
+		form:      form,
+		name:      form.Get("name"),
+		namespace: namespace,
+		url:       form.Get("url"),
+		githubURL: form.Get("github_url"),
+	}
+
+	onStage(cStageValidating)
+
+	if result, errCode, resolved := resolveAddRequest(ctx, client, request, onStage); resolved {
+		return result, errCode
+	}
 
-	if errCode := staticNameAndURLValidation(macroName, macroURL); errCode != Success {
+	if errCode := fetchAddedMedia(ctx, request, onStage); errCode != Success {
 		return nil, errCode
 	}
 
-	isExist, sameURLMacro := queryExistingMacroMetadata(macroName, macroURL, client)
+	if result, errCode := decodeAddedMedia(ctx, client, request, onStage); errCode != Success {
+		return result, errCode
+	}
 
-	if isExist {
-		return nil, NameAlreadyExist
+	if errCode := hostAddedMedia(ctx, client, request, onStage); errCode != Success {
+		return nil, errCode
 	}
 
-	if sameURLMacro != nil {
-		newMacro := duplicateExistingMacro(client, macroName, sameURLMacro)
-		return newMacro, Success
+	return saveAddedMacro(ctx, client, request, onStage), Success
+}
+
+// resolveAddRequest validates the request and completes the requests that
+// don't need the media: taken names, URLs of existing macros and aliases. It
+// returns true when the request is complete.
+func resolveAddRequest(
+	ctx context.Context,
+	client *bigquery.Client,
+	request *addRequest,
+	onStage func(stage string),
+) (*addResult, ErrorCode, bool) {
+	if errCode := staticNameAndURLValidation(request.name, request.url); errCode != Success {
+		return nil, errCode, true
 	}
 
-	isMacroURLGithubMedia := isGithubMedia(macroURL)
+	request.name = macroReference(splitMacroReference(request.name))
+
+	isExist, sameURLMacro := queryExistingMacroMetadata(ctx, request.name, request.url, client)
 
-	if isMacroURLGithubMedia {
-		macroGithubURL = macroURL
+	if isExist {
+		return &addResult{Suggestions: suggestAvailableNames(ctx, client, request.name)}, NameAlreadyExist, true
 	}
 
-	if !isMacroURLGithubMedia && !isGithubMedia(macroGithubURL) {
-		macroGithubURL, err = GetGithubImage(client, macroURL)
-		if err != nil {
-			log.Panicf("failed to get github image: %v", err)
-		}
+	if sameURLMacro != nil {
+		onStage(cStageSaving)
+
+		return &addResult{Macro: duplicateExistingMacro(ctx, client, request.name, sameURLMacro)}, Success, true
 	}
 
-	fileSize, fileType, errCode := getFileSizeAndType(macroGithubURL)
-	if errCode != Success {
-		return nil, errCode
+	aliasOf := request.form.Get("alias_of")
+	if aliasOf == "" {
+		return nil, Success, false
 	}
 
-	if errCode := validateFileSizeAndType(fileSize, fileType, cFileMaxSize); errCode != Success {
-		return nil, errCode
+	// only public macros and macros of the same namespace can be aliased
+	aliasNamespace, _ := splitMacroReference(aliasOf)
+	if aliasNamespace != "" && aliasNamespace != request.namespace {
+		return nil, AliasTargetNotFound, true
 	}
 
-	width, height := getMacroDimensions(macroGithubURL)
+	aliasTarget := queryMacroByName(ctx, client, macroReference(splitMacroReference(aliasOf)))
+	if aliasTarget == nil {
+		return nil, AliasTargetNotFound, true
+	}
 
-	insertNewMacro(client, macroName, macroURL, macroGithubURL, fileSize, width, height)
+	onStage(cStageSaving)
 
-	return &MacroRow{
-		Name:   macroName,
-		URL:    macroGithubURL,
-		Width:  width,
-		Height: height,
-	}, Success
+	return &addResult{Macro: duplicateExistingMacro(ctx, client, request.name, aliasTarget)}, Success, true
 }
 
-func getFileSizeAndType(macroURL string) (fileSize int64, fileType string, errCode ErrorCode) {
-	req, err := http.NewRequestWithContext(context.Background(), "GET", macroURL, http.NoBody)
-
-	if err != nil {
-		return 0, "", InvalidURL
+// fetchAddedMedia downloads the media, optimizing it when it exceeds the size
+// limit and the request allows it.
+func fetchAddedMedia(ctx context.Context, request *addRequest, onStage func(stage string)) ErrorCode {
+	if isGithubMedia(request.url) {
+		request.githubURL = request.url
 	}
 
-	req.Header.Add("Range", "bytes=0-512")
+	// the image is only uploaded to GitHub once it passed every check
+	fetchURL := request.url
+	if isGithubMedia(request.githubURL) {
+		fetchURL = request.githubURL
+	}
 
-	var client http.Client
+	onStage(cStageFetching)
 
-	resp, err := client.Do(req)
+	// oversized images are only downloaded in full when they can be optimized,
+	// optimized copies are public so macros of organizations aren't optimized
+	maxFetchSize := int64(cFileMaxSize)
+	if request.form.Get("optimize") == "true" && getMediaStore() != nil && request.namespace == "" {
+		maxFetchSize = cOptimizeMaxInputSize
+	}
 
+	fetched, err := fetchMedia(ctx, fetchURL, maxFetchSize)
 	if err != nil {
-		return 0, "", InvalidURL
+		return getMediaErrorCode(err)
 	}
 
-	fileSize = getFileSize(resp)
-	if fileSize > cFileMaxSize {
-		return 0, "", FileIsTooBig
+	request.fetched = fetched
+
+	if len(fetched.Data) <= cFileMaxSize {
+		return Success
 	}
 
-	body := make([]byte, 512)
-	_, err = resp.Body.Read(body)
+	onStage(cStageOptimizing)
 
-	if err != nil {
-		return 0, "", InvalidURL
+	request.originalSize = int64(len(fetched.Data))
+
+	optimized, generatedObject, errCode := optimizeOversizedMedia(fetched)
+	if errCode != Success {
+		return errCode
 	}
 
-	fileType = http.DetectContentType(body)
+	request.fetched = optimized
+	request.generatedObject = generatedObject
+	request.finalSize = int64(len(optimized.Data))
 
-	return fileSize, fileType, Success
+	return Success
 }
 
-func staticNameAndURLValidation(macroName, macroURL string) ErrorCode {
-	if macroName == "" {
-		return EmptyName
+// decodeAddedMedia decodes the media, replaces unsafe SVGs by their sanitized
+// copy and looks for macros with the same image.
+func decodeAddedMedia(
+	ctx context.Context,
+	client *bigquery.Client,
+	request *addRequest,
+	onStage func(stage string),
+) (*addResult, ErrorCode) {
+	onStage(cStageDecoding)
+
+	decoded, err := decodeFetchedMedia(request.fetched)
+	if err != nil {
+		return nil, getMediaErrorCode(err)
 	}
 
-	if macroURL == "" {
-		return EmptyURL
+	request.decoded = decoded
+
+	if decoded.Sanitized != nil {
+		// the sanitized copy is public, like optimized ones
+		if getMediaStore() == nil || request.namespace != "" {
+			return nil, SVGContainsUnsafeContent
+		}
+
+		digest := sha256Hex(decoded.Sanitized)
+		request.fetched = &fetchedMedia{Data: decoded.Sanitized, MediaType: cMediaTypeSVG, SHA256: digest}
+		request.generatedObject = fmt.Sprintf("sanitized/%s.svg", digest)
+		decoded.SHA256 = digest
 	}
 
-	if strings.Contains(macroName, " ") {
-		return NameContainsSpaces
+	if request.form.Get("allow_similar") != "true" {
+		onStage(cStageDeduplicating)
+
+		if similar := querySimilarMacros(ctx, client, request.namespace, decoded); len(similar) > 0 {
+			return &addResult{Similar: similar}, SimilarMacroExists
+		}
 	}
 
-	return Success
+	return nil, Success
 }
 
-func getFileSize(r *http.Response) int64 {
-	if r.Header.Get("Content-Range") != "" {
-		parts := strings.Split(r.Header.Get("Content-Range"), "/")
-		if len(parts) == 2 {
-			size, err := strconv.ParseInt(parts[1], 10, 64)
-			if err == nil {
-				return size
+// hostAddedMedia sets the GitHub URL the media is served from, uploading it
+// to the public gist unless it's already hosted by GitHub.
+func hostAddedMedia(ctx context.Context, client *bigquery.Client, request *addRequest, onStage func(stage string)) ErrorCode {
+	switch {
+	case request.namespace != "":
+		// media of organization macros isn't posted to the public gist, it's
+		// referenced where the caller uploaded it
+		if !isGithubMedia(request.githubURL) {
+			request.githubURL = request.url
+		}
+	case request.generatedObject != "":
+		onStage(cStageUploading)
+
+		githubURL, errCode := hostMediaCopy(
+			ctx, client, getMediaStore(), request.generatedObject, request.fetched.MediaType, request.fetched.Data,
+		)
+		if errCode != Success {
+			return errCode
+		}
+
+		request.githubURL = githubURL
+	case !isGithubMedia(request.githubURL):
+		onStage(cStageUploading)
+
+		githubURL, err := GetGithubImage(ctx, client, request.url)
+		if err != nil {
+			if isTimeoutOrCanceled(err) {
+				return TransientError
 			}
+
+			log.Panicf("failed to get github image: %v", err)
 		}
+
+		request.githubURL = githubURL
 	}
 
-	if r.Header.Get("Content-Length") != "" {
-		size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
-		if err == nil {
-			if size > r.ContentLength {
-				return size
-			}
+	return Success
+}
+
+// saveAddedMacro generates the variants of the media and inserts the macro.
+func saveAddedMacro(ctx context.Context, client *bigquery.Client, request *addRequest, onStage func(stage string)) *addResult {
+	decoded := request.decoded
+
+	newMacro := &MacroRow{
+		Name:       request.name,
+		URL:        request.url,
+		URLSize:    int64(len(request.fetched.Data)),
+		Width:      decoded.Width,
+		Height:     decoded.Height,
+		GithubURL:  request.githubURL,
+		Frames:     decoded.Frames,
+		DurationMs: decoded.DurationMs,
+		LoopCount:  decoded.LoopCount,
+		SHA256:     decoded.SHA256,
+		PHash:      bigquery.NullInt64{Int64: decoded.PHash, Valid: decoded.HasPHash},
+	}
 
-			return r.ContentLength
+	onStage(cStageGeneratingVariants)
+
+	setMacroVariants(newMacro, generateVariants(ctx, getNamespaceMediaStore(request.namespace), request.githubURL, decoded))
+
+	onStage(cStageSaving)
+
+	insertNewMacro(ctx, client, newMacro)
+
+	response := *newMacro
+	response.URL = request.githubURL
+
+	return &addResult{Macro: &response, OriginalSize: request.originalSize, FinalSize: request.finalSize}
+}
+
+// hostMediaCopy stores a copy of the media generated by the server in the
+// media store and returns the GitHub URL it's served from.
+func hostMediaCopy(
+	ctx context.Context,
+	client *bigquery.Client,
+	store mediaStore,
+	objectName, contentType string,
+	data []byte,
+) (string, ErrorCode) {
+	storedURL, err := store.Put(ctx, objectName, contentType, data)
+	if err != nil {
+		log.Panicf("failed to store %s: %v", objectName, err)
+	}
+
+	githubURL, err := GetGithubImage(ctx, client, storedURL)
+	if err != nil {
+		if isTimeoutOrCanceled(err) {
+			return "", TransientError
 		}
+
+		log.Panicf("failed to get github image: %v", err)
+	}
+
+	return githubURL, Success
+}
+
+func setMacroVariants(macro *MacroRow, variants *macroVariants) {
+	if variants.Thumbnail != nil {
+		macro.ThumbnailURL = variants.Thumbnail.URL
+		macro.ThumbnailWidth = variants.Thumbnail.Width
+		macro.ThumbnailHeight = variants.Thumbnail.Height
+	}
+
+	if variants.Preview != nil {
+		macro.PreviewURL = variants.Preview.URL
+		macro.PreviewWidth = variants.Preview.Width
+		macro.PreviewHeight = variants.Preview.Height
 	}
+}
 
-	return r.ContentLength
+func staticNameAndURLValidation(macroName, macroURL string) ErrorCode {
+	if macroName == "" {
+		return EmptyName
+	}
+
+	if macroURL == "" {
+		return EmptyURL
+	}
+
+	return validateMacroReference(macroName)
 }
 
-func queryExistingMacroMetadata(macroName, macroURL string, client *bigquery.Client) (bool, *MacroRow) {
-	query := client.Query(
-		"SELECT * FROM github-macros.macros.macros WHERE name=@name OR url=@url",
-	)
+func queryExistingMacroMetadata(ctx context.Context, macroName, macroURL string, client *bigquery.Client) (bool, *MacroRow) {
+	query := client.Query(`
+		SELECT
+			name,
+			url,
+			url_size,
+			width,
+			height,
+			github_url,
+			` + cMacroMediaColumns + `,
+			IFNULL(sha256, '') AS sha256,
+			phash
+		FROM github-macros.macros.macros
+		WHERE LOWER(name)=LOWER(@name) OR (url=@url AND ` + cVisibleMacrosFilter + `)
+	`)
+	namespace, _ := splitMacroReference(macroName)
 	query.Parameters = []bigquery.QueryParameter{
 		{
 			Name:  "name",
@@ -211,14 +381,18 @@ func queryExistingMacroMetadata(macroName, macroURL string, client *bigquery.Cli
 			Name:  "url",
 			Value: macroURL,
 		},
+		{
+			Name:  "namespaces",
+			Value: []string{namespace},
+		},
 	}
 
-	results := getQueryResults(query)
+	results := getQueryResults(ctx, query)
 
 	var sameURL *MacroRow
 
 	for _, res := range results {
-		if res.Name == macroName {
+		if strings.EqualFold(res.Name, macroName) {
 			return true, nil
 		}
 
@@ -230,31 +404,102 @@ func queryExistingMacroMetadata(macroName, macroURL string, client *bigquery.Cli
 	return false, sameURL
 }
 
-func Add(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+func queryMacroByName(ctx context.Context, client *bigquery.Client, macroName string) *MacroRow {
+	query := client.Query(`
+		SELECT
+			name,
+			url,
+			url_size,
+			width,
+			height,
+			github_url,
+			` + cMacroMediaColumns + `,
+			IFNULL(sha256, '') AS sha256,
+			phash
+		FROM github-macros.macros.macros
+		WHERE name=@name
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "name",
+			Value: macroName,
+		},
+	}
 
-	if err := r.ParseForm(); err != nil {
-		log.Panicf("error while parsing form: %v", err)
+	results := getQueryResults(ctx, query)
+	if len(results) == 0 {
+		return nil
 	}
 
-	newMacro, errCode := executaAdd(r)
+	return results[0]
+}
 
-	var (
-		response string
-		err      error
-	)
+// querySimilarMacros returns the macros visible from namespace with exactly
+// the same bytes or with a perceptual hash close enough to be considered the
+// same image, closest first.
+func querySimilarMacros(ctx context.Context, client *bigquery.Client, namespace string, decoded *decodedImage) []*MacroRow {
+	query := client.Query(`
+		SELECT
+			name,
+			github_url AS url,
+			width,
+			height,
+			` + cMacroMediaColumns + `
+		FROM github-macros.macros.macros
+		WHERE
+			` + cVisibleMacrosFilter + `
+			AND (
+				sha256 = @sha256
+				OR (@has_phash AND phash IS NOT NULL AND BIT_COUNT(phash ^ @phash) <= @threshold)
+			)
+		ORDER BY IF(sha256 = @sha256, 0, 1 + BIT_COUNT(phash ^ @phash)), name
+		LIMIT @limit
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "sha256",
+			Value: decoded.SHA256,
+		},
+		{
+			Name:  "has_phash",
+			Value: decoded.HasPHash,
+		},
+		{
+			Name:  "phash",
+			Value: decoded.PHash,
+		},
+		{
+			Name:  "threshold",
+			Value: getPHashThreshold(),
+		},
+		{
+			Name:  "limit",
+			Value: cMaxSimilarMacros,
+		},
+		{
+			Name:  "namespaces",
+			Value: []string{namespace},
+		},
+	}
 
-	if errCode != Success {
-		response, err = getErrorCodeResponse(errCode)
-	} else {
-		response, err = getRespons(
-			map[string]interface{}{
-				"code": Success,
-				"data": *newMacro,
-			},
-		)
+	return getQueryResults(ctx, query)
+}
+
+// Add validates the request and enqueues an add job, returning its ID right
+// away. The job progress and result are available through AddStatus.
+func Add(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
 	}
 
+	if err := r.ParseForm(); err != nil {
+		log.Panicf("error while parsing form: %v", err)
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	response, err := enqueueAddJob(ctx, r.Form, getCallerToken(r))
 	if err != nil {
 		log.Panicf("failed to create response %v", err)
 	}
@@ -266,25 +511,118 @@ func Add(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func getMacroDimensions(macroURL string) (width, height int64) {
-	config, err := getImageConfig(macroURL)
-	if err != nil {
-		log.Panicf("getMacroDimensions: %v", err)
+// getMediaErrorCode maps an error of fetching or decoding an image to the
+// error code returned to the client.
+func getMediaErrorCode(err error) ErrorCode {
+	switch {
+	case errors.Is(err, errFileTooBig), errors.Is(err, errImageTooLarge):
+		return FileIsTooBig
+	case errors.Is(err, errImageIsCorrupted):
+		return FileIsCorrupted
+	case errors.Is(err, errUnsupportedImage):
+		return FileFormatNotSupported
+	case isFetchBlockedError(err):
+		return URLHostnameNotSupported
+	case isTimeoutOrCanceled(err), isTransientFetchStatusError(err):
+		return TransientError
+	case isFetchStatusError(err):
+		return ImageFetchFailed
 	}
 
-	return int64(config.Width), int64(config.Height)
+	log.Printf("failed to fetch image: %v", err)
+
+	return InvalidURL
 }
 
-func getErrorCodeResponse(errCode ErrorCode) (string, error) {
-	return getRespons(
-		map[string]interface{}{
-			"code": errCode,
-		},
-	)
+// submitAddJob verifies the caller may add to the macro namespace before
+// queuing the job, so the token never leaves the request.
+func submitAddJob(ctx context.Context, form url.Values, token string) (string, ErrorCode) {
+	if errCode := staticNameAndURLValidation(form.Get("name"), form.Get("url")); errCode != Success {
+		return "", errCode
+	}
+
+	namespace, _ := splitMacroReference(form.Get("name"))
+
+	if errCode := checkNamespaceAccess(ctx, token, namespace); errCode != Success {
+		return "", errCode
+	}
+
+	job := &addJob{ID: uuid.NewString(), Form: form}
+	queue, store := getJobBackend()
+
+	if err := store.Save(ctx, &addJobStatus{ID: job.ID, Stage: cStageQueued}); err != nil {
+		log.Panicf("failed to save job %s: %v", job.ID, err)
+	}
+
+	if err := queue.Enqueue(ctx, job); err != nil {
+		log.Printf("failed to enqueue job %s: %v", job.ID, err)
+
+		// the job will never run, its status reports the failure
+		failed := &addJobStatus{ID: job.ID, Stage: cStageDone, Done: true, Response: getAddResponse(nil, TransientError)}
+		if err := store.Save(ctx, failed); err != nil {
+			log.Printf("failed to save result of job %s: %v", job.ID, err)
+		}
+
+		return "", TransientError
+	}
+
+	return job.ID, Success
+}
+
+// enqueueAddJob queues the add job and returns the response of Add.
+func enqueueAddJob(ctx context.Context, form url.Values, token string) (string, error) {
+	jobID, errCode := submitAddJob(ctx, form, token)
+	if errCode != Success {
+		return getRespons(getAddResponse(nil, errCode))
+	}
+
+	return getRespons(&addJobResponse{Code: Success, JobID: jobID, Stage: cStageQueued})
+}
+
+// addJobResponse is the response of a queued add job.
+type addJobResponse struct {
+	Code  ErrorCode `json:"code"`
+	JobID string    `json:"job_id,omitempty"`
+	Stage string    `json:"stage,omitempty"`
 }
 
-func getRespons(responseMap map[string]interface{}) (string, error) {
-	response, err := json.Marshal(responseMap)
+// addResponse is the outcome of an add job. Depending on the code, it holds
+// the new macro, the supported formats, the similar macros or the available
+// names. OriginalSize and FinalSize are set when the image was optimized.
+type addResponse struct {
+	Code             ErrorCode   `json:"code"`
+	Data             *MacroRow   `json:"data,omitempty"`
+	OriginalSize     int64       `json:"original_size,omitempty"`
+	FinalSize        int64       `json:"final_size,omitempty"`
+	SupportedFormats []string    `json:"supported_formats,omitempty"`
+	Similar          []*MacroRow `json:"similar,omitempty"`
+	Suggestions      []string    `json:"suggestions,omitempty"`
+}
+
+func getAddResponse(result *addResult, errCode ErrorCode) *addResponse {
+	response := &addResponse{Code: errCode}
+
+	switch errCode {
+	case Success:
+		response.Data = result.Macro
+
+		if result.OriginalSize > 0 {
+			response.OriginalSize = result.OriginalSize
+			response.FinalSize = result.FinalSize
+		}
+	case FileFormatNotSupported:
+		response.SupportedFormats = supportedFormats
+	case SimilarMacroExists:
+		response.Similar = result.Similar
+	case NameAlreadyExist:
+		response.Suggestions = result.Suggestions
+	}
+
+	return response
+}
+
+func getRespons(responseValue interface{}) (string, error) {
+	response, err := json.Marshal(responseValue)
 
 	if err != nil {
 		return "", fmt.Errorf("error while marshaling response: %v", err)
@@ -293,61 +631,112 @@ func getRespons(responseMap map[string]interface{}) (string, error) {
 	return string(response), nil
 }
 
-func insertNewMacro(
-	client *bigquery.Client,
-	macroName, macroURL, macroGithubURL string,
-	macroSize, width, height int64,
-) {
+// insertNewMacro stores the macro, in the namespace its name is prefixed by.
+func insertNewMacro(ctx context.Context, client *bigquery.Client, macro *MacroRow) {
+	namespace, _ := splitMacroReference(macro.Name)
+
 	query := client.Query(`
 		INSERT INTO github-macros.macros.macros 
-		(name, url, github_url, url_size, width, height)
-		VALUES (@name, @url, @github_url, @url_size, @width, @height)
+		(
+			name, url, github_url, url_size, width, height, frames, duration_ms, loop_count,
+			thumbnail_url, thumbnail_width, thumbnail_height, preview_url, preview_width, preview_height,
+			sha256, phash, namespace
+		)
+		VALUES (
+			@name, @url, @github_url, @url_size, @width, @height, @frames, @duration_ms, @loop_count,
+			@thumbnail_url, @thumbnail_width, @thumbnail_height, @preview_url, @preview_width, @preview_height,
+			@sha256, @phash, @namespace
+		)
 	`)
 	query.Parameters = []bigquery.QueryParameter{
 		{
 			Name:  "name",
-			Value: macroName,
+			Value: macro.Name,
 		},
 		{
 			Name:  "url",
-			Value: macroURL,
+			Value: macro.URL,
 		},
 		{
 			Name:  "github_url",
-			Value: macroGithubURL,
+			Value: macro.GithubURL,
 		},
 		{
 			Name:  "url_size",
-			Value: macroSize,
+			Value: macro.URLSize,
 		},
 		{
 			Name:  "width",
-			Value: width,
+			Value: macro.Width,
 		},
 		{
 			Name:  "height",
-			Value: height,
+			Value: macro.Height,
+		},
+		{
+			Name:  "frames",
+			Value: macro.Frames,
+		},
+		{
+			Name:  "duration_ms",
+			Value: macro.DurationMs,
+		},
+		{
+			Name: "loop_count",
+			// static images don't have a loop count
+			Value: bigquery.NullInt64{Int64: macro.LoopCount, Valid: macro.LoopCount != cLoopCountNotAnimated},
+		},
+		{
+			Name:  "thumbnail_url",
+			Value: macro.ThumbnailURL,
+		},
+		{
+			Name:  "thumbnail_width",
+			Value: macro.ThumbnailWidth,
+		},
+		{
+			Name:  "thumbnail_height",
+			Value: macro.ThumbnailHeight,
+		},
+		{
+			Name:  "preview_url",
+			Value: macro.PreviewURL,
+		},
+		{
+			Name:  "preview_width",
+			Value: macro.PreviewWidth,
+		},
+		{
+			Name:  "preview_height",
+			Value: macro.PreviewHeight,
+		},
+		{
+			Name:  "sha256",
+			Value: macro.SHA256,
+		},
+		{
+			Name:  "phash",
+			Value: macro.PHash,
+		},
+		{
+			Name:  "namespace",
+			Value: bigquery.NullString{StringVal: namespace, Valid: namespace != ""},
 		},
 	}
 
-	if _, err := runQuery(context.Background(), query); err != nil {
+	if _, err := runQuery(ctx, query); err != nil {
 		log.Panicf("failed to insert new macro: %v", err)
 	}
-}
 
-func duplicateExistingMacro(client *bigquery.Client, macroName string, macroToDuplicate *MacroRow) *MacroRow {
-	insertNewMacro(
-		client,
-		macroName,
-		macroToDuplicate.URL,
-		macroToDuplicate.GithubURL,
-		macroToDuplicate.URLSize,
-		macroToDuplicate.Width,
-		macroToDuplicate.Height,
-	)
+	invalidateQueryCache(ctx)
+	invalidateAutocompleteIndex()
+}
 
+func duplicateExistingMacro(ctx context.Context, client *bigquery.Client, macroName string, macroToDuplicate *MacroRow) *MacroRow {
 	var newMacro = *macroToDuplicate
 	newMacro.Name = macroName
 
+	insertNewMacro(ctx, client, &newMacro)
+
 	return &newMacro
 }
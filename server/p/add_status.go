@@ -0,0 +1,89 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// addStatusResponse is the progress of an add job. Once the job is done, it
+// also holds the fields of the add response, including its code.
+type addStatusResponse struct {
+	addResponse
+	JobID string `json:"job_id"`
+	Stage string `json:"stage"`
+	Done  bool   `json:"done"`
+}
+
+// getAddStatus returns the progress of the job, nil if there's no such job.
+func getAddStatus(ctx context.Context, jobID string) (*addStatusResponse, error) {
+	_, store := getJobBackend()
+
+	status, err := store.Get(ctx, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job %s: %v", jobID, err)
+	}
+
+	if status == nil {
+		return nil, nil
+	}
+
+	response := &addStatusResponse{
+		addResponse: addResponse{Code: Success},
+		JobID:       status.ID,
+		Stage:       status.Stage,
+		Done:        status.Done,
+	}
+
+	if status.Response != nil {
+		response.addResponse = *status.Response
+	}
+
+	return response, nil
+}
+
+func getAddStatusResponse(ctx context.Context, jobID string) (string, error) {
+	response, err := getAddStatus(ctx, jobID)
+	if err != nil {
+		return "", err
+	}
+
+	if response == nil {
+		return getRespons(&codeResponse{Code: JobNotFound})
+	}
+
+	return getRespons(response)
+}
+
+// AddStatus reports the progress of an add job. Once the job is done, the
+// response also holds the fields of the add response, including its code.
+func AddStatus(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Panicf("failed to parse form: %v", err)
+	}
+
+	jobID := r.Form.Get("id")
+	if jobID == "" {
+		log.Panicf("job id is missing")
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	response, err := getAddStatusResponse(ctx, jobID)
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	_, err = fmt.Fprint(w, response)
+
+	if err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
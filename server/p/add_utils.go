@@ -1,24 +1,37 @@
 package p
 
 import (
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
 	"net/http"
-
-	_ "golang.org/x/image/bmp"
 )
 
 const (
 	cFileMaxSize = 1024 * 1024 * 10
 )
 
+var errFileTooBig = errors.New("network reader exceed read limit")
+
+type imageMetadata struct {
+	Width      int64
+	Height     int64
+	Frames     int64
+	DurationMs int64
+	LoopCount  int64
+}
+
+// fetchedMedia is a downloaded image along with what was computed while
+// streaming it.
+type fetchedMedia struct {
+	Data      []byte
+	MediaType string
+	SHA256    string
+}
+
 type readerWithMaxSize struct {
 	Reader  io.Reader
 	MaxSize int64
@@ -31,43 +44,121 @@ func (r *readerWithMaxSize) Read(p []byte) (n int, err error) {
 	if err == nil || err == io.EOF {
 		r.curSize += int64(n)
 		if r.curSize > r.MaxSize {
-			return n, errors.New("network reader exceed read limit")
+			return n, errFileTooBig
 		}
 	}
 
 	return n, err
 }
 
-func sendHTTPGetRequest(requestURL string) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, requestURL, http.NoBody)
+func sendHTTPGetRequest(ctx context.Context, requestURL string) ([]byte, error) {
+	ctx, cancel := withStageTimeout(ctx, stageFetch)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, http.NoBody)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 
-	resp, err := client.Do(req)
+	resp, err := doSafeRequest(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	if err := checkFetchStatus(resp); err != nil {
+		return nil, fmt.Errorf("failed to get '%s': %w", requestURL, err)
+	}
+
 	return io.ReadAll(
 		&readerWithMaxSize{Reader: resp.Body, MaxSize: cFileMaxSize},
 	)
 }
 
-func getImageConfig(macroURL string) (*image.Config, error) {
-	macroBuf, err := sendHTTPGetRequest(macroURL)
+// fetchMedia downloads the image with a single bounded request. The media
+// type is sniffed from the first bytes, so unsupported files are rejected
+// without downloading the rest, and the content hash is computed while the
+// body streams in.
+func fetchMedia(ctx context.Context, mediaURL string, maxSize int64) (*fetchedMedia, error) {
+	ctx, cancel := withStageTimeout(ctx, stageFetch)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+
+	resp, err := doMediaRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if err := checkFetchStatus(resp); err != nil {
+		return nil, fmt.Errorf("failed to fetch image '%s': %w", mediaURL, err)
+	}
+
+	if resp.ContentLength > maxSize {
+		return nil, errFileTooBig
+	}
+
+	hasher := sha256.New()
+	reader := io.TeeReader(&readerWithMaxSize{Reader: resp.Body, MaxSize: maxSize}, hasher)
+
+	head := make([]byte, cSniffLen)
+
+	n, err := io.ReadFull(reader, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read image '%s': %w", mediaURL, err)
+	}
+
+	mediaType := detectMediaType(head[:n])
+	if _, ok := supportedTypes[mediaType]; !ok {
+		return nil, fmt.Errorf("image '%s' is %s: %w", mediaURL, mediaType, errUnsupportedImage)
+	}
+
+	rest, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read image '%s': %v", macroURL, err)
+		return nil, fmt.Errorf("failed to read image '%s': %w", mediaURL, err)
 	}
 
-	config, _, err := image.DecodeConfig(bytes.NewReader(macroBuf))
+	return &fetchedMedia{
+		Data:      append(head[:n], rest...),
+		MediaType: mediaType,
+		SHA256:    hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+// decodeFetchedMedia decodes the downloaded image and computes its
+// perceptual hash, without downloading it again.
+func decodeFetchedMedia(fetched *fetchedMedia) (*decodedImage, error) {
+	decoded, err := decodeImage(fetched.Data)
+
+	switch {
+	case errors.Is(err, errUnsupportedImage), errors.Is(err, errImageTooLarge):
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	case err != nil:
+		return nil, fmt.Errorf("failed to decode image: %w: %v", errImageIsCorrupted, err)
+	}
+
+	decoded.SHA256 = fetched.SHA256
+
+	if decoded.Still != nil {
+		decoded.PHash = perceptualHash(decoded.Still)
+		decoded.HasPHash = true
+	}
+
+	return decoded, nil
+}
+
+func getDecodedImage(ctx context.Context, macroURL string) (*decodedImage, error) {
+	fetched, err := fetchMedia(ctx, macroURL, cFileMaxSize)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image '%s': %v", macroURL, err)
+		return nil, err
 	}
 
-	return &config, nil
+	return decodeFetchedMedia(fetched)
 }
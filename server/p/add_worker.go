@@ -0,0 +1,39 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"log"
+	"net/http"
+)
+
+// AddWorker processes add jobs delivered by the Pub/Sub push subscription of
+// the job topic. Delivery is at least once, so a job is only processed by the
+// worker that claimed it. Messages that can't be decoded are acknowledged,
+// they would fail on every delivery.
+func AddWorker(w http.ResponseWriter, r *http.Request) {
+	var job addJob
+
+	messageID, err := decodePushMessage(r, &job)
+	if err != nil || job.ID == "" {
+		log.Printf("dropping malformed job %s: %v", messageID, err)
+		writeWorkerAck(w)
+
+		return
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	_, store := getJobBackend()
+
+	claimed, err := store.Claim(ctx, job.ID)
+	if err != nil {
+		log.Panicf("failed to claim job %s: %v", job.ID, err)
+	}
+
+	if claimed {
+		processAddJob(ctx, store, &job)
+	}
+
+	writeWorkerAck(w)
+}
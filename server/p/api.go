@@ -0,0 +1,367 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	cAPIPrefix          = "/v1/"
+	cAPIMacrosPath      = "macros"
+	cAPIJobsPath        = "jobs"
+	cAPIUsagesPath      = "usages"
+	cAPIReportsPath     = "reports"
+	cAPIMaxRequestSize  = 64 * 1024
+	cAPIJSONContentType = "application/json; charset=utf-8"
+)
+
+var errRequestTooLarge = errors.New("request body is too large")
+
+// addMacroRequest is the body of POST /v1/macros, with the fields of the
+// legacy add form. The optional fields are marked omitempty for the OpenAPI
+// document.
+type addMacroRequest struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	GithubURL    string `json:"github_url,omitempty"`
+	AliasOf      string `json:"alias_of,omitempty"`
+	AllowSimilar bool   `json:"allow_similar,omitempty"`
+	Optimize     bool   `json:"optimize,omitempty"`
+}
+
+// form converts the request to the form the add job is processed from.
+func (request *addMacroRequest) form() url.Values {
+	form := url.Values{
+		"name":       {request.Name},
+		"url":        {request.URL},
+		"github_url": {request.GithubURL},
+	}
+
+	if request.AliasOf != "" {
+		form.Set("alias_of", request.AliasOf)
+	}
+
+	if request.AllowSimilar {
+		form.Set("allow_similar", "true")
+	}
+
+	if request.Optimize {
+		form.Set("optimize", "true")
+	}
+
+	return form
+}
+
+// recordUsageRequest is the body of POST /v1/macros/{name}/usages, the
+// trigger defaults to a click.
+type recordUsageRequest struct {
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// macroResponse is the response of GET /v1/macros/{name}.
+type macroResponse struct {
+	Code ErrorCode `json:"code"`
+	Data *MacroRow `json:"data"`
+}
+
+// getErrorStatus maps an error code to the HTTP status of the v1 API.
+func getErrorStatus(errCode ErrorCode) int {
+	switch errCode {
+	case Success:
+		return http.StatusOK
+	case NameAlreadyExist, SimilarMacroExists:
+		return http.StatusConflict
+	case MacroNotFound, JobNotFound, CollectionNotFound:
+		return http.StatusNotFound
+	case AuthenticationRequired:
+		return http.StatusUnauthorized
+	case NamespaceAccessDenied:
+		return http.StatusForbidden
+	case TransientError:
+		return http.StatusServiceUnavailable
+	case InfraFailure:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	w.Header().Set("Content-Type", cAPIJSONContentType)
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
+
+// writeCacheableJSON writes the response of a query with its caching headers.
+func writeCacheableJSON(w http.ResponseWriter, r *http.Request, queryType string, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	w.Header().Set("Content-Type", cAPIJSONContentType)
+	writeCacheableResponse(w, r, queryType, http.StatusOK, body)
+}
+
+func writeErrorCode(w http.ResponseWriter, errCode ErrorCode) {
+	writeJSON(w, getErrorStatus(errCode), &codeResponse{Code: errCode})
+}
+
+// decodeJSONBody decodes the request body into request, an empty body leaves
+// it as is.
+func decodeJSONBody(r *http.Request, request interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(r.Body, cAPIMaxRequestSize+1))
+	if err != nil {
+		return err
+	}
+
+	if len(body) > cAPIMaxRequestSize {
+		return errRequestTooLarge
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(body, request)
+}
+
+// listMacros serves GET /v1/macros, a search when q is set and the
+// suggestions otherwise.
+func listMacros(ctx context.Context, w http.ResponseWriter, r *http.Request, client *bigquery.Client) {
+	params := r.URL.Query()
+
+	macroQuery := &macroQuery{Type: queryTypeSuggestion, Text: params.Get("q")}
+	if macroQuery.Text != "" {
+		macroQuery.Type = queryTypeSearch
+	}
+
+	macroQuery.Cursor = params.Get("cursor")
+
+	if page := params.Get("page"); page != "" {
+		var err error
+		if macroQuery.Page, err = strconv.Atoi(page); err != nil || macroQuery.Page < 0 {
+			writeErrorCode(w, InvalidRequest)
+			return
+		}
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		var err error
+		if macroQuery.Limit, err = strconv.Atoi(limit); err != nil || macroQuery.Limit <= 0 {
+			writeErrorCode(w, InvalidRequest)
+			return
+		}
+	}
+
+	token := getCallerToken(r)
+
+	result, err := queryMacros(ctx, client, macroQuery, getQueryScope(ctx, params.Get("collection"), token))
+	if errors.Is(err, errInvalidCursor) {
+		writeErrorCode(w, InvalidRequest)
+		return
+	}
+
+	if err != nil {
+		log.Panicf("failed to query macros: %v", err)
+	}
+
+	writeCacheableJSON(w, r, macroQuery.Type, getMacroListResponse(macroQuery, result))
+}
+
+// getMacro serves GET /v1/macros/{name}.
+func getMacro(ctx context.Context, w http.ResponseWriter, r *http.Request, client *bigquery.Client, name string) {
+	scope := getQueryScope(ctx, "", getCallerToken(r))
+
+	result, err := queryMacros(ctx, client, &macroQuery{Type: queryTypeGet, Text: name}, scope)
+	if err != nil {
+		log.Panicf("failed to query macro: %v", err)
+	}
+
+	if len(result.Rows) == 0 {
+		writeErrorCode(w, MacroNotFound)
+		return
+	}
+
+	writeCacheableJSON(w, r, queryTypeGet, &macroResponse{Code: Success, Data: result.Rows[0]})
+}
+
+// addMacro serves POST /v1/macros. Like the legacy add, it only queues the
+// job, whose progress is served by GET /v1/jobs/{id}.
+func addMacro(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var request addMacroRequest
+	if err := decodeJSONBody(r, &request); err != nil {
+		writeErrorCode(w, InvalidRequest)
+		return
+	}
+
+	jobID, errCode := submitAddJob(ctx, request.form(), getCallerToken(r))
+	if errCode != Success {
+		writeJSON(w, getErrorStatus(errCode), getAddResponse(nil, errCode))
+		return
+	}
+
+	w.Header().Set("Location", cAPIPrefix+cAPIJobsPath+"/"+jobID)
+	writeJSON(w, http.StatusAccepted, &addJobResponse{Code: Success, JobID: jobID, Stage: cStageQueued})
+}
+
+// getJob serves GET /v1/jobs/{id}.
+func getJob(ctx context.Context, w http.ResponseWriter, jobID string) {
+	response, err := getAddStatus(ctx, jobID)
+	if err != nil {
+		log.Panicf("failed to get job: %v", err)
+	}
+
+	if response == nil {
+		writeErrorCode(w, JobNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// recordUsage serves POST /v1/macros/{name}/usages.
+func recordUsage(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) {
+	request := recordUsageRequest{Trigger: cClickTrigger}
+	if err := decodeJSONBody(r, &request); err != nil {
+		writeErrorCode(w, InvalidRequest)
+		return
+	}
+
+	if request.Trigger != cClickTrigger && request.Trigger != cDirectTrigger {
+		writeErrorCode(w, InvalidRequest)
+		return
+	}
+
+	recordMacroUsage(ctx, getCallerToken(r), macroReference(splitMacroReference(name)), request.Trigger)
+
+	writeJSON(w, http.StatusCreated, &codeResponse{Code: Success})
+}
+
+// addReport serves POST /v1/macros/{name}/reports.
+func addReport(ctx context.Context, w http.ResponseWriter, client *bigquery.Client, name string) {
+	errCode := reportMacro(ctx, client, macroReference(splitMacroReference(name)))
+	if errCode != Success {
+		writeErrorCode(w, errCode)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, &codeResponse{Code: Success})
+}
+
+// routeMacro routes the requests of a single macro. Names may contain the
+// namespace separator, so the sub-resource is only looked for on POST.
+func routeMacro(ctx context.Context, w http.ResponseWriter, r *http.Request, client *bigquery.Client, path string) {
+	if r.Method == http.MethodGet {
+		getMacro(ctx, w, r, client, path)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	separatorIndex := strings.LastIndex(path, "/")
+	if separatorIndex <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := path[:separatorIndex]
+
+	switch path[separatorIndex+1:] {
+	case cAPIUsagesPath:
+		recordUsage(ctx, w, r, name)
+	case cAPIReportsPath:
+		addReport(ctx, w, client, name)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// API serves the v1 REST API:
+//
+//	GET  /v1/macros?q=&cursor=       search, or the suggestions without q
+//	GET  /v1/macros/{name}           a single macro
+//	POST /v1/macros                  queue an add job
+//	GET  /v1/jobs/{id}               the progress of an add job
+//	POST /v1/macros/{name}/usages    count a usage
+//	POST /v1/macros/{name}/reports   report a broken image
+//
+// It's backed by the same logic as the legacy functions, which keep serving
+// the form based API of older extension versions. The OpenAPI document of the
+// routes is served at /openapi.json.
+func API(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+
+	if r.URL.Path == cOpenAPIPath && r.Method == http.MethodGet {
+		serveOpenAPIDocument(w)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, cAPIPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	resource := strings.Trim(strings.TrimPrefix(r.URL.Path, cAPIPrefix), "/")
+	collection, path := resource, ""
+
+	if separatorIndex := strings.Index(resource, "/"); separatorIndex >= 0 {
+		collection, path = resource[:separatorIndex], resource[separatorIndex+1:]
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	switch {
+	case collection == cAPIJobsPath && path != "" && r.Method == http.MethodGet:
+		getJob(ctx, w, path)
+		return
+	case collection == cAPIJobsPath && path != "":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case collection == cAPIMacrosPath && path == "" && r.Method == http.MethodPost:
+		addMacro(ctx, w, r)
+		return
+	case collection != cAPIMacrosPath:
+		http.NotFound(w, r)
+		return
+	}
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		log.Panicf("failed to create bigquery client: %v", err)
+	}
+	defer client.Close()
+
+	switch {
+	case path != "":
+		routeMacro(ctx, w, r, client, path)
+	case r.Method == http.MethodGet:
+		listMacros(ctx, w, r, client)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
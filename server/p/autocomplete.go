@@ -0,0 +1,360 @@
+package p
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	cAutocompleteRefreshEnv     = "AUTOCOMPLETE_REFRESH"
+	cDefaultAutocompleteRefresh = 5 * time.Minute
+	cDefaultAutocompleteResults = 10
+	// prefixes of up to this many characters get a bucket sorted by usages
+	cAutocompleteBucketPrefix = 2
+	// how long a read of the cache generation is trusted, so lookups, made on
+	// every keystroke, don't each make a round trip to the cache
+	cAutocompleteGenerationTTL = 5 * time.Second
+)
+
+// autocompleteEntry is a macro of the index, Key is its lower cased name
+// without the namespace.
+type autocompleteEntry struct {
+	Key       string
+	Namespace string
+	Macro     *MacroRow
+}
+
+// autocompleteIndex holds all the macros sorted by their key, so the macros
+// whose name starts with a prefix are a contiguous range found by a binary
+// search. The macros of short prefixes, which match the most, are also kept
+// sorted by usages in buckets so a lookup stops after the first visible ones.
+// It's loaded on first use and reloaded once it's older than
+// AUTOCOMPLETE_REFRESH or after the macros changed, which every instance
+// learns from the generation of the query cache, read at most every
+// cAutocompleteGenerationTTL.
+type autocompleteIndex struct {
+	mu         sync.RWMutex
+	entries    []autocompleteEntry
+	buckets    map[string][]*autocompleteEntry
+	loadedAt   time.Time
+	generation string
+	stale      bool
+	loading    *autocompleteLoad
+
+	latestGeneration string
+	generationReadAt time.Time
+}
+
+// autocompleteLoad is a load in progress, concurrent lookups wait for it
+// rather than each scanning the macros.
+type autocompleteLoad struct {
+	done chan struct{}
+	err  error
+}
+
+var macroAutocompleteIndex = &autocompleteIndex{}
+
+func getAutocompleteRefreshInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv(cAutocompleteRefreshEnv))
+	if err != nil || interval <= 0 {
+		return cDefaultAutocompleteRefresh
+	}
+
+	return interval
+}
+
+// queryAutocompleteEntries reads all the macros with their usages.
+func queryAutocompleteEntries(ctx context.Context, client *bigquery.Client) []autocompleteEntry {
+	query := client.Query(`
+		SELECT
+			name,
+			github_url AS url,
+			width,
+			height,
+			` + cMacroMediaColumns + `,
+			` + cUsagesColumn + ` AS usages
+		FROM github-macros.macros.macros Macros
+		LEFT JOIN github-macros.macros.usages Usages
+		ON Macros.name = Usages.macro_name
+	`)
+
+	rows := getQueryResults(ctx, query)
+	entries := make([]autocompleteEntry, 0, len(rows))
+
+	for _, row := range rows {
+		namespace, name := splitMacroReference(row.Name)
+		entries = append(entries, autocompleteEntry{Key: strings.ToLower(name), Namespace: namespace, Macro: row})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	return entries
+}
+
+// isMorePopular orders the results of a lookup, most used first.
+func isMorePopular(a, b *autocompleteEntry) bool {
+	if a.Macro.Usages != b.Macro.Usages {
+		return a.Macro.Usages > b.Macro.Usages
+	}
+
+	return a.Macro.Name < b.Macro.Name
+}
+
+// getAutocompleteBuckets groups the entries by every prefix of up to
+// cAutocompleteBucketPrefix characters of their key, most used first.
+func getAutocompleteBuckets(entries []autocompleteEntry) map[string][]*autocompleteEntry {
+	buckets := map[string][]*autocompleteEntry{}
+
+	for i := range entries {
+		entry := &entries[i]
+
+		for length := 0; length <= cAutocompleteBucketPrefix && length <= len(entry.Key); length++ {
+			buckets[entry.Key[:length]] = append(buckets[entry.Key[:length]], entry)
+		}
+	}
+
+	for _, bucket := range buckets {
+		sort.Slice(bucket, func(i, j int) bool {
+			return isMorePopular(bucket[i], bucket[j])
+		})
+	}
+
+	return buckets
+}
+
+// reload replaces the entries by the stored macros.
+func (index *autocompleteIndex) reload(ctx context.Context, generation string) error {
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	entries := queryAutocompleteEntries(ctx, client)
+	buckets := getAutocompleteBuckets(entries)
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.entries = entries
+	index.buckets = buckets
+	index.loadedAt = time.Now()
+	index.generation = generation
+	index.stale = false
+
+	return nil
+}
+
+// load reloads the index, as of the cache generation. Concurrent calls share
+// a single reload.
+func (index *autocompleteIndex) load(ctx context.Context, generation string) (err error) {
+	index.mu.Lock()
+
+	if call := index.loading; call != nil {
+		index.mu.Unlock()
+
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	call := &autocompleteLoad{done: make(chan struct{})}
+	index.loading = call
+	index.mu.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+
+		call.err = err
+
+		index.mu.Lock()
+		index.loading = nil
+		index.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	return index.reload(ctx, generation)
+}
+
+// refresh reloads the index in the background, the current entries keep
+// being served meanwhile.
+func (index *autocompleteIndex) refresh(generation string) {
+	index.mu.RLock()
+	loading := index.loading != nil
+	index.mu.RUnlock()
+
+	if loading {
+		return
+	}
+
+	go func() {
+		ctx, cancel := withStageTimeout(context.Background(), stageStorage)
+		defer cancel()
+
+		if err := index.load(ctx, generation); err != nil {
+			log.Printf("failed to refresh the autocomplete index: %v", err)
+		}
+	}()
+}
+
+// markStale makes the next lookup refresh the index, after macros were added
+// or deleted by this instance.
+func (index *autocompleteIndex) markStale() {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	index.stale = true
+}
+
+// getGeneration returns the generation of the query cache, or current when
+// it can't be read so a failing cache doesn't reload the index every time.
+func getGeneration(ctx context.Context, current string) string {
+	generation, err := getQueryCache().Generation(ctx)
+	if err != nil {
+		log.Printf("failed to read the cache generation: %v", err)
+		return current
+	}
+
+	return generation
+}
+
+// getLatestGeneration returns the generation of the query cache, read again
+// once the last read is older than cAutocompleteGenerationTTL.
+func (index *autocompleteIndex) getLatestGeneration(ctx context.Context) string {
+	index.mu.RLock()
+	latest, readAt, current := index.latestGeneration, index.generationReadAt, index.generation
+	index.mu.RUnlock()
+
+	if !readAt.IsZero() && time.Since(readAt) < cAutocompleteGenerationTTL {
+		return latest
+	}
+
+	latest = getGeneration(ctx, current)
+
+	index.mu.Lock()
+	index.latestGeneration = latest
+	index.generationReadAt = time.Now()
+	index.mu.Unlock()
+
+	return latest
+}
+
+// lookup returns up to limit macros visible in namespaces whose name starts
+// with prefix, most used first. A prefix with a namespace ("acme/lg") only
+// matches the macros of that namespace. Only the first lookup waits for the
+// index to load, an outdated index keeps being served while it's reloaded in
+// the background.
+func (index *autocompleteIndex) lookup(ctx context.Context, prefix string, namespaces []string, limit int) []*MacroRow {
+	generation := index.getLatestGeneration(ctx)
+
+	index.mu.RLock()
+	loaded := !index.loadedAt.IsZero()
+	outdated := index.stale || index.generation != generation || time.Since(index.loadedAt) > getAutocompleteRefreshInterval()
+	index.mu.RUnlock()
+
+	switch {
+	case !loaded:
+		if err := index.load(ctx, generation); err != nil {
+			log.Panicf("failed to load the autocomplete index: %v", err)
+		}
+	case outdated:
+		index.refresh(generation)
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	return index.match(prefix, namespaces, limit)
+}
+
+// match returns the lookup results, the caller holds the lock. Short
+// prefixes walk their bucket in order of usages, longer ones sort the few
+// macros in their range.
+func (index *autocompleteIndex) match(prefix string, namespaces []string, limit int) []*MacroRow {
+	visible := map[string]bool{"": true}
+	for _, namespace := range namespaces {
+		visible[namespace] = true
+	}
+
+	namespace, name := "", strings.ToLower(prefix)
+	hasNamespace := strings.Contains(prefix, cNamespaceSeparator)
+
+	if hasNamespace {
+		namespace, name = splitMacroReference(name)
+	}
+
+	isMatch := func(entry *autocompleteEntry) bool {
+		return visible[entry.Namespace] && (!hasNamespace || entry.Namespace == namespace)
+	}
+
+	matches := []*autocompleteEntry{}
+
+	if len(name) <= cAutocompleteBucketPrefix {
+		for _, entry := range index.buckets[name] {
+			if len(matches) == limit {
+				break
+			}
+
+			if isMatch(entry) {
+				matches = append(matches, entry)
+			}
+		}
+	} else {
+		start := sort.Search(len(index.entries), func(i int) bool {
+			return index.entries[i].Key >= name
+		})
+
+		for i := start; i < len(index.entries) && strings.HasPrefix(index.entries[i].Key, name); i++ {
+			if entry := &index.entries[i]; isMatch(entry) {
+				matches = append(matches, entry)
+			}
+		}
+
+		sort.Slice(matches, func(i, j int) bool {
+			return isMorePopular(matches[i], matches[j])
+		})
+
+		if len(matches) > limit {
+			matches = matches[:limit]
+		}
+	}
+
+	rows := make([]*MacroRow, 0, len(matches))
+	for _, match := range matches {
+		rows = append(rows, match.Macro)
+	}
+
+	return rows
+}
+
+// LoadAutocompleteIndex loads the autocomplete index, so a long running
+// server doesn't load it while answering the first request.
+func LoadAutocompleteIndex(ctx context.Context) error {
+	ctx, cancel := withStageTimeout(ctx, stageStorage)
+	defer cancel()
+
+	return macroAutocompleteIndex.load(ctx, getGeneration(ctx, ""))
+}
+
+// invalidateAutocompleteIndex refreshes the index of this instance after
+// macros were added or deleted, the others learn it from the purge of the
+// query cache.
+func invalidateAutocompleteIndex() {
+	macroAutocompleteIndex.markStale()
+}
@@ -0,0 +1,167 @@
+package p
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestAutocompleteIndex(usages map[string]int64) *autocompleteIndex {
+	entries := []autocompleteEntry{}
+
+	for name, count := range usages {
+		namespace, key := splitMacroReference(name)
+		entries = append(entries, autocompleteEntry{
+			Key:       strings.ToLower(key),
+			Namespace: namespace,
+			Macro:     &MacroRow{Name: name, Usages: count},
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+
+	return &autocompleteIndex{entries: entries, buckets: getAutocompleteBuckets(entries)}
+}
+
+func TestAutocompleteMatch(t *testing.T) {
+	index := newTestAutocompleteIndex(map[string]int64{
+		"lgtm":      50,
+		"lol":       80,
+		"Lgtm-cat":  50,
+		"lgtm-dog":  10,
+		"ship-it":   30,
+		"acme/lgtm": 100,
+		"acme/lime": 5,
+		"other/lgz": 1000,
+	})
+
+	tests := []struct {
+		prefix     string
+		namespaces []string
+		limit      int
+		names      []string
+	}{
+		{"", nil, 3, []string{"lol", "Lgtm-cat", "lgtm"}},
+		{"l", nil, 10, []string{"lol", "Lgtm-cat", "lgtm", "lgtm-dog"}},
+		{"L", []string{"acme"}, 2, []string{"acme/lgtm", "lol"}},
+		{"lg", []string{"acme"}, 10, []string{"acme/lgtm", "Lgtm-cat", "lgtm", "lgtm-dog"}},
+		{"lgt", nil, 10, []string{"Lgtm-cat", "lgtm", "lgtm-dog"}},
+		{"lgtm-", nil, 1, []string{"Lgtm-cat"}},
+		{"acme/l", []string{"acme"}, 10, []string{"acme/lgtm", "acme/lime"}},
+		{"acme/lgt", []string{"acme"}, 10, []string{"acme/lgtm"}},
+		{"acme/l", nil, 10, []string{}},
+		{"other/", []string{"acme"}, 10, []string{}},
+		{"x", nil, 10, []string{}},
+	}
+
+	for _, test := range tests {
+		names := []string{}
+		for _, row := range index.match(test.prefix, test.namespaces, test.limit) {
+			names = append(names, row.Name)
+		}
+
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("match(%q, %v, %d) = %v, want %v", test.prefix, test.namespaces, test.limit, names, test.names)
+		}
+	}
+}
+
+func TestAutocompleteLoadsOnce(t *testing.T) {
+	fake := useFakeBigQuery(t)
+	resetQueryCache(t)
+	setTestEnv(t, "FUNCTION_TARGET", "")
+	setTestEnv(t, cCacheBackendEnv, cCacheBackendNone)
+
+	fake.hold = make(chan struct{})
+	index := &autocompleteIndex{}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			index.lookup(context.Background(), "lg", nil, 5)
+		}()
+	}
+
+	// let every lookup find the index unloaded before the query completes
+	time.Sleep(50 * time.Millisecond)
+	close(fake.hold)
+	wg.Wait()
+
+	if queries := fake.queryCount(); queries != 1 {
+		t.Errorf("concurrent lookups ran %d queries, want 1", queries)
+	}
+}
+
+func TestAutocompleteReloadsAfterPurgeOfOtherInstance(t *testing.T) {
+	fake := useFakeBigQuery(t)
+	redis := newFakeRedis(t, "")
+	resetQueryCache(t)
+	setTestEnv(t, "FUNCTION_TARGET", "query")
+	setTestEnv(t, cCacheBackendEnv, "")
+	setTestEnv(t, cRedisAddrEnv, redis.addr())
+
+	ctx := context.Background()
+	index := &autocompleteIndex{}
+
+	if rows := index.lookup(ctx, "lg", nil, 5); len(rows) != 1 {
+		t.Fatalf("lookup = %d macros, want 1", len(rows))
+	}
+
+	gets := redis.getCount()
+
+	index.lookup(ctx, "lg", nil, 5)
+
+	if queries := fake.queryCount(); queries != 1 {
+		t.Fatalf("lookups of a fresh index ran %d queries, want 1", queries)
+	}
+
+	if redis.getCount() != gets {
+		t.Errorf("lookup read the cache generation again within %v", cAutocompleteGenerationTTL)
+	}
+
+	// another function adds a macro and purges the shared cache
+	if err := newRedisCache(redis.addr(), "").Purge(ctx); err != nil {
+		t.Fatalf("Purge = %v", err)
+	}
+
+	index.mu.Lock()
+	index.generationReadAt = time.Time{}
+	index.mu.Unlock()
+
+	// the current index is served while the reload waits for its query
+	fake.hold = make(chan struct{})
+
+	if rows := index.lookup(ctx, "lg", nil, 5); len(rows) != 1 {
+		t.Errorf("lookup during a reload = %d macros, want 1", len(rows))
+	}
+
+	close(fake.hold)
+
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		index.mu.RLock()
+		reloaded := index.generation == "1" && index.loading == nil
+		index.mu.RUnlock()
+
+		if reloaded {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the index wasn't reloaded after a purge")
+		}
+	}
+
+	if queries := fake.queryCount(); queries != 2 {
+		t.Errorf("lookup after a purge ran %d queries in total, want 2", queries)
+	}
+}
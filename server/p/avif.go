@@ -0,0 +1,204 @@
+package p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+const (
+	cBoxHeaderSize      = 8
+	cLargeBoxHeaderSize = 16
+	cFullBoxHeaderSize  = 4
+	cISPESize           = 12
+	cSTSZSize           = 12
+	cMDHDv0Size         = 20
+	cMDHDv1Size         = 32
+)
+
+var avifBrands = [][]byte{[]byte("avif"), []byte("avis")}
+
+// avifContainers are the boxes we descend into, with the size of the header
+// that precedes their children.
+var avifContainers = map[string]int{
+	"meta": cFullBoxHeaderSize,
+	"iprp": 0,
+	"ipco": 0,
+	"moov": 0,
+	"trak": 0,
+	"mdia": 0,
+	"minf": 0,
+	"stbl": 0,
+}
+
+type avifInfo struct {
+	width, height   int64
+	samples         int64
+	timescale       int64
+	duration        int64
+	hasMediaData    bool
+	hasFileTypeInfo bool
+}
+
+func isAVIF(head []byte) bool {
+	if len(head) < cLargeBoxHeaderSize || !bytes.Equal(head[4:8], []byte("ftyp")) {
+		return false
+	}
+
+	size := int(binary.BigEndian.Uint32(head))
+	if size < cLargeBoxHeaderSize || size > len(head) {
+		size = len(head)
+	}
+
+	// major brand followed by the minor version and the compatible brands
+	brands := [][]byte{head[8:12]}
+	for offset := cLargeBoxHeaderSize; offset+4 <= size; offset += 4 {
+		brands = append(brands, head[offset:offset+4])
+	}
+
+	for _, brand := range brands {
+		for _, avifBrand := range avifBrands {
+			if bytes.Equal(brand, avifBrand) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// walkBoxes calls fn for every ISOBMFF box in buf, returning
+// errImageIsCorrupted if a box runs past the end of its parent.
+func walkBoxes(buf []byte, fn func(boxType string, data []byte) error) error {
+	for offset := 0; offset < len(buf); {
+		if offset+cBoxHeaderSize > len(buf) {
+			return errImageIsCorrupted
+		}
+
+		size := uint64(binary.BigEndian.Uint32(buf[offset:]))
+		boxType := string(buf[offset+4 : offset+8])
+		headerSize := uint64(cBoxHeaderSize)
+
+		switch size {
+		case 0:
+			size = uint64(len(buf) - offset)
+		case 1:
+			if offset+cLargeBoxHeaderSize > len(buf) {
+				return errImageIsCorrupted
+			}
+
+			size = binary.BigEndian.Uint64(buf[offset+8:])
+			headerSize = cLargeBoxHeaderSize
+		}
+
+		if size < headerSize || size > uint64(len(buf)-offset) || size > math.MaxInt32 {
+			return errImageIsCorrupted
+		}
+
+		if err := fn(boxType, buf[offset+int(headerSize):offset+int(size)]); err != nil {
+			return err
+		}
+
+		offset += int(size)
+	}
+
+	return nil
+}
+
+func (info *avifInfo) visitBox(boxType string, data []byte) error {
+	if headerSize, ok := avifContainers[boxType]; ok {
+		if len(data) < headerSize {
+			return errImageIsCorrupted
+		}
+
+		return walkBoxes(data[headerSize:], info.visitBox)
+	}
+
+	switch boxType {
+	case "ftyp":
+		info.hasFileTypeInfo = true
+	case "mdat":
+		info.hasMediaData = len(data) > 0
+	case "ispe":
+		if len(data) < cISPESize {
+			return errImageIsCorrupted
+		}
+
+		// an image may carry several items (alpha, thumbnails), keep the largest
+		width := int64(binary.BigEndian.Uint32(data[4:]))
+		height := int64(binary.BigEndian.Uint32(data[8:]))
+
+		if width*height > info.width*info.height {
+			info.width, info.height = width, height
+		}
+	case "stsz":
+		if len(data) < cSTSZSize {
+			return errImageIsCorrupted
+		}
+
+		info.samples = int64(binary.BigEndian.Uint32(data[8:]))
+	case "mdhd":
+		return info.visitMediaHeader(data)
+	}
+
+	return nil
+}
+
+func (info *avifInfo) visitMediaHeader(data []byte) error {
+	if len(data) < cMDHDv0Size {
+		return errImageIsCorrupted
+	}
+
+	if data[0] == 1 {
+		if len(data) < cMDHDv1Size {
+			return errImageIsCorrupted
+		}
+
+		info.timescale = int64(binary.BigEndian.Uint32(data[20:]))
+		info.duration = int64(binary.BigEndian.Uint64(data[24:]))
+
+		return nil
+	}
+
+	info.timescale = int64(binary.BigEndian.Uint32(data[12:]))
+	info.duration = int64(binary.BigEndian.Uint32(data[16:]))
+
+	return nil
+}
+
+// decodeAVIFMetadata validates the container structure and reads the image
+// dimensions and, for image sequences, the frame count and duration. There is
+// no AV1 decoder available, so the coded payload itself isn't decoded.
+func decodeAVIFMetadata(buf []byte) (*decodedImage, error) {
+	info := &avifInfo{}
+
+	if err := walkBoxes(buf, info.visitBox); err != nil {
+		return nil, err
+	}
+
+	if !info.hasFileTypeInfo || !info.hasMediaData || info.width <= 0 || info.height <= 0 {
+		return nil, errImageIsCorrupted
+	}
+
+	if err := checkPixelBudget(info.width, info.height, info.samples); err != nil {
+		return nil, err
+	}
+
+	decoded := &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:  info.width,
+			Height: info.height,
+			Frames: 1,
+		},
+	}
+
+	if info.samples > 1 {
+		decoded.Frames = info.samples
+
+		if info.timescale > 0 {
+			decoded.DurationMs = info.duration * cMsPerSecond / info.timescale
+		}
+	}
+
+	return decoded, nil
+}
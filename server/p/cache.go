@@ -0,0 +1,197 @@
+package p
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	cCacheBackendEnv    = "CACHE_BACKEND"
+	cCacheBackendRedis  = "redis"
+	cCacheBackendMemory = "memory"
+	cCacheBackendNone   = "none"
+	cCacheSizeEnv       = "CACHE_SIZE"
+	cDefaultCacheSize   = 1000
+)
+
+// responseCache keeps query results for a while, in the process or in a
+// Redis compatible server selected by CACHE_BACKEND. Caches are best effort, a
+// failing cache is logged and the query runs against BigQuery.
+type responseCache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value as of generation, read before value was computed. When
+	// the cache was purged since, value may predate the change and isn't
+	// served.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration, generation string) error
+	// Purge drops every entry, after a change of the macros.
+	Purge(ctx context.Context) error
+	// Generation changes on every purge, in all the processes sharing the
+	// cache, so in-process state derived from the macros can tell it's stale.
+	Generation(ctx context.Context) (string, error)
+}
+
+// lruCache is an in-process cache holding the most recently used entries.
+type lruCache struct {
+	mu         sync.Mutex
+	size       int
+	order      *list.List
+	entries    map[string]*list.Element
+	generation int64
+}
+
+type lruCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// noCache is used when caching is disabled.
+type noCache struct{}
+
+var (
+	queryCacheOnce sync.Once
+	queryCache     responseCache
+)
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *lruCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := element.Value.(*lruCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+
+		return nil, false, nil
+	}
+
+	c.order.MoveToFront(element)
+
+	return entry.value, true, nil
+}
+
+func (c *lruCache) Set(_ context.Context, key string, value []byte, ttl time.Duration, generation string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != strconv.FormatInt(c.generation, 10) {
+		return nil
+	}
+
+	entry := &lruCacheEntry{key: key, value: value, expires: time.Now().Add(ttl)}
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+
+		return nil
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruCacheEntry).key)
+	}
+
+	return nil
+}
+
+func (c *lruCache) Purge(_ context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.generation++
+
+	return nil
+}
+
+func (c *lruCache) Generation(_ context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return strconv.FormatInt(c.generation, 10), nil
+}
+
+func (noCache) Get(context.Context, string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+func (noCache) Set(context.Context, string, []byte, time.Duration, string) error {
+	return nil
+}
+
+func (noCache) Purge(context.Context) error {
+	return nil
+}
+
+// Generation never changes, purges aren't shared without a cache.
+func (noCache) Generation(context.Context) (string, error) {
+	return "", nil
+}
+
+// getQueryCache returns the cache selected by CACHE_BACKEND. Cloud Functions
+// default to Redis: a purge only reaches the process it runs in, so the
+// in-process cache of the other instances and functions would keep serving
+// stale results. Without a Redis server they don't cache at all.
+func getQueryCache() responseCache {
+	queryCacheOnce.Do(func() {
+		backend := os.Getenv(cCacheBackendEnv)
+
+		if isCloudFunction() {
+			switch {
+			case backend == cCacheBackendMemory:
+				log.Printf("error: %s=%s can't be purged across Cloud Functions, caching is disabled", cCacheBackendEnv, backend)
+
+				backend = cCacheBackendNone
+			case backend == "" && os.Getenv(cRedisAddrEnv) == "":
+				log.Printf("error: %s is not set, caching is disabled", cRedisAddrEnv)
+
+				backend = cCacheBackendNone
+			case backend == "":
+				backend = cCacheBackendRedis
+			}
+		}
+
+		switch backend {
+		case cCacheBackendNone:
+			queryCache = noCache{}
+		case cCacheBackendRedis:
+			queryCache = newRedisCache(os.Getenv(cRedisAddrEnv), os.Getenv(cRedisPasswordEnv))
+		default:
+			size, err := strconv.Atoi(os.Getenv(cCacheSizeEnv))
+			if err != nil || size <= 0 {
+				size = cDefaultCacheSize
+			}
+
+			queryCache = newLRUCache(size)
+		}
+	})
+
+	return queryCache
+}
+
+// invalidateQueryCache drops the cached results after macros were added or
+// deleted.
+func invalidateQueryCache(ctx context.Context) {
+	if err := getQueryCache().Purge(ctx); err != nil {
+		log.Printf("failed to invalidate cached results: %v", err)
+	}
+}
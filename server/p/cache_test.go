@@ -0,0 +1,141 @@
+package p
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetQueryCache makes the next getQueryCache select the cache again.
+func resetQueryCache(t *testing.T) {
+	queryCacheOnce = sync.Once{}
+	queryCache = nil
+
+	t.Cleanup(func() {
+		queryCacheOnce = sync.Once{}
+		queryCache = nil
+	})
+}
+
+func TestGetQueryCache(t *testing.T) {
+	tests := []struct {
+		function  string
+		backend   string
+		redisAddr string
+		want      string
+	}{
+		{"", "", "", "*p.lruCache"},
+		{"", cCacheBackendMemory, "", "*p.lruCache"},
+		{"", cCacheBackendRedis, "", "*p.redisCache"},
+		{"", cCacheBackendNone, "", "p.noCache"},
+		{"query", "", "10.0.0.1:6379", "*p.redisCache"},
+		{"query", "", "", "p.noCache"},
+		{"query", cCacheBackendMemory, "10.0.0.1:6379", "p.noCache"},
+		{"query", cCacheBackendNone, "10.0.0.1:6379", "p.noCache"},
+	}
+
+	for _, test := range tests {
+		resetQueryCache(t)
+		setTestEnv(t, "FUNCTION_TARGET", test.function)
+		setTestEnv(t, cCacheBackendEnv, test.backend)
+		setTestEnv(t, cRedisAddrEnv, test.redisAddr)
+
+		if got := fmt.Sprintf("%T", getQueryCache()); got != test.want {
+			t.Errorf("getQueryCache() with FUNCTION_TARGET=%q %s=%q %s=%q = %s, want %s",
+				test.function, cCacheBackendEnv, test.backend, cRedisAddrEnv, test.redisAddr, got, test.want)
+		}
+	}
+}
+
+func TestQueryMacrosDoesNotCacheMissingMacros(t *testing.T) {
+	useFakeBigQuery(t)
+	resetQueryCache(t)
+	setTestEnv(t, "FUNCTION_TARGET", "")
+	setTestEnv(t, cCacheBackendEnv, cCacheBackendMemory)
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	scope := &queryScope{Namespaces: []string{}}
+
+	for _, test := range []struct {
+		name   string
+		cached bool
+	}{
+		{"lgtm", true},
+		{cMissingValue, false},
+	} {
+		macroQuery := &macroQuery{Type: queryTypeGet, Text: test.name}
+
+		if _, err := queryMacros(ctx, client, macroQuery, scope); err != nil {
+			t.Fatalf("queryMacros(%s) = %v", test.name, err)
+		}
+
+		if cached := getCachedQueryResult(ctx, getQueryCacheKey(macroQuery, scope)) != nil; cached != test.cached {
+			t.Errorf("get %s cached = %v, want %v", test.name, cached, test.cached)
+		}
+	}
+}
+
+func TestQueryMacrosDoesNotCacheAcrossPurge(t *testing.T) {
+	redis := newFakeRedis(t, "")
+
+	for _, backend := range []string{cCacheBackendMemory, cCacheBackendRedis} {
+		fake := useFakeBigQuery(t)
+		resetQueryCache(t)
+		setTestEnv(t, "FUNCTION_TARGET", "")
+		setTestEnv(t, cCacheBackendEnv, backend)
+		setTestEnv(t, cRedisAddrEnv, redis.addr())
+
+		ctx := context.Background()
+
+		client, err := newBigQueryClient(ctx)
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		defer client.Close()
+
+		scope := &queryScope{Namespaces: []string{}}
+		macroQuery := &macroQuery{Type: queryTypeGet, Text: "lgtm"}
+		key := getQueryCacheKey(macroQuery, scope)
+
+		fake.hold = make(chan struct{})
+		done := make(chan error)
+
+		go func() {
+			_, err := queryMacros(ctx, client, macroQuery, scope)
+			done <- err
+		}()
+
+		// the query starts after the cache missed, purge before it completes
+		for fake.queryCount() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		invalidateQueryCache(ctx)
+		close(fake.hold)
+
+		if err := <-done; err != nil {
+			t.Fatalf("queryMacros() with %s cache = %v", backend, err)
+		}
+
+		if cached := getCachedQueryResult(ctx, key); cached != nil {
+			t.Errorf("%s cache kept a result computed before a purge", backend)
+		}
+
+		if _, err := queryMacros(ctx, client, macroQuery, scope); err != nil {
+			t.Fatalf("queryMacros() with %s cache = %v", backend, err)
+		}
+
+		if cached := getCachedQueryResult(ctx, key); cached == nil {
+			t.Errorf("%s cache didn't keep a result computed after a purge", backend)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	cGithubUserURL     = "https://api.github.com/user"
+	cGithubUserOrgsURL = "https://api.github.com/user/orgs?per_page=100"
+	cCallerCacheTTL    = 5 * time.Minute
+	// members of more organizations only see the macros of the first ones
+	cMaxOrgPages = 10
+)
+
+var (
+	errMissingToken = errors.New("github token is missing")
+	errInvalidToken = errors.New("github token is invalid")
+
+	linkNextPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="next"`)
+)
+
+type callerCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// callerCache keeps what the GitHub API returned for recent callers, keyed by
+// the hash of their token, so every request doesn't cost a GitHub API call.
+var callerCache = struct {
+	sync.Mutex
+	entries map[string]*callerCacheEntry
+}{entries: map[string]*callerCacheEntry{}}
+
+// getCallerToken returns the GitHub token the caller authenticates with, it
+// is sent as "Authorization: token <token>" or as a bearer token.
+func getCallerToken(r *http.Request) string {
+	return parseAuthorization(r.Header.Get("Authorization"))
+}
+
+// parseAuthorization returns the token of an authorization header value.
+func parseAuthorization(authorization string) string {
+	for _, scheme := range []string{"token ", "Bearer "} {
+		if strings.HasPrefix(authorization, scheme) {
+			return strings.TrimSpace(strings.TrimPrefix(authorization, scheme))
+		}
+	}
+
+	return ""
+}
+
+// getNextPageURL returns the URL of the next page of a paginated GitHub API
+// response, or an empty string on the last page. Only GitHub API URLs are
+// followed since the token is sent along.
+func getNextPageURL(link string) string {
+	match := linkNextPattern.FindStringSubmatch(link)
+	if match == nil {
+		return ""
+	}
+
+	nextURL, err := url.Parse(match[1])
+	if err != nil || nextURL.Scheme != "https" || nextURL.Host != "api.github.com" {
+		return ""
+	}
+
+	return nextURL.String()
+}
+
+// sendUserAPIRequest calls the GitHub API on behalf of the owner of token and
+// decodes the response into result. It returns the URL of the next page for
+// paginated responses.
+func sendUserAPIRequest(ctx context.Context, token, apiURL string, result interface{}) (string, error) {
+	ctx, cancel := withStageTimeout(ctx, stageGithub)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Authorization", "token "+token)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	resp, err := doSafeRequest(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return "", errInvalidToken
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("github API request to %s failed: status %d", apiURL, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return "", err
+	}
+
+	return getNextPageURL(resp.Header.Get("Link")), nil
+}
+
+// getCachedCallerValue returns the cached value of kind for token, calling
+// fetch when it isn't cached or expired.
+func getCachedCallerValue(
+	ctx context.Context,
+	token, kind string,
+	fetch func(ctx context.Context, token string) (interface{}, error),
+) (interface{}, error) {
+	if token == "" {
+		return nil, errMissingToken
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	key := kind + ":" + hex.EncodeToString(digest[:])
+
+	callerCache.Lock()
+	entry, ok := callerCache.entries[key]
+	callerCache.Unlock()
+
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := fetch(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	callerCache.Lock()
+	defer callerCache.Unlock()
+
+	now := time.Now()
+
+	for cachedKey, cached := range callerCache.entries {
+		if now.After(cached.expires) {
+			delete(callerCache.entries, cachedKey)
+		}
+	}
+
+	callerCache.entries[key] = &callerCacheEntry{value: value, expires: now.Add(cCallerCacheTTL)}
+
+	return value, nil
+}
+
+// getCallerOrgs returns the lower cased logins of the organizations the
+// owner of token is a member of, following the pages of the response. The
+// token needs the read:org scope to see private memberships.
+func getCallerOrgs(ctx context.Context, token string) ([]string, error) {
+	value, err := getCachedCallerValue(ctx, token, "orgs", func(ctx context.Context, token string) (interface{}, error) {
+		logins := []string{}
+		pageURL := cGithubUserOrgsURL
+
+		for page := 0; page < cMaxOrgPages && pageURL != ""; page++ {
+			var (
+				orgs []struct {
+					Login string `json:"login"`
+				}
+				err error
+			)
+
+			if pageURL, err = sendUserAPIRequest(ctx, token, pageURL, &orgs); err != nil {
+				return nil, err
+			}
+
+			for _, org := range orgs {
+				logins = append(logins, strings.ToLower(org.Login))
+			}
+		}
+
+		return logins, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return value.([]string), nil
+}
+
+// getCallerLogin returns the lower cased login of the owner of token.
+func getCallerLogin(ctx context.Context, token string) (string, error) {
+	value, err := getCachedCallerValue(ctx, token, "login", func(ctx context.Context, token string) (interface{}, error) {
+		var user struct {
+			Login string `json:"login"`
+		}
+
+		if _, err := sendUserAPIRequest(ctx, token, cGithubUserURL, &user); err != nil {
+			return nil, err
+		}
+
+		return strings.ToLower(user.Login), nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return value.(string), nil
+}
@@ -15,12 +15,12 @@ const (
 	cErrorTypeNet = "net"
 )
 
-func saveLog(r *http.Request) {
+func saveLog(ctx context.Context, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		log.Panicf("error while parsing form: %v", err)
 	}
-	ctx := context.Background()
-	client, err := bigquery.NewClient(ctx, "github-macros")
+
+	client, err := newBigQueryClient(ctx)
 
 	if err != nil {
 		log.Panicf("error while running bigquery.NewClient: %v", err)
@@ -73,9 +73,14 @@ func saveLog(r *http.Request) {
 }
 
 func ClientError(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if allowCORS(w, r) {
+		return
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
 
-	saveLog(r)
+	saveLog(ctx, r)
 
 	_, err := fmt.Fprint(w, "OK")
 
@@ -0,0 +1,468 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	cMaxCollectionNameLength        = 64
+	cMaxCollectionDescriptionLength = 280
+	cMaxCollectionSearchResults     = 20
+	cMaxSubscribedMacros            = 100
+)
+
+// Actions of the Collection function.
+const (
+	cCollectionActionCreate      = "create"
+	cCollectionActionUpdate      = "update"
+	cCollectionActionPublish     = "publish"
+	cCollectionActionUnpublish   = "unpublish"
+	cCollectionActionDelete      = "delete"
+	cCollectionActionAddMacro    = "add_macro"
+	cCollectionActionRemoveMacro = "remove_macro"
+	cCollectionActionSubscribe   = "subscribe"
+	cCollectionActionUnsubscribe = "unsubscribe"
+)
+
+// CollectionRow is a named group of macros. Only its owner can change it,
+// other users can subscribe to it once it's published. Macros is the number
+// of macros in the collection and Subscribed tells whether the caller is
+// subscribed to it.
+type CollectionRow struct {
+	ID          string `json:"id" bigquery:"id"`
+	Owner       string `json:"owner" bigquery:"owner_login"`
+	Name        string `json:"name" bigquery:"name"`
+	Description string `json:"description" bigquery:"description"`
+	Published   bool   `json:"published" bigquery:"published"`
+	Macros      int64  `json:"macros" bigquery:"macros"`
+	Subscribed  bool   `json:"subscribed" bigquery:"subscribed"`
+}
+
+// cCollectionColumns selects a CollectionRow out of collections C, with the
+// subscriptions of @user_login joined as S.
+const cCollectionColumns = `
+	C.id,
+	C.owner_login,
+	C.name,
+	IFNULL(C.description, '') AS description,
+	C.published,
+	(SELECT COUNT(*) FROM github-macros.macros.collection_macros CM WHERE CM.collection_id = C.id) AS macros,
+	S.user_login IS NOT NULL AS subscribed
+`
+
+// cCollectionMacrosFilter restricts a query of Macros to the macros of
+// @collection, when it's set, provided the collection is published or owned
+// by @user_login.
+const cCollectionMacrosFilter = `(@collection = '' OR Macros.name IN (
+	SELECT CM.macro_name
+	FROM github-macros.macros.collection_macros CM
+	JOIN github-macros.macros.collections C
+	ON C.id = CM.collection_id
+	WHERE C.id = @collection AND (C.published OR C.owner_login = @user_login)
+))`
+
+func validateCollectionFields(name, description string) ErrorCode {
+	if strings.TrimSpace(name) == "" || utf8.RuneCountInString(name) > cMaxCollectionNameLength {
+		return InvalidCollectionName
+	}
+
+	if utf8.RuneCountInString(description) > cMaxCollectionDescriptionLength {
+		return InvalidCollectionDescription
+	}
+
+	return Success
+}
+
+func getCollectionRows(ctx context.Context, query *bigquery.Query) []*CollectionRow {
+	iter, err := runQuery(ctx, query)
+	if err != nil {
+		log.Panicf("failed to run query: %v", err)
+	}
+
+	rows := []*CollectionRow{}
+
+	for {
+		var row CollectionRow
+
+		err = iter.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+
+		if err != nil {
+			log.Panicf("failed to read query result: %v", err)
+		}
+
+		rows = append(rows, &row)
+	}
+
+	return rows
+}
+
+// queryCollection returns the collection if it's owned by login, or
+// published when ownedOnly is false.
+func queryCollection(ctx context.Context, client *bigquery.Client, id, login string, ownedOnly bool) *CollectionRow {
+	query := client.Query(`
+		SELECT ` + cCollectionColumns + `
+		FROM github-macros.macros.collections C
+		LEFT JOIN github-macros.macros.collection_subscriptions S
+		ON S.collection_id = C.id AND S.user_login = @user_login
+		WHERE C.id = @id AND (C.owner_login = @user_login OR (C.published AND NOT @owned_only))
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "id",
+			Value: id,
+		},
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "owned_only",
+			Value: ownedOnly,
+		},
+	}
+
+	rows := getCollectionRows(ctx, query)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return rows[0]
+}
+
+// queryCollections returns the collections owned by or subscribed to by
+// login. When text is set, the published collections whose name contains it
+// are returned instead.
+func queryCollections(ctx context.Context, client *bigquery.Client, login, text string) []*CollectionRow {
+	query := client.Query(`
+		SELECT ` + cCollectionColumns + `
+		FROM github-macros.macros.collections C
+		LEFT JOIN github-macros.macros.collection_subscriptions S
+		ON S.collection_id = C.id AND S.user_login = @user_login
+		WHERE
+			(@text = '' AND (C.owner_login = @user_login OR S.user_login IS NOT NULL))
+			OR (@text != '' AND C.published AND LOWER(C.name) LIKE LOWER(@pattern))
+		ORDER BY macros DESC, C.name
+		LIMIT @limit
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "text",
+			Value: text,
+		},
+		{
+			Name:  "pattern",
+			Value: "%" + text + "%",
+		},
+		{
+			Name:  "limit",
+			Value: cMaxCollectionSearchResults,
+		},
+	}
+
+	return getCollectionRows(ctx, query)
+}
+
+// querySubscribedMacros returns the macros of the published collections
+// login is subscribed to, most recently added first.
+func querySubscribedMacros(ctx context.Context, client *bigquery.Client, login string, namespaces []string) []*MacroRow {
+	query := client.Query(`
+		SELECT
+			name,
+			github_url AS url,
+			width,
+			height,
+			` + cMacroMediaColumns + `
+		FROM (
+			SELECT CM.macro_name, MAX(CM.add_time) AS add_time
+			FROM github-macros.macros.collection_subscriptions S
+			JOIN github-macros.macros.collections C
+			ON C.id = S.collection_id
+			JOIN github-macros.macros.collection_macros CM
+			ON CM.collection_id = C.id
+			WHERE S.user_login = @user_login AND C.published
+			GROUP BY CM.macro_name
+		) Subscribed
+		JOIN github-macros.macros.macros Macros
+		ON Macros.name = Subscribed.macro_name
+		WHERE ` + cVisibleMacrosFilter + `
+		ORDER BY Subscribed.add_time DESC
+		LIMIT @limit
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "namespaces",
+			Value: namespaces,
+		},
+		{
+			Name:  "limit",
+			Value: cMaxSubscribedMacros,
+		},
+	}
+
+	return getQueryResults(ctx, query)
+}
+
+// runCollectionQuery runs a mutation of the collection tables, the
+// parameters are passed by name.
+func runCollectionQuery(ctx context.Context, client *bigquery.Client, sql string, params map[string]interface{}) {
+	query := client.Query(sql)
+
+	for name, value := range params {
+		query.Parameters = append(query.Parameters, bigquery.QueryParameter{Name: name, Value: value})
+	}
+
+	if _, err := runQuery(ctx, query); err != nil {
+		log.Panicf("failed to update collection: %v", err)
+	}
+}
+
+func createCollection(ctx context.Context, client *bigquery.Client, login, name, description string) *CollectionRow {
+	collection := &CollectionRow{
+		ID:          uuid.NewString(),
+		Owner:       login,
+		Name:        strings.TrimSpace(name),
+		Description: description,
+	}
+
+	runCollectionQuery(ctx, client, `
+		INSERT INTO github-macros.macros.collections
+		(id, owner_login, name, description, published, create_time, update_time)
+		VALUES (@id, @owner_login, @name, @description, FALSE, CURRENT_TIMESTAMP(), CURRENT_TIMESTAMP())
+	`, map[string]interface{}{
+		"id":          collection.ID,
+		"owner_login": collection.Owner,
+		"name":        collection.Name,
+		"description": collection.Description,
+	})
+
+	return collection
+}
+
+// executeCollectionAction applies the action of the request on behalf of
+// login, returning the changed collection along with the error code.
+func executeCollectionAction(
+	ctx context.Context,
+	client *bigquery.Client,
+	r *http.Request,
+	login string,
+) (*CollectionRow, ErrorCode) {
+	action := r.Form.Get("action")
+	name := r.Form.Get("name")
+	description := r.Form.Get("description")
+
+	if action == cCollectionActionCreate {
+		if errCode := validateCollectionFields(name, description); errCode != Success {
+			return nil, errCode
+		}
+
+		return createCollection(ctx, client, login, name, description), Success
+	}
+
+	id := r.Form.Get("id")
+	if id == "" {
+		return nil, MissingMandatoryFields
+	}
+
+	// only the owner may change a collection, anyone may subscribe to a published one
+	ownedOnly := action != cCollectionActionSubscribe && action != cCollectionActionUnsubscribe
+
+	collection := queryCollection(ctx, client, id, login, ownedOnly)
+	if collection == nil {
+		return nil, CollectionNotFound
+	}
+
+	params := map[string]interface{}{
+		"id":         id,
+		"user_login": login,
+	}
+
+	switch action {
+	case cCollectionActionUpdate:
+		if errCode := validateCollectionFields(name, description); errCode != Success {
+			return nil, errCode
+		}
+
+		params["name"] = strings.TrimSpace(name)
+		params["description"] = description
+
+		runCollectionQuery(ctx, client, `
+			UPDATE github-macros.macros.collections
+			SET name = @name, description = @description, update_time = CURRENT_TIMESTAMP()
+			WHERE id = @id AND owner_login = @user_login
+		`, params)
+	case cCollectionActionPublish, cCollectionActionUnpublish:
+		params["published"] = action == cCollectionActionPublish
+
+		runCollectionQuery(ctx, client, `
+			UPDATE github-macros.macros.collections
+			SET published = @published, update_time = CURRENT_TIMESTAMP()
+			WHERE id = @id AND owner_login = @user_login
+		`, params)
+	case cCollectionActionDelete:
+		runCollectionQuery(ctx, client, `
+			DELETE FROM github-macros.macros.collections WHERE id = @id AND owner_login = @user_login;
+			DELETE FROM github-macros.macros.collection_macros WHERE collection_id = @id;
+			DELETE FROM github-macros.macros.collection_subscriptions WHERE collection_id = @id;
+		`, params)
+
+		return nil, Success
+	case cCollectionActionAddMacro, cCollectionActionRemoveMacro:
+		macroName := r.Form.Get("macro_name")
+		if macroName == "" {
+			return nil, MissingMandatoryFields
+		}
+
+		params["macro_name"] = macroReference(splitMacroReference(macroName))
+
+		if action == cCollectionActionRemoveMacro {
+			runCollectionQuery(ctx, client, `
+				DELETE FROM github-macros.macros.collection_macros
+				WHERE collection_id = @id AND macro_name = @macro_name
+			`, params)
+
+			break
+		}
+
+		if !isMacroVisible(ctx, client, params["macro_name"].(string), getCallerNamespaces(ctx, getCallerToken(r))) {
+			return nil, MacroNotFound
+		}
+
+		runCollectionQuery(ctx, client, `
+			MERGE github-macros.macros.collection_macros CM
+			USING (SELECT @id AS collection_id, @macro_name AS macro_name) N
+			ON CM.collection_id = N.collection_id AND CM.macro_name = N.macro_name
+			WHEN NOT MATCHED THEN
+				INSERT (collection_id, macro_name, add_time)
+				VALUES (N.collection_id, N.macro_name, CURRENT_TIMESTAMP())
+		`, params)
+	case cCollectionActionSubscribe:
+		if !collection.Published {
+			return nil, CollectionNotFound
+		}
+
+		runCollectionQuery(ctx, client, `
+			MERGE github-macros.macros.collection_subscriptions S
+			USING (SELECT @id AS collection_id, @user_login AS user_login) N
+			ON S.collection_id = N.collection_id AND S.user_login = N.user_login
+			WHEN NOT MATCHED THEN
+				INSERT (collection_id, user_login, create_time)
+				VALUES (N.collection_id, N.user_login, CURRENT_TIMESTAMP())
+		`, params)
+	case cCollectionActionUnsubscribe:
+		runCollectionQuery(ctx, client, `
+			DELETE FROM github-macros.macros.collection_subscriptions
+			WHERE collection_id = @id AND user_login = @user_login
+		`, params)
+	default:
+		return nil, MissingMandatoryFields
+	}
+
+	return queryCollection(ctx, client, id, login, false), Success
+}
+
+// collectionListResponse is a list of collections.
+type collectionListResponse struct {
+	Code ErrorCode        `json:"code"`
+	Data []*CollectionRow `json:"data"`
+}
+
+// collectionResponse is the response of a collection action, Data is the
+// collection it changed, if it still exists.
+type collectionResponse struct {
+	Code ErrorCode      `json:"code"`
+	Data *CollectionRow `json:"data,omitempty"`
+}
+
+// getCollectionsResponse lists the collections of the caller, or searches
+// the published collections by name when text is set.
+func getCollectionsResponse(ctx context.Context, client *bigquery.Client, token, text string) (string, error) {
+	login := ""
+
+	if token != "" {
+		var errCode ErrorCode
+
+		if login, errCode = getAuthenticatedLogin(ctx, token); errCode != Success {
+			return getCollectionsResponseString(&collectionListResponse{Code: errCode, Data: []*CollectionRow{}})
+		}
+	}
+
+	if login == "" && text == "" {
+		return getCollectionsResponseString(&collectionListResponse{Code: AuthenticationRequired, Data: []*CollectionRow{}})
+	}
+
+	return getCollectionsResponseString(&collectionListResponse{
+		Code: Success,
+		Data: queryCollections(ctx, client, login, text),
+	})
+}
+
+func getCollectionsResponseString(responseValue interface{}) (string, error) {
+	response, err := json.Marshal(responseValue)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling results: %v", err)
+	}
+
+	return string(response), nil
+}
+
+// Collection creates, changes, publishes and subscribes to collections of
+// macros, according to the action field of the request. The caller is
+// identified by their GitHub token.
+func Collection(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Panicf("failed to parse form: %v", err)
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	response := &collectionResponse{}
+
+	login, errCode := getAuthenticatedLogin(ctx, getCallerToken(r))
+	if errCode == Success {
+		client, err := newBigQueryClient(ctx)
+		if err != nil {
+			log.Panicf("failed to create bigquery client: %v", err)
+		}
+		defer client.Close()
+
+		response.Data, errCode = executeCollectionAction(ctx, client, r, login)
+	}
+
+	response.Code = errCode
+
+	responseString, err := getCollectionsResponseString(response)
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	if _, err = fmt.Fprint(w, responseString); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func newCollectionRequest(t *testing.T, form url.Values, token string) *http.Request {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodPost, "/collection", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if token != "" {
+		r.Header.Set("Authorization", "token "+token)
+	}
+
+	if err := r.ParseForm(); err != nil {
+		t.Fatalf("failed to parse form: %v", err)
+	}
+
+	return r
+}
+
+func TestValidateCollectionFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        ErrorCode
+	}{
+		{"reviews", "", Success},
+		{"reviews", strings.Repeat("é", cMaxCollectionDescriptionLength), Success},
+		{strings.Repeat("é", cMaxCollectionNameLength), "", Success},
+		{"", "", InvalidCollectionName},
+		{"   ", "", InvalidCollectionName},
+		{strings.Repeat("a", cMaxCollectionNameLength+1), "", InvalidCollectionName},
+		{"reviews", strings.Repeat("a", cMaxCollectionDescriptionLength+1), InvalidCollectionDescription},
+	}
+
+	for _, test := range tests {
+		if got := validateCollectionFields(test.name, test.description); got != test.want {
+			t.Errorf("validateCollectionFields(%q, %d runes) = %d, want %d", test.name, len([]rune(test.description)), got, test.want)
+		}
+	}
+}
+
+func TestExecuteCollectionAction(t *testing.T) {
+	useFakeBigQuery(t)
+	cacheTestCaller(t, "collection-token", "octocat", []string{})
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		form     url.Values
+		want     ErrorCode
+		wantData bool
+	}{
+		{url.Values{"action": {cCollectionActionCreate}, "name": {"  reviews "}}, Success, true},
+		{url.Values{"action": {cCollectionActionCreate}, "name": {" "}}, InvalidCollectionName, false},
+		{url.Values{"action": {cCollectionActionUpdate}, "name": {"reviews"}}, MissingMandatoryFields, false},
+		{url.Values{"action": {cCollectionActionUpdate}, "id": {cMissingValue}, "name": {"reviews"}}, CollectionNotFound, false},
+		{url.Values{"action": {cCollectionActionUpdate}, "id": {"c1"}, "name": {""}}, InvalidCollectionName, false},
+		{url.Values{"action": {cCollectionActionUpdate}, "id": {"c1"}, "name": {"reviews"}}, Success, true},
+		{url.Values{"action": {cCollectionActionPublish}, "id": {"c1"}}, Success, true},
+		{url.Values{"action": {cCollectionActionDelete}, "id": {"c1"}}, Success, false},
+		{url.Values{"action": {cCollectionActionAddMacro}, "id": {"c1"}}, MissingMandatoryFields, false},
+		{url.Values{"action": {cCollectionActionAddMacro}, "id": {"c1"}, "macro_name": {cMissingValue}}, MacroNotFound, false},
+		{url.Values{"action": {cCollectionActionAddMacro}, "id": {"c1"}, "macro_name": {"lgtm"}}, Success, true},
+		{url.Values{"action": {cCollectionActionRemoveMacro}, "id": {"c1"}, "macro_name": {"lgtm"}}, Success, true},
+		// the collection the fake BigQuery finds isn't published
+		{url.Values{"action": {cCollectionActionSubscribe}, "id": {"c1"}}, CollectionNotFound, false},
+		{url.Values{"action": {cCollectionActionUnsubscribe}, "id": {"c1"}}, Success, true},
+		{url.Values{"action": {"rename"}, "id": {"c1"}}, MissingMandatoryFields, false},
+	}
+
+	for _, test := range tests {
+		r := newCollectionRequest(t, test.form, "collection-token")
+
+		collection, got := executeCollectionAction(ctx, client, r, "octocat")
+		if got != test.want || (collection != nil) != test.wantData {
+			t.Errorf("executeCollectionAction(%v) = %+v, %d, want data %v, %d", test.form, collection, got, test.wantData, test.want)
+		}
+	}
+
+	r := newCollectionRequest(t, url.Values{"action": {cCollectionActionCreate}, "name": {"  reviews "}}, "collection-token")
+
+	collection, _ := executeCollectionAction(ctx, client, r, "octocat")
+	if collection == nil || collection.ID == "" || collection.Name != "reviews" || collection.Owner != "octocat" || collection.Published {
+		t.Errorf("executeCollectionAction(create) = %+v, want an unpublished collection named reviews owned by octocat", collection)
+	}
+}
+
+func TestCollectionRequiresAuthentication(t *testing.T) {
+	useFakeBigQuery(t)
+
+	w := httptest.NewRecorder()
+	Collection(w, newCollectionRequest(t, url.Values{"action": {cCollectionActionCreate}, "name": {"reviews"}}, ""))
+
+	var response collectionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Code != AuthenticationRequired || response.Data != nil {
+		t.Errorf("Collection() without a token = %s, want code %d", w.Body.String(), AuthenticationRequired)
+	}
+}
+
+func TestGetCollectionsResponse(t *testing.T) {
+	useFakeBigQuery(t)
+	cacheTestCaller(t, "collections-token", "octocat", []string{})
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		token string
+		text  string
+		want  ErrorCode
+		rows  int
+	}{
+		{"", "", AuthenticationRequired, 0},
+		{"", "review", Success, 1},
+		{"", cMissingValue, Success, 0},
+		{"collections-token", "", Success, 1},
+	}
+
+	for _, test := range tests {
+		encoded, err := getCollectionsResponse(ctx, client, test.token, test.text)
+		if err != nil {
+			t.Fatalf("getCollectionsResponse() = %v", err)
+		}
+
+		var response collectionListResponse
+		if err := json.Unmarshal([]byte(encoded), &response); err != nil {
+			t.Fatalf("invalid response %s: %v", encoded, err)
+		}
+
+		if response.Code != test.want || len(response.Data) != test.rows {
+			t.Errorf("getCollectionsResponse(%q, %q) = %s, want code %d and %d rows", test.token, test.text, encoded, test.want, test.rows)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package p
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	cCursorSecretEnv = "CURSOR_SECRET"
+	cMaxPageSizeEnv  = "MAX_PAGE_SIZE"
+	cDefaultMaxPage  = 100
+	cCursorSeparator = "."
+)
+
+var errInvalidCursor = errors.New("cursor is invalid")
+
+var missingCursorSecretOnce sync.Once
+
+// pageCursor is the sort key of the last macro of a page, the next page
+// starts right after it. Query identifies the query the cursor was issued
+// for, so it can't be replayed against another one.
+type pageCursor struct {
+	Usages int64  `json:"u"`
+	Name   string `json:"n"`
+	Query  string `json:"q"`
+}
+
+// getCursorSecret returns the key cursors are signed with, nil when
+// CURSOR_SECRET isn't set. The key must be shared by all the instances, a
+// cursor may be sent back to any of them, so without it no cursors are issued
+// and clients page by number.
+func getCursorSecret() []byte {
+	secret := os.Getenv(cCursorSecretEnv)
+	if secret == "" {
+		missingCursorSecretOnce.Do(func() {
+			log.Printf("error: %s is not set, cursors are disabled and queries are paged by number", cCursorSecretEnv)
+		})
+
+		return nil
+	}
+
+	return []byte(secret)
+}
+
+func signCursorPayload(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getQueryFingerprint identifies the results a cursor pages through.
+func getQueryFingerprint(macroQuery *macroQuery, collection string) string {
+	digest := sha256.Sum256([]byte(strings.Join([]string{macroQuery.Type, macroQuery.Text, collection}, "\x00")))
+
+	return hex.EncodeToString(digest[:8])
+}
+
+// encodeCursor returns the opaque, signed form of cursor, or an empty string
+// when cursors are disabled.
+func encodeCursor(cursor *pageCursor) string {
+	secret := getCursorSecret()
+	if secret == nil {
+		return ""
+	}
+
+	payloadBytes, err := json.Marshal(cursor)
+	if err != nil {
+		log.Panicf("failed to encode cursor: %v", err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(payloadBytes)
+
+	return payload + cCursorSeparator + signCursorPayload(secret, payload)
+}
+
+// decodeCursor verifies the signature of an encoded cursor and that it was
+// issued for the query with fingerprint. No cursor is valid when cursors are
+// disabled.
+func decodeCursor(encoded, fingerprint string) (*pageCursor, error) {
+	secret := getCursorSecret()
+	parts := strings.Split(encoded, cCursorSeparator)
+
+	if secret == nil || len(parts) != 2 {
+		return nil, errInvalidCursor
+	}
+
+	if !hmac.Equal([]byte(signCursorPayload(secret, parts[0])), []byte(parts[1])) {
+		return nil, errInvalidCursor
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCursor
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(payloadBytes, &cursor); err != nil || cursor.Query != fingerprint {
+		return nil, errInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// getMaxPageSize returns the largest page a query may ask for.
+func getMaxPageSize() int {
+	maxPageSize, err := strconv.Atoi(os.Getenv(cMaxPageSizeEnv))
+	if err != nil || maxPageSize <= 0 {
+		return cDefaultMaxPage
+	}
+
+	return maxPageSize
+}
+
+// getPageSize returns the requested page size, the default one when it's not
+// set and the maximal one when it's too large.
+func getPageSize(limit int) int {
+	if limit <= 0 {
+		return resultsPerPage
+	}
+
+	if maxPageSize := getMaxPageSize(); limit > maxPageSize {
+		return maxPageSize
+	}
+
+	return limit
+}
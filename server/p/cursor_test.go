@@ -0,0 +1,82 @@
+package p
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCursor(t *testing.T) {
+	setTestEnv(t, cCursorSecretEnv, "test-secret")
+
+	searchQuery := getQueryFingerprint(&macroQuery{Type: queryTypeSearch, Text: "dog"}, "")
+	otherQuery := getQueryFingerprint(&macroQuery{Type: queryTypeSearch, Text: "cat"}, "")
+	collectionQuery := getQueryFingerprint(&macroQuery{Type: queryTypeSearch, Text: "dog"}, "pets")
+
+	cursor := &pageCursor{Usages: 42, Name: "dog-wave", Query: searchQuery}
+	encoded := encodeCursor(cursor)
+	payload := strings.SplitN(encoded, cCursorSeparator, 2)[0]
+
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(`{"u":0,"n":"dog-wave","q":"` + searchQuery + `"}`))
+	flippedSignature := encoded[:len(encoded)-1] + "A"
+
+	if strings.HasSuffix(encoded, "A") {
+		flippedSignature = encoded[:len(encoded)-1] + "B"
+	}
+
+	notJSON := "bm90IGpzb24"
+
+	tests := []struct {
+		name        string
+		encoded     string
+		fingerprint string
+		err         error
+	}{
+		{"valid", encoded, searchQuery, nil},
+		{"tampered payload", tamperedPayload + cCursorSeparator + strings.SplitN(encoded, cCursorSeparator, 2)[1], searchQuery, errInvalidCursor},
+		{"tampered signature", flippedSignature, searchQuery, errInvalidCursor},
+		{"unsigned", payload, searchQuery, errInvalidCursor},
+		{"other query", encoded, otherQuery, errInvalidCursor},
+		{"other collection", encoded, collectionQuery, errInvalidCursor},
+		{"empty", "", searchQuery, errInvalidCursor},
+		{"garbage", "not a cursor", searchQuery, errInvalidCursor},
+		{"extra part", encoded + cCursorSeparator + "x", searchQuery, errInvalidCursor},
+		{"invalid base64", "!!!" + cCursorSeparator + signCursorPayload([]byte("test-secret"), "!!!"), searchQuery, errInvalidCursor},
+		{"invalid json", notJSON + cCursorSeparator + signCursorPayload([]byte("test-secret"), notJSON), searchQuery, errInvalidCursor},
+	}
+
+	for _, test := range tests {
+		decoded, err := decodeCursor(test.encoded, test.fingerprint)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: decodeCursor = %v, want %v", test.name, err, test.err)
+			continue
+		}
+
+		if err == nil && *decoded != *cursor {
+			t.Errorf("%s: decodeCursor = %+v, want %+v", test.name, decoded, cursor)
+		}
+	}
+}
+
+func TestCursorsWithoutSecret(t *testing.T) {
+	setTestEnv(t, cCursorSecretEnv, "test-secret")
+
+	fingerprint := getQueryFingerprint(&macroQuery{Type: queryTypeSuggestion}, "")
+	encoded := encodeCursor(&pageCursor{Name: "lgtm", Query: fingerprint})
+
+	setTestEnv(t, cCursorSecretEnv, "")
+
+	if cursor := encodeCursor(&pageCursor{Name: "lgtm", Query: fingerprint}); cursor != "" {
+		t.Errorf("encodeCursor without a secret = %q, want no cursor", cursor)
+	}
+
+	if _, err := decodeCursor(encoded, fingerprint); !errors.Is(err, errInvalidCursor) {
+		t.Errorf("decodeCursor without a secret = %v, want %v", err, errInvalidCursor)
+	}
+
+	response := getMacroListResponse(&macroQuery{Type: queryTypeSuggestion, Page: 2}, &macroQueryResult{HasMore: true})
+	if response.NextCursor != "" || response.NextPage != 3 {
+		t.Errorf("next page without a secret = %q, %d, want page 3", response.NextCursor, response.NextPage)
+	}
+}
@@ -0,0 +1,347 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	cMacroAltPrefix     = "github-macros-"
+	cMinFenceLength     = 3
+	cMaxFenceIndent     = 3
+	cMaxExpandableNames = 200
+)
+
+// macroTokenPattern matches $name$ and $org/name$ macro references.
+var macroTokenPattern = regexp.MustCompile(`\$((?:[A-Za-z0-9][A-Za-z0-9-]{0,38}/)?[A-Za-z0-9][A-Za-z0-9_-]*)\$`)
+
+// codeFence returns the fence character and length if line opens or closes
+// a fenced code block.
+func codeFence(line string) (byte, int) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > cMaxFenceIndent || trimmed == "" {
+		return 0, 0
+	}
+
+	fenceChar := trimmed[0]
+	if fenceChar != '`' && fenceChar != '~' {
+		return 0, 0
+	}
+
+	length := 0
+	for length < len(trimmed) && trimmed[length] == fenceChar {
+		length++
+	}
+
+	if length < cMinFenceLength {
+		return 0, 0
+	}
+
+	return fenceChar, length
+}
+
+// mapInlineText applies fn to the parts of line outside of code spans. A code
+// span starts with a run of backticks and ends with a run of the same length,
+// an unmatched run is plain text.
+func mapInlineText(line string, fn func(text string) string) string {
+	var result strings.Builder
+
+	textStart := 0
+
+	for i := 0; i < len(line); {
+		if line[i] != '`' {
+			i++
+			continue
+		}
+
+		runLength := 0
+		for i+runLength < len(line) && line[i+runLength] == '`' {
+			runLength++
+		}
+
+		closing := -1
+
+		for j := i + runLength; j < len(line); {
+			if line[j] != '`' {
+				j++
+				continue
+			}
+
+			closingLength := 0
+			for j+closingLength < len(line) && line[j+closingLength] == '`' {
+				closingLength++
+			}
+
+			if closingLength == runLength {
+				closing = j
+				break
+			}
+
+			j += closingLength
+		}
+
+		if closing < 0 {
+			i += runLength
+			continue
+		}
+
+		result.WriteString(fn(line[textStart:i]))
+		result.WriteString(line[i : closing+runLength])
+
+		i = closing + runLength
+		textStart = i
+	}
+
+	result.WriteString(fn(line[textStart:]))
+
+	return result.String()
+}
+
+// isIndentedCode reports whether line is indented enough to be part of an
+// indented code block.
+func isIndentedCode(line string) bool {
+	return strings.HasPrefix(line, "\t") || strings.HasPrefix(line, strings.Repeat(" ", cMaxFenceIndent+1))
+}
+
+// mapMarkdownText applies fn to the text of markdown that isn't code: fenced
+// and indented code blocks and inline code spans are kept as is. The lines of
+// a paragraph are mapped together since code spans may span line breaks.
+func mapMarkdownText(markdown string, fn func(text string) string) string {
+	var (
+		result      strings.Builder
+		paragraph   strings.Builder
+		fenceChar   byte
+		fenceLength int
+	)
+
+	flushParagraph := func() {
+		if paragraph.Len() > 0 {
+			result.WriteString(mapInlineText(paragraph.String(), fn))
+			paragraph.Reset()
+		}
+	}
+
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		content := strings.TrimRight(line, "\r\n")
+		char, length := codeFence(content)
+
+		switch {
+		case fenceChar != 0:
+			// a closing fence has the same character, at least as long and no info string
+			if char == fenceChar && length >= fenceLength &&
+				strings.Trim(strings.TrimSpace(line), string(fenceChar)) == "" {
+				fenceChar = 0
+			}
+
+			result.WriteString(line)
+		case char != 0:
+			flushParagraph()
+			fenceChar, fenceLength = char, length
+			result.WriteString(line)
+		case strings.TrimSpace(content) == "":
+			flushParagraph()
+			result.WriteString(line)
+		case paragraph.Len() == 0 && isIndentedCode(content):
+			// indented code can't interrupt a paragraph, an indented line
+			// inside of one is a continuation line
+			result.WriteString(line)
+		default:
+			paragraph.WriteString(line)
+		}
+	}
+
+	flushParagraph()
+
+	return result.String()
+}
+
+// findMacroReferences returns the distinct macro references in markdown, in
+// order of appearance.
+func findMacroReferences(markdown string) []string {
+	references := []string{}
+	seen := map[string]bool{}
+
+	mapMarkdownText(markdown, func(text string) string {
+		for _, match := range macroTokenPattern.FindAllStringSubmatch(text, -1) {
+			if !seen[match[1]] {
+				seen[match[1]] = true
+				references = append(references, match[1])
+			}
+		}
+
+		return text
+	})
+
+	return references
+}
+
+// macroImageTag renders the macro like the extension does, with its alt text
+// prefixed so the extension can tell macros apart from other images.
+func macroImageTag(macro *MacroRow) string {
+	return fmt.Sprintf(
+		`<img src="%s" alt="%s" width="%d" height="%d">`,
+		html.EscapeString(macro.URL),
+		html.EscapeString(cMacroAltPrefix+macro.Name),
+		macro.Width,
+		macro.Height,
+	)
+}
+
+// queryMacrosByReferences resolves the references visible from namespaces,
+// keyed by their reference.
+func queryMacrosByReferences(
+	ctx context.Context,
+	client *bigquery.Client,
+	references []string,
+	namespaces []string,
+) map[string]*MacroRow {
+	names := make([]string, 0, len(references))
+	for _, reference := range references {
+		names = append(names, macroReference(splitMacroReference(reference)))
+	}
+
+	query := client.Query(`
+		SELECT
+			name,
+			github_url AS url,
+			width,
+			height,
+			` + cMacroMediaColumns + `
+		FROM github-macros.macros.macros
+		WHERE name IN UNNEST(@names) AND ` + cVisibleMacrosFilter + `
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "names",
+			Value: names,
+		},
+		{
+			Name:  "namespaces",
+			Value: namespaces,
+		},
+	}
+
+	macros := map[string]*MacroRow{}
+	for _, macro := range getQueryResults(ctx, query) {
+		macros[macro.Name] = macro
+	}
+
+	resolved := map[string]*MacroRow{}
+
+	for _, reference := range references {
+		if macro, ok := macros[macroReference(splitMacroReference(reference))]; ok {
+			resolved[reference] = macro
+		}
+	}
+
+	return resolved
+}
+
+// markdownExpansion is the outcome of expanding the macros of a markdown
+// block. Resolved holds the names of the macros that were expanded.
+type markdownExpansion struct {
+	Markdown   string
+	Resolved   []string
+	Unresolved []string
+}
+
+// expandResponse is the response of Expand, Unresolved holds the referenced
+// names that weren't found.
+type expandResponse struct {
+	Code       ErrorCode `json:"code"`
+	Markdown   string    `json:"markdown,omitempty"`
+	Unresolved []string  `json:"unresolved,omitempty"`
+}
+
+// expandMarkdown replaces the $name$ references of markdown outside of code
+// with image tags.
+func expandMarkdown(
+	ctx context.Context,
+	client *bigquery.Client,
+	markdown string,
+	namespaces []string,
+) (*markdownExpansion, ErrorCode) {
+	expansion := &markdownExpansion{Markdown: markdown, Resolved: []string{}, Unresolved: []string{}}
+
+	references := findMacroReferences(markdown)
+	if len(references) == 0 {
+		return expansion, Success
+	}
+
+	if len(references) > cMaxExpandableNames {
+		return nil, TooManyMacros
+	}
+
+	resolved := queryMacrosByReferences(ctx, client, references, namespaces)
+
+	for _, reference := range references {
+		if macro, ok := resolved[reference]; ok {
+			expansion.Resolved = append(expansion.Resolved, macro.Name)
+		} else {
+			expansion.Unresolved = append(expansion.Unresolved, reference)
+		}
+	}
+
+	expansion.Markdown = mapMarkdownText(markdown, func(text string) string {
+		return macroTokenPattern.ReplaceAllStringFunc(text, func(token string) string {
+			if macro, ok := resolved[strings.Trim(token, "$")]; ok {
+				return macroImageTag(macro)
+			}
+
+			return token
+		})
+	})
+
+	return expansion, Success
+}
+
+// Expand resolves all the $name$ references of the markdown field in a
+// single batch and returns the markdown with the macros replaced by sized
+// image tags, along with the names that couldn't be resolved. References in
+// code spans and code blocks are left alone.
+func Expand(w http.ResponseWriter, r *http.Request) {
+	if allowCORS(w, r) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Panicf("failed to parse form: %v", err)
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		log.Panicf("failed to create bigquery client: %v", err)
+	}
+	defer client.Close()
+
+	namespaces := getCallerNamespaces(ctx, getCallerToken(r))
+
+	expansion, errCode := expandMarkdown(ctx, client, r.Form.Get("markdown"), namespaces)
+
+	expandResponse := &expandResponse{Code: errCode}
+	if errCode == Success {
+		expandResponse.Markdown = expansion.Markdown
+		expandResponse.Unresolved = expansion.Unresolved
+	}
+
+	response, err := json.Marshal(expandResponse)
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	if _, err = fmt.Fprint(w, string(response)); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package p
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindMacroReferences(t *testing.T) {
+	tests := []struct {
+		name       string
+		markdown   string
+		references []string
+	}{
+		{"plain", "hello $lgtm$ and $ship-it$", []string{"lgtm", "ship-it"}},
+		{"duplicates", "$lgtm$ $lgtm$", []string{"lgtm"}},
+		{"namespaced", "$my-org/lgtm$ and $lgtm$", []string{"my-org/lgtm", "lgtm"}},
+		{"code span", "`$lgtm$` $ship$", []string{"ship"}},
+		{"nested backticks", "`` a ` $lgtm$ `` $ship$", []string{"ship"}},
+		{"unmatched backticks", "`` $lgtm$ `", []string{"lgtm"}},
+		{"code span across lines", "see `a\n$lgtm$` $ship$", []string{"ship"}},
+		{"code span ends with paragraph", "a `b\n\n$lgtm$`", []string{"lgtm"}},
+		{"backtick fence", "```\n$lgtm$\n```\n$ship$", []string{"ship"}},
+		{"tilde fence", "~~~go\n$lgtm$\n```\n$wip$\n~~~\n$ship$", []string{"ship"}},
+		{"longer closing fence", "````\n$lgtm$\n```\n$wip$\n`````\n$ship$", []string{"ship"}},
+		{"fence interrupts paragraph", "text\n```\n$lgtm$\n```", []string{}},
+		{"indented code", "text\n\n    $lgtm$\n\t$wip$\n\n$ship$", []string{"ship"}},
+		{"indented code at start", "    $lgtm$\n$ship$", []string{"ship"}},
+		{"indented continuation line", "text\n    $lgtm$", []string{"lgtm"}},
+		{"indented fence", "    ```\n$lgtm$", []string{"lgtm"}},
+		{"crlf", "```\r\n$lgtm$\r\n```\r\n$ship$\r\n", []string{"ship"}},
+	}
+
+	for _, test := range tests {
+		if references := findMacroReferences(test.markdown); !reflect.DeepEqual(references, test.references) {
+			t.Errorf("%s: findMacroReferences(%q) = %q, want %q", test.name, test.markdown, references, test.references)
+		}
+	}
+}
+
+func TestMapMarkdownTextKeepsCode(t *testing.T) {
+	markdown := "a $x$\n\n```\n$x$\n```\n\n    $x$\n\n`$x\n$` $x$\n"
+	want := "a X\n\n```\n$x$\n```\n\n    $x$\n\n`$x\n$` X\n"
+
+	got := mapMarkdownText(markdown, func(text string) string {
+		return strings.ReplaceAll(text, "$x$", "X")
+	})
+
+	if got != want {
+		t.Errorf("mapMarkdownText(%q) = %q, want %q", markdown, got, want)
+	}
+}
@@ -0,0 +1,294 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	cFetchDialTimeout       = 5 * time.Second
+	cFetchTLSTimeout        = 5 * time.Second
+	cFetchHeaderTimeout     = 10 * time.Second
+	cFetchMaxRedirects      = 5
+	cFetchAllowedHostsEnv   = "FETCH_ALLOWED_HOSTS"
+	cFetchDeniedHostsEnv    = "FETCH_DENIED_HOSTS"
+	cFetchHostListSeparator = ","
+)
+
+var (
+	errSchemeNotAllowed  = errors.New("URL scheme is not allowed")
+	errHostNotAllowed    = errors.New("URL host is not allowed")
+	errAddressNotAllowed = errors.New("destination address is not allowed")
+	errTooManyRedirects  = errors.New("too many redirects")
+)
+
+var allowedSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+// blockedNetworks lists every range we never want to reach from the server:
+// loopback, private, link-local (including cloud metadata endpoints),
+// carrier-grade NAT, multicast and reserved addresses. IPv6 ranges embedding
+// IPv4 addresses (6to4, NAT64) are blocked as a whole, since they can wrap
+// any of the IPv4 ones.
+var blockedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.0.2.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"198.51.100.0/24",
+	"203.0.113.0/24",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"64:ff9b:1::/48",
+	"100::/64",
+	"2001:db8::/32",
+	"2002::/16",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+var (
+	safeHTTPClientOnce sync.Once
+	safeHTTPClient     *http.Client
+	mediaHTTPClient    *http.Client
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CIDR %q: %v", cidr, err))
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+func isDisallowedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hostListFromEnv(name string) []string {
+	hosts := []string{}
+
+	for _, host := range strings.Split(os.Getenv(name), cFetchHostListSeparator) {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts = append(hosts, strings.TrimPrefix(host, "."))
+		}
+	}
+
+	return hosts
+}
+
+// hostMatches returns true if host equals one of the patterns or is a
+// subdomain of one of them.
+func hostMatches(host string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func getFetchHost(u *url.URL) string {
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// validateFetchURL checks the URL of any outbound request: it must be http(s)
+// and must not point at a blocked address.
+func validateFetchURL(u *url.URL) error {
+	if !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return errSchemeNotAllowed
+	}
+
+	host := getFetchHost(u)
+	if host == "" {
+		return errHostNotAllowed
+	}
+
+	if ip := net.ParseIP(host); ip != nil && isDisallowedIP(ip) {
+		return errAddressNotAllowed
+	}
+
+	return nil
+}
+
+// validateMediaURL checks the URL of media supplied by users, which is also
+// limited to the hosts FETCH_ALLOWED_HOSTS and FETCH_DENIED_HOSTS permit.
+// Requests the server makes to GitHub on its own aren't subject to the lists.
+func validateMediaURL(u *url.URL) error {
+	if err := validateFetchURL(u); err != nil {
+		return err
+	}
+
+	host := getFetchHost(u)
+
+	if hostMatches(host, hostListFromEnv(cFetchDeniedHostsEnv)) {
+		return errHostNotAllowed
+	}
+
+	if allowed := hostListFromEnv(cFetchAllowedHostsEnv); len(allowed) > 0 && !hostMatches(host, allowed) {
+		return errHostNotAllowed
+	}
+
+	return nil
+}
+
+// checkDialAddress runs after DNS resolution, right before the connection is
+// made, so a host that resolves to a public address during validation and to
+// an internal one when dialing (DNS rebinding) is still blocked.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errAddressNotAllowed, err)
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isDisallowedIP(ip) {
+		return fmt.Errorf("%w: %s", errAddressNotAllowed, address)
+	}
+
+	return nil
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= cFetchMaxRedirects {
+		return errTooManyRedirects
+	}
+
+	return validateFetchURL(req.URL)
+}
+
+func checkMediaRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= cFetchMaxRedirects {
+		return errTooManyRedirects
+	}
+
+	return validateMediaURL(req.URL)
+}
+
+// initSafeHTTPClients creates the clients used for every outbound request the
+// server makes. They only talk to public addresses over http(s) and
+// re-validate every redirect hop, the media client also checks the host lists.
+// Callers bound the total time through the request context.
+func initSafeHTTPClients() {
+	safeHTTPClientOnce.Do(func() {
+		dialer := &net.Dialer{
+			Timeout: cFetchDialTimeout,
+			Control: checkDialAddress,
+		}
+
+		transport := &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cFetchTLSTimeout,
+			ResponseHeaderTimeout: cFetchHeaderTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		}
+
+		safeHTTPClient = &http.Client{Transport: transport, CheckRedirect: checkRedirect}
+		mediaHTTPClient = &http.Client{Transport: transport, CheckRedirect: checkMediaRedirect}
+	})
+}
+
+// doSafeRequest validates the request URL and sends it using the safe client.
+func doSafeRequest(req *http.Request) (*http.Response, error) {
+	if err := validateFetchURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	initSafeHTTPClients()
+
+	return safeHTTPClient.Do(req)
+}
+
+// doMediaRequest sends a request for media supplied by a user, limited to the
+// allowed hosts.
+func doMediaRequest(req *http.Request) (*http.Response, error) {
+	if err := validateMediaURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	initSafeHTTPClients()
+
+	return mediaHTTPClient.Do(req)
+}
+
+// fetchStatusError is returned when a download responds with a status other
+// than 2xx.
+type fetchStatusError struct {
+	StatusCode int
+}
+
+func (e *fetchStatusError) Error() string {
+	return fmt.Sprintf("fetch failed with status %d", e.StatusCode)
+}
+
+// checkFetchStatus rejects responses whose status isn't 2xx, so error pages
+// aren't mistaken for the requested content.
+func checkFetchStatus(resp *http.Response) error {
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return &fetchStatusError{StatusCode: resp.StatusCode}
+	}
+
+	return nil
+}
+
+func isFetchStatusError(err error) bool {
+	var statusErr *fetchStatusError
+
+	return errors.As(err, &statusErr)
+}
+
+// isTransientFetchStatusError reports whether the download failed with a
+// status that may succeed when retried.
+func isTransientFetchStatusError(err error) bool {
+	var statusErr *fetchStatusError
+
+	return errors.As(err, &statusErr) &&
+		(statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests)
+}
+
+func isFetchBlockedError(err error) bool {
+	return errors.Is(err, errSchemeNotAllowed) ||
+		errors.Is(err, errHostNotAllowed) ||
+		errors.Is(err, errAddressNotAllowed)
+}
@@ -0,0 +1,247 @@
+package p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// setTestEnv sets an environment variable for the duration of the test.
+func setTestEnv(t *testing.T, name, value string) {
+	previous, existed := os.LookupEnv(name)
+
+	if err := os.Setenv(name, value); err != nil {
+		t.Fatalf("failed to set %s: %v", name, err)
+	}
+
+	t.Cleanup(func() {
+		if existed {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestIsDisallowedIP(t *testing.T) {
+	tests := []struct {
+		ip         string
+		disallowed bool
+	}{
+		{"8.8.8.8", false},
+		{"140.82.112.3", false},
+		{"2606:4700:4700::1111", false},
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:169.254.169.254", true},
+		{"2002:7f00:1::1", true},
+		{"2002:a9fe:a9fe::1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b:1::a00:1", true},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %s", test.ip)
+		}
+
+		if got := isDisallowedIP(ip); got != test.disallowed {
+			t.Errorf("isDisallowedIP(%s) = %v, want %v", test.ip, got, test.disallowed)
+		}
+	}
+}
+
+func TestValidateMediaURL(t *testing.T) {
+	setTestEnv(t, cFetchAllowedHostsEnv, "")
+	setTestEnv(t, cFetchDeniedHostsEnv, "evil.example")
+
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://user-images.githubusercontent.com/1/a.gif", nil},
+		{"http://example.com/a.png", nil},
+		{"ftp://example.com/a.png", errSchemeNotAllowed},
+		{"file:///etc/passwd", errSchemeNotAllowed},
+		{"http:///a.png", errHostNotAllowed},
+		{"https://evil.example/a.png", errHostNotAllowed},
+		{"https://cdn.evil.example/a.png", errHostNotAllowed},
+		{"http://127.0.0.1/a.png", errAddressNotAllowed},
+		{"http://169.254.169.254/latest/meta-data", errAddressNotAllowed},
+		{"http://[::1]:8080/a.png", errAddressNotAllowed},
+		{"http://[2002:a9fe:a9fe::1]/a.png", errAddressNotAllowed},
+		{"http://[64:ff9b::7f00:1]/a.png", errAddressNotAllowed},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("invalid test URL %s: %v", test.url, err)
+		}
+
+		if err := validateMediaURL(u); !errors.Is(err, test.err) {
+			t.Errorf("validateMediaURL(%s) = %v, want %v", test.url, err, test.err)
+		}
+	}
+}
+
+func TestValidateMediaURLAllowedHosts(t *testing.T) {
+	setTestEnv(t, cFetchAllowedHostsEnv, "githubusercontent.com")
+	setTestEnv(t, cFetchDeniedHostsEnv, "")
+
+	allowed, _ := url.Parse("https://user-images.githubusercontent.com/a.gif")
+	if err := validateMediaURL(allowed); err != nil {
+		t.Errorf("subdomain of an allowed host rejected: %v", err)
+	}
+
+	other, _ := url.Parse("https://example.com/a.gif")
+	if err := validateMediaURL(other); !errors.Is(err, errHostNotAllowed) {
+		t.Errorf("host outside the allow list = %v, want %v", err, errHostNotAllowed)
+	}
+}
+
+func TestValidateFetchURLIgnoresHostLists(t *testing.T) {
+	setTestEnv(t, cFetchAllowedHostsEnv, "githubusercontent.com")
+	setTestEnv(t, cFetchDeniedHostsEnv, "github.com")
+
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://api.github.com/user", nil},
+		{"https://gist.github.com/githubmacros/1", nil},
+		{"http://127.0.0.1/user", errAddressNotAllowed},
+		{"ftp://api.github.com/user", errSchemeNotAllowed},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatalf("invalid test URL %s: %v", test.url, err)
+		}
+
+		if err := validateFetchURL(u); !errors.Is(err, test.err) {
+			t.Errorf("validateFetchURL(%s) = %v, want %v", test.url, err, test.err)
+		}
+
+		if err := validateMediaURL(u); err == nil {
+			t.Errorf("validateMediaURL(%s) accepted a host outside the lists", test.url)
+		}
+	}
+}
+
+func TestCheckRedirect(t *testing.T) {
+	setTestEnv(t, cFetchAllowedHostsEnv, "")
+	setTestEnv(t, cFetchDeniedHostsEnv, "")
+
+	newRequest := func(rawURL string) *http.Request {
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			t.Fatalf("invalid test URL %s: %v", rawURL, err)
+		}
+
+		return req
+	}
+
+	via := []*http.Request{newRequest("https://example.com/a.gif")}
+
+	tests := []struct {
+		url string
+		via []*http.Request
+		err error
+	}{
+		{"https://example.org/b.gif", via, nil},
+		{"http://127.0.0.1/b.gif", via, errAddressNotAllowed},
+		{"http://[::ffff:10.0.0.1]/b.gif", via, errAddressNotAllowed},
+		{"http://[2002:a00:1::]/b.gif", via, errAddressNotAllowed},
+		{"gopher://example.org/b.gif", via, errSchemeNotAllowed},
+		{"https://example.org/b.gif", make([]*http.Request, cFetchMaxRedirects), errTooManyRedirects},
+	}
+
+	for _, test := range tests {
+		if err := checkRedirect(newRequest(test.url), test.via); !errors.Is(err, test.err) {
+			t.Errorf("checkRedirect(%s) = %v, want %v", test.url, err, test.err)
+		}
+	}
+
+	setTestEnv(t, cFetchAllowedHostsEnv, "example.com")
+
+	if err := checkRedirect(newRequest("https://example.org/b.gif"), via); err != nil {
+		t.Errorf("checkRedirect to a host outside the allow list = %v, want nil", err)
+	}
+
+	if err := checkMediaRedirect(newRequest("https://example.org/b.gif"), via); !errors.Is(err, errHostNotAllowed) {
+		t.Errorf("checkMediaRedirect to a host outside the allow list = %v, want %v", err, errHostNotAllowed)
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"140.82.112.3:443", true},
+		{"[2606:4700:4700::1111]:443", true},
+		{"127.0.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"[64:ff9b::a9fe:a9fe]:80", false},
+		{"[2002:a9fe:a9fe::1]:80", false},
+		{"localhost:80", false},
+		{"not an address", false},
+	}
+
+	for _, test := range tests {
+		err := checkDialAddress("tcp", test.address, nil)
+		if (err == nil) != test.allowed {
+			t.Errorf("checkDialAddress(%s) = %v, want allowed %v", test.address, err, test.allowed)
+		}
+
+		if err != nil && !errors.Is(err, errAddressNotAllowed) {
+			t.Errorf("checkDialAddress(%s) = %v, want %v", test.address, err, errAddressNotAllowed)
+		}
+	}
+}
+
+func TestCheckFetchStatus(t *testing.T) {
+	tests := []struct {
+		status  int
+		errCode ErrorCode
+	}{
+		{http.StatusOK, Success},
+		{http.StatusNoContent, Success},
+		{http.StatusNotModified, ImageFetchFailed},
+		{http.StatusForbidden, ImageFetchFailed},
+		{http.StatusNotFound, ImageFetchFailed},
+		{http.StatusTooManyRequests, TransientError},
+		{http.StatusBadGateway, TransientError},
+	}
+
+	for _, test := range tests {
+		err := checkFetchStatus(&http.Response{StatusCode: test.status})
+
+		errCode := Success
+		if err != nil {
+			errCode = getMediaErrorCode(fmt.Errorf("failed to fetch image: %w", err))
+		}
+
+		if errCode != test.errCode {
+			t.Errorf("checkFetchStatus(%d) = %v, want code %d", test.status, err, test.errCode)
+		}
+	}
+}
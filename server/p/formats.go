@@ -0,0 +1,473 @@
+package p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	cMediaTypeGIF  = "image/gif"
+	cMediaTypePNG  = "image/png"
+	cMediaTypeAPNG = "image/apng"
+	cMediaTypeBMP  = "image/bmp"
+	cMediaTypeJPEG = "image/jpeg"
+	cMediaTypeWebP = "image/webp"
+	cMediaTypeSVG  = "image/svg+xml"
+	cMediaTypeAVIF = "image/avif"
+
+	// GIF frame delays are stored in hundredths of a second
+	cGIFDelayUnitMs = 10
+	// APNG frames with a zero delay denominator are timed in hundredths of a second
+	cAPNGDefaultDelayDen = 100
+	cMsPerSecond         = 1000
+
+	cSniffLen = 512
+
+	// cMaxImagePixels bounds the size of a single decoded frame and
+	// cMaxAnimationPixels the total size of all the frames of an animation,
+	// they are checked from the headers before anything is decoded
+	cMaxImagePixels     = 40 * 1000 * 1000
+	cMaxAnimationPixels = 100 * 1000 * 1000
+
+	// cLoopCountNotAnimated is the loop count of static images and of macros
+	// stored before loop counts were, 0 is reserved for animations looping
+	// forever
+	cLoopCountNotAnimated = -2
+)
+
+var supportedTypes = map[string]bool{
+	cMediaTypeGIF:  true,
+	cMediaTypePNG:  true,
+	cMediaTypeAPNG: true,
+	cMediaTypeBMP:  true,
+	cMediaTypeJPEG: true,
+	cMediaTypeWebP: true,
+	cMediaTypeSVG:  true,
+	cMediaTypeAVIF: true,
+}
+
+// supportedFormats is returned to the client along with FileFormatNotSupported.
+var supportedFormats = []string{"gif", "png", "apng", "bmp", "jpeg", "webp", "svg", "avif"}
+
+// decodedImage holds the image metadata along with its decoded pixels when the
+// format can be decoded. Still is the first (or default) frame and Animation
+// is set for GIFs, the only animated format we can decode frame by frame.
+// Sanitized is set for SVGs that had unsafe content removed, it's what gets
+// stored instead of the original file.
+type decodedImage struct {
+	imageMetadata
+	Still     image.Image
+	Animation *gif.GIF
+	Sanitized []byte
+	SHA256    string
+	PHash     int64
+	HasPHash  bool
+}
+
+var (
+	errImageIsCorrupted = errors.New("image is corrupted or truncated")
+	errUnsupportedImage = errors.New("image format is not supported")
+	errImageTooLarge    = errors.New("image dimensions exceed the pixel budget")
+
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// detectMediaType sniffs the media type from the first bytes of the file.
+// It extends http.DetectContentType with formats it doesn't know about or
+// doesn't tell apart (APNG, SVG and AVIF).
+func detectMediaType(head []byte) string {
+	if len(head) > cSniffLen {
+		head = head[:cSniffLen]
+	}
+
+	switch {
+	case isAVIF(head):
+		return cMediaTypeAVIF
+	case isSVG(head):
+		return cMediaTypeSVG
+	case bytes.HasPrefix(head, pngSignature):
+		if isAPNG(head) {
+			return cMediaTypeAPNG
+		}
+
+		return cMediaTypePNG
+	}
+
+	return http.DetectContentType(head)
+}
+
+// isAPNG looks for an acTL chunk, which must appear before the first IDAT.
+func isAPNG(buf []byte) bool {
+	found := false
+
+	_ = walkPNGChunks(buf, func(chunkType string, _ []byte) bool {
+		if chunkType == "acTL" {
+			found = true
+		}
+
+		return !found && chunkType != "IDAT"
+	})
+
+	return found
+}
+
+// walkPNGChunks calls fn for every chunk until fn returns false or IEND is
+// reached. It returns errImageIsCorrupted if the chunk list is truncated.
+func walkPNGChunks(buf []byte, fn func(chunkType string, data []byte) bool) error {
+	if !bytes.HasPrefix(buf, pngSignature) {
+		return errImageIsCorrupted
+	}
+
+	const chunkOverhead = 12
+
+	for offset := len(pngSignature); ; {
+		if offset+chunkOverhead > len(buf) {
+			return errImageIsCorrupted
+		}
+
+		length := int(binary.BigEndian.Uint32(buf[offset:]))
+		chunkType := string(buf[offset+4 : offset+8])
+
+		if length < 0 || offset+chunkOverhead+length > len(buf) {
+			return errImageIsCorrupted
+		}
+
+		if !fn(chunkType, buf[offset+8:offset+8+length]) || chunkType == "IEND" {
+			return nil
+		}
+
+		offset += chunkOverhead + length
+	}
+}
+
+// decodeImage fully decodes the image, so truncated or corrupted files are
+// rejected rather than only checking their header.
+func decodeImage(buf []byte) (*decodedImage, error) {
+	var (
+		decoded *decodedImage
+		err     error
+	)
+
+	switch detectMediaType(buf) {
+	case cMediaTypeGIF:
+		decoded, err = decodeGIFMetadata(buf)
+	case cMediaTypePNG, cMediaTypeAPNG:
+		decoded, err = decodePNGMetadata(buf)
+	case cMediaTypeWebP:
+		decoded, err = decodeWebPMetadata(buf)
+	case cMediaTypeSVG:
+		decoded, err = decodeSVGMetadata(buf)
+	case cMediaTypeAVIF:
+		decoded, err = decodeAVIFMetadata(buf)
+	case cMediaTypeJPEG, cMediaTypeBMP:
+		decoded, err = decodeStaticImageMetadata(buf)
+	default:
+		return nil, errUnsupportedImage
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if decoded.Frames <= 1 {
+		decoded.LoopCount = cLoopCountNotAnimated
+	}
+
+	return decoded, nil
+}
+
+// checkPixelBudget rejects images whose decoded frames would take more memory
+// than the budget allows.
+func checkPixelBudget(width, height, frames int64) error {
+	return checkPixelBudgetWithin(width, height, frames, cMaxImagePixels, cMaxAnimationPixels)
+}
+
+// checkPixelBudgetWithin rejects images with frames larger than maxImagePixels
+// or animations larger than maxAnimationPixels in total.
+func checkPixelBudgetWithin(width, height, frames, maxImagePixels, maxAnimationPixels int64) error {
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+
+	if width > maxImagePixels/height {
+		return errImageTooLarge
+	}
+
+	if frames > 1 && width*height > maxAnimationPixels/frames {
+		return errImageTooLarge
+	}
+
+	return nil
+}
+
+// checkImageConfig reads the dimensions of a raster image from its header
+// and checks them against the pixel budget.
+func checkImageConfig(buf []byte, frames int64) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+
+	return checkPixelBudget(int64(config.Width), int64(config.Height), frames)
+}
+
+func decodeStaticImageMetadata(buf []byte) (*decodedImage, error) {
+	if err := checkImageConfig(buf, 1); err != nil {
+		return nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+
+	return &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:  int64(bounds.Dx()),
+			Height: int64(bounds.Dy()),
+			Frames: 1,
+		},
+		Still: img,
+	}, nil
+}
+
+// countGIFFrames walks the blocks of a GIF without decompressing them and
+// returns the number of frames and the largest frame size, frames may be
+// larger than the logical screen. It returns errImageIsCorrupted if a block
+// runs past the end of the file.
+func countGIFFrames(buf []byte) (frames, maxFramePixels int64, err error) {
+	const (
+		headerSize          = 13
+		imageDescriptorSize = 10
+		colorTableFlag      = 0x80
+		colorTableSizeMask  = 0x07
+		extensionIntroducer = 0x21
+		imageSeparator      = 0x2c
+		trailer             = 0x3b
+	)
+
+	colorTableSize := func(flags byte) int {
+		if flags&colorTableFlag == 0 {
+			return 0
+		}
+
+		return 3 << (uint(flags&colorTableSizeMask) + 1)
+	}
+
+	// skipSubBlocks returns the offset following the data sub-blocks starting
+	// at offset
+	skipSubBlocks := func(offset int) (int, error) {
+		for {
+			if offset >= len(buf) {
+				return 0, errImageIsCorrupted
+			}
+
+			size := int(buf[offset])
+			offset++
+
+			if size == 0 {
+				return offset, nil
+			}
+
+			offset += size
+		}
+	}
+
+	if len(buf) < headerSize {
+		return 0, 0, errImageIsCorrupted
+	}
+
+	offset := headerSize + colorTableSize(buf[10])
+
+	for offset < len(buf) {
+		switch buf[offset] {
+		case trailer:
+			return frames, maxFramePixels, nil
+		case extensionIntroducer:
+			if offset+2 > len(buf) {
+				return 0, 0, errImageIsCorrupted
+			}
+
+			if offset, err = skipSubBlocks(offset + 2); err != nil {
+				return 0, 0, err
+			}
+		case imageSeparator:
+			// the descriptor is followed by the LZW minimum code size
+			if offset+imageDescriptorSize+1 > len(buf) {
+				return 0, 0, errImageIsCorrupted
+			}
+
+			width := int64(binary.LittleEndian.Uint16(buf[offset+5:]))
+			height := int64(binary.LittleEndian.Uint16(buf[offset+7:]))
+
+			if width*height > maxFramePixels {
+				maxFramePixels = width * height
+			}
+
+			frames++
+			offset += imageDescriptorSize + colorTableSize(buf[offset+9])
+
+			if offset, err = skipSubBlocks(offset + 1); err != nil {
+				return 0, 0, err
+			}
+		default:
+			return 0, 0, errImageIsCorrupted
+		}
+	}
+
+	// files without a trailer are accepted by the decoder
+	return frames, maxFramePixels, nil
+}
+
+// checkGIFPixelBudget checks the frames of a GIF against the budget from its
+// headers, before any of them is decoded.
+func checkGIFPixelBudget(buf []byte, maxImagePixels, maxAnimationPixels int64) error {
+	config, err := gif.DecodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+
+	frames, maxFramePixels, err := countGIFFrames(buf)
+	if err != nil {
+		return err
+	}
+
+	// frames are composited on the logical screen, so an animation takes at
+	// least that much memory per frame
+	screenPixels := int64(config.Width) * int64(config.Height)
+	if maxFramePixels > screenPixels {
+		screenPixels = maxFramePixels
+	}
+
+	return checkPixelBudgetWithin(screenPixels, 1, frames, maxImagePixels, maxAnimationPixels)
+}
+
+func decodeGIFMetadata(buf []byte) (*decodedImage, error) {
+	if err := checkGIFPixelBudget(buf, cMaxImagePixels, cMaxAnimationPixels); err != nil {
+		return nil, err
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.Image) == 0 {
+		return nil, errors.New("gif has no frames")
+	}
+
+	var durationMs int64
+
+	for _, delay := range g.Delay {
+		durationMs += int64(delay) * cGIFDelayUnitMs
+	}
+
+	return &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:      int64(g.Config.Width),
+			Height:     int64(g.Config.Height),
+			Frames:     int64(len(g.Image)),
+			DurationMs: durationMs,
+			LoopCount:  int64(g.LoopCount),
+		},
+		Still:     g.Image[0],
+		Animation: g,
+	}, nil
+}
+
+// loopCountFromPlays converts a "number of plays" value (0 is infinite) as
+// used by APNG and WebP into GIF loop count semantics.
+func loopCountFromPlays(plays int64) int64 {
+	switch plays {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	}
+
+	return plays - 1
+}
+
+// decodePNGMetadata decodes the default image and, for APNG files, collects
+// the animation control chunks.
+func decodePNGMetadata(buf []byte) (*decodedImage, error) {
+	var (
+		isAnimated bool
+		frames     int64
+		durationMs int64
+		plays      int64
+	)
+
+	const (
+		acTLSize = 8
+		fcTLSize = 26
+	)
+
+	err := walkPNGChunks(buf, func(chunkType string, data []byte) bool {
+		switch {
+		case chunkType == "acTL" && len(data) >= acTLSize:
+			isAnimated = true
+			plays = int64(binary.BigEndian.Uint32(data[4:]))
+		case chunkType == "fcTL" && len(data) >= fcTLSize:
+			frames++
+			delayNum := int64(binary.BigEndian.Uint16(data[20:]))
+			delayDen := int64(binary.BigEndian.Uint16(data[22:]))
+
+			if delayDen == 0 {
+				delayDen = cAPNGDefaultDelayDen
+			}
+
+			durationMs += delayNum * cMsPerSecond / delayDen
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if isAnimated && frames == 0 {
+		return nil, errImageIsCorrupted
+	}
+
+	// only the default image is decoded, but the frames are composited on
+	// the canvas when the animation is played
+	if err := checkImageConfig(buf, frames); err != nil {
+		return nil, err
+	}
+
+	img, err := png.Decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	decoded := &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:  int64(bounds.Dx()),
+			Height: int64(bounds.Dy()),
+			Frames: 1,
+		},
+		Still: img,
+	}
+
+	if isAnimated {
+		decoded.Frames = frames
+		decoded.DurationMs = durationMs
+		decoded.LoopCount = loopCountFromPlays(plays)
+	}
+
+	return decoded, nil
+}
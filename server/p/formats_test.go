@@ -0,0 +1,164 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"testing"
+)
+
+// newTestGIF encodes an animation of width x height frames, one per delay,
+// on a logical screen of the given size.
+func newTestGIF(t *testing.T, width, height int, screen image.Point, delays []int, loopCount int) []byte {
+	t.Helper()
+
+	g := &gif.GIF{LoopCount: loopCount, Config: image.Config{Width: screen.X, Height: screen.Y}}
+
+	for i, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		frame.Set(i%width, 0, color.White)
+
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, delay)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("failed to encode test GIF: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func newTestPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	img.Set(0, 0, color.NRGBA{R: 255, A: 255})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test PNG: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestDecodeImage(t *testing.T) {
+	animation := newTestGIF(t, 8, 6, image.Point{X: 8, Y: 6}, []int{10, 20, 30}, 0)
+
+	tests := []struct {
+		name string
+		buf  []byte
+		want imageMetadata
+	}{
+		{
+			name: "animated gif",
+			buf:  animation,
+			want: imageMetadata{Width: 8, Height: 6, Frames: 3, DurationMs: 600, LoopCount: 0},
+		},
+		{
+			name: "gif played once",
+			buf:  newTestGIF(t, 4, 4, image.Point{X: 4, Y: 4}, []int{5, 5}, -1),
+			want: imageMetadata{Width: 4, Height: 4, Frames: 2, DurationMs: 100, LoopCount: -1},
+		},
+		{
+			name: "static gif",
+			buf:  newTestGIF(t, 4, 4, image.Point{X: 4, Y: 4}, []int{0}, 0),
+			want: imageMetadata{Width: 4, Height: 4, Frames: 1, LoopCount: cLoopCountNotAnimated},
+		},
+		{
+			name: "png",
+			buf:  newTestPNG(t, 5, 3),
+			want: imageMetadata{Width: 5, Height: 3, Frames: 1, LoopCount: cLoopCountNotAnimated},
+		},
+	}
+
+	for _, test := range tests {
+		decoded, err := decodeImage(test.buf)
+		if err != nil {
+			t.Errorf("%s: decodeImage() = %v", test.name, err)
+			continue
+		}
+
+		if decoded.imageMetadata != test.want {
+			t.Errorf("%s: decodeImage() = %+v, want %+v", test.name, decoded.imageMetadata, test.want)
+		}
+
+		if decoded.Still == nil {
+			t.Errorf("%s: decodeImage() has no still image", test.name)
+		}
+	}
+}
+
+func TestDecodeImageRejectsBrokenFiles(t *testing.T) {
+	animation := newTestGIF(t, 8, 6, image.Point{X: 8, Y: 6}, []int{10, 20, 30}, 0)
+	still := newTestPNG(t, 5, 3)
+
+	tests := []struct {
+		name string
+		buf  []byte
+		err  error
+	}{
+		{"truncated gif", animation[:len(animation)/2], nil},
+		{"truncated png", still[:len(still)-20], nil},
+		{"unknown format", []byte("not an image at all"), errUnsupportedImage},
+		{"frame over the pixel budget", newTestGIF(t, 1, 1, image.Point{X: 10000, Y: 10000}, []int{0}, 0), errImageTooLarge},
+		{"animation over the pixel budget", newTestGIF(t, 1, 1, image.Point{X: 6000, Y: 6000}, []int{1, 1, 1}, 0), errImageTooLarge},
+	}
+
+	for _, test := range tests {
+		_, err := decodeImage(test.buf)
+		if err == nil {
+			t.Errorf("%s: decodeImage() succeeded", test.name)
+			continue
+		}
+
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%s: decodeImage() = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDecodeFetchedMediaReportsCorruption(t *testing.T) {
+	animation := newTestGIF(t, 8, 6, image.Point{X: 8, Y: 6}, []int{10, 20}, 0)
+
+	decoded, err := decodeFetchedMedia(&fetchedMedia{Data: animation, MediaType: cMediaTypeGIF, SHA256: "digest"})
+	if err != nil {
+		t.Fatalf("decodeFetchedMedia() = %v", err)
+	}
+
+	if decoded.SHA256 != "digest" || !decoded.HasPHash {
+		t.Errorf("decodeFetchedMedia() = sha256 %q, has phash %v, want the digest and a phash", decoded.SHA256, decoded.HasPHash)
+	}
+
+	_, err = decodeFetchedMedia(&fetchedMedia{Data: animation[:len(animation)-30], MediaType: cMediaTypeGIF})
+	if !errors.Is(err, errImageIsCorrupted) {
+		t.Errorf("decodeFetchedMedia(truncated) = %v, want %v", err, errImageIsCorrupted)
+	}
+}
+
+func TestCountGIFFrames(t *testing.T) {
+	animation := newTestGIF(t, 8, 6, image.Point{X: 10, Y: 10}, []int{1, 1, 1, 1}, 0)
+
+	frames, maxFramePixels, err := countGIFFrames(animation)
+	if err != nil || frames != 4 || maxFramePixels != 48 {
+		t.Errorf("countGIFFrames() = %d, %d, %v, want 4, 48, nil", frames, maxFramePixels, err)
+	}
+
+	if _, _, err := countGIFFrames(animation[:45]); !errors.Is(err, errImageIsCorrupted) {
+		t.Errorf("countGIFFrames(truncated) = %v, want %v", err, errImageIsCorrupted)
+	}
+}
+
+func TestLoopCountFromPlays(t *testing.T) {
+	for plays, want := range map[int64]int64{0: 0, 1: -1, 2: 1, 5: 4} {
+		if got := loopCountFromPlays(plays); got != want {
+			t.Errorf("loopCountFromPlays(%d) = %d, want %d", plays, got, want)
+		}
+	}
+}
@@ -28,10 +28,13 @@ type GistRow struct {
 	Comments int    `bigquery:"comments"`
 }
 
-func sendAPIRequest(apiURL, payload string) (map[string]interface{}, error) {
+func sendAPIRequest(ctx context.Context, apiURL, payload string) (map[string]interface{}, error) {
+	ctx, cancel := withStageTimeout(ctx, stageGithub)
+	defer cancel()
+
 	body := strings.NewReader(payload)
 
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, apiURL, body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, body)
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +43,7 @@ func sendAPIRequest(apiURL, payload string) (map[string]interface{}, error) {
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := doSafeRequest(req)
 	if err != nil {
 		return nil, err
 	}
@@ -60,9 +63,9 @@ func sendAPIRequest(apiURL, payload string) (map[string]interface{}, error) {
 	return response, nil
 }
 
-func queryAvailableGistID(client *bigquery.Client) (string, error) {
+func queryAvailableGistID(ctx context.Context, client *bigquery.Client) (string, error) {
 	query := client.Query("SELECT * FROM `github-macros.macros.gists` ORDER BY creation_time DESC LIMIT 1")
-	it, err := runQuery(context.Background(), query)
+	it, err := runQuery(ctx, query)
 
 	if err != nil {
 		return "", err
@@ -85,7 +88,7 @@ func queryAvailableGistID(client *bigquery.Client) (string, error) {
 	return "", nil
 }
 
-func addNewGist(client *bigquery.Client, gistID string) {
+func addNewGist(ctx context.Context, client *bigquery.Client, gistID string) {
 	query := client.Query(
 		"INSERT INTO `github-macros.macros.gists` (id, comments, creation_time) VALUES (@id, 0, CURRENT_TIMESTAMP())",
 	)
@@ -96,12 +99,12 @@ func addNewGist(client *bigquery.Client, gistID string) {
 		},
 	}
 
-	if _, err := runQuery(context.Background(), query); err != nil {
+	if _, err := runQuery(ctx, query); err != nil {
 		log.Printf("failed to add new gist: %v", err)
 	}
 }
 
-func updateComments(client *bigquery.Client, gistID string) {
+func updateComments(ctx context.Context, client *bigquery.Client, gistID string) {
 	query := client.Query(
 		"UPDATE `github-macros.macros.gists` SET comments = comments + 1 WHERE id=@id",
 	)
@@ -112,13 +115,13 @@ func updateComments(client *bigquery.Client, gistID string) {
 		},
 	}
 
-	if _, err := runQuery(context.Background(), query); err != nil {
+	if _, err := runQuery(ctx, query); err != nil {
 		log.Printf("failed to update comments: %v", err)
 	}
 }
 
-func getGistID(client *bigquery.Client) (string, error) {
-	gistID, err := queryAvailableGistID(client)
+func getGistID(ctx context.Context, client *bigquery.Client) (string, error) {
+	gistID, err := queryAvailableGistID(ctx, client)
 	if err != nil {
 		return "", err
 	}
@@ -128,6 +131,7 @@ func getGistID(client *bigquery.Client) (string, error) {
 	}
 
 	res, err := sendAPIRequest(
+		ctx,
 		"https://api.github.com/gists",
 		fmt.Sprintf(
 			`{"public": true, "files":{%q: {"content": "created on %s"}}}`,
@@ -146,13 +150,14 @@ func getGistID(client *bigquery.Client) (string, error) {
 		return "", errors.New("missing gist ID")
 	}
 
-	addNewGist(client, gistID)
+	addNewGist(ctx, client, gistID)
 
 	return gistID, nil
 }
 
-func commentOnGist(client *bigquery.Client, gistID, comment string) (int64, error) {
+func commentOnGist(ctx context.Context, client *bigquery.Client, gistID, comment string) (int64, error) {
 	res, err := sendAPIRequest(
+		ctx,
 		fmt.Sprintf("https://api.github.com/gists/%s/comments", gistID),
 		fmt.Sprintf(`{"body":%q}`, comment),
 	)
@@ -165,13 +170,14 @@ func commentOnGist(client *bigquery.Client, gistID, comment string) (int64, erro
 		return 0, fmt.Errorf("unable to find the id of the new comment")
 	}
 
-	updateComments(client, gistID)
+	updateComments(ctx, client, gistID)
 
 	return int64(res["id"].(float64)), nil
 }
 
-func getGist(gistID string) ([]byte, error) {
+func getGist(ctx context.Context, gistID string) ([]byte, error) {
 	resp, err := sendHTTPGetRequest(
+		ctx,
 		fmt.Sprintf("https://gist.github.com/githubmacros/%s", gistID),
 	)
 
@@ -182,20 +188,20 @@ func getGist(gistID string) ([]byte, error) {
 	return resp, nil
 }
 
-func GetGithubImage(client *bigquery.Client, imageURL string) (string, error) {
-	gistID, err := getGistID(client)
+func GetGithubImage(ctx context.Context, client *bigquery.Client, imageURL string) (string, error) {
+	gistID, err := getGistID(ctx, client)
 	if err != nil {
 		return "", err
 	}
 
 	payload := fmt.Sprintf("![ghm](%s)", imageURL)
 
-	commentID, err := commentOnGist(client, gistID, payload)
+	commentID, err := commentOnGist(ctx, client, gistID, payload)
 	if err != nil {
 		return "", err
 	}
 
-	gist, err := getGist(gistID)
+	gist, err := getGist(ctx, gistID)
 	if err != nil {
 		return "", err
 	}
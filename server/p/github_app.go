@@ -0,0 +1,303 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	cGithubAppIDEnv           = "GITHUB_APP_ID"
+	cGithubAppPrivateKeyEnv   = "GITHUB_APP_PRIVATE_KEY"
+	cGithubInstallationURL    = "https://api.github.com/app/installations/%d/access_tokens"
+	cGithubGraphQLURL         = "https://api.github.com/graphql"
+	cGithubAppJWTLifetime     = 9 * time.Minute
+	cGithubAppJWTClockSkew    = time.Minute
+	cInstallationTokenLeeway  = 5 * time.Minute
+	cUpdateDiscussionMutation = `mutation($id: ID!, $body: String!) {
+		updateDiscussionComment(input: {commentId: $id, body: $body}) { comment { id } }
+	}`
+	cDiscussionCommentQuery = `query($id: ID!) {
+		node(id: $id) { ... on DiscussionComment { updatedAt } }
+	}`
+)
+
+var (
+	errGithubAppNotConfigured = errors.New("github app is not configured")
+	errInvalidAppPrivateKey   = errors.New("github app private key is invalid")
+	errCommentChanged         = errors.New("comment was edited since the event")
+)
+
+type installationToken struct {
+	token   string
+	expires time.Time
+}
+
+// installationTokens caches the access token of every installation until
+// shortly before it expires, GitHub grants them for an hour.
+var installationTokens = struct {
+	sync.Mutex
+	entries map[int64]*installationToken
+}{entries: map[int64]*installationToken{}}
+
+// parseAppPrivateKey parses the PEM private key GitHub generates for the app,
+// which is PKCS#1, falling back to PKCS#8 for converted keys.
+func parseAppPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errInvalidAppPrivateKey
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errInvalidAppPrivateKey
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errInvalidAppPrivateKey
+	}
+
+	return key, nil
+}
+
+// createAppJWT creates the RS256 JWT the app authenticates with when it asks
+// for installation tokens. It's backdated to allow for clock drift.
+func createAppJWT(now time.Time) (string, error) {
+	appID := os.Getenv(cGithubAppIDEnv)
+	pemKey := os.Getenv(cGithubAppPrivateKeyEnv)
+
+	if appID == "" || pemKey == "" {
+		return "", errGithubAppNotConfigured
+	}
+
+	key, err := parseAppPrivateKey(pemKey)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-cGithubAppJWTClockSkew).Unix(),
+		"exp": now.Add(cGithubAppJWTLifetime).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// sendAppAPIRequest sends a request with a JSON payload, when it isn't nil,
+// to the GitHub API authenticated by authorization and decodes the response
+// into result when it isn't nil.
+func sendAppAPIRequest(
+	ctx context.Context,
+	method, apiURL, authorization string,
+	payload, result interface{},
+) error {
+	ctx, cancel := withStageTimeout(ctx, stageGithub)
+	defer cancel()
+
+	var body io.Reader = http.NoBody
+
+	if payload != nil {
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := doSafeRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("github API request to %s failed: status %d", apiURL, resp.StatusCode)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// getInstallationToken returns an access token of the app installation, which
+// is what the app acts with on the repositories it's installed on.
+func getInstallationToken(ctx context.Context, installationID int64) (string, error) {
+	installationTokens.Lock()
+	cached, ok := installationTokens.entries[installationID]
+	installationTokens.Unlock()
+
+	now := time.Now()
+
+	if ok && now.Add(cInstallationTokenLeeway).Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	jwt, err := createAppJWT(now)
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	apiURL := fmt.Sprintf(cGithubInstallationURL, installationID)
+	if err := sendAppAPIRequest(ctx, http.MethodPost, apiURL, "Bearer "+jwt, struct{}{}, &response); err != nil {
+		return "", err
+	}
+
+	installationTokens.Lock()
+	defer installationTokens.Unlock()
+
+	for id, entry := range installationTokens.entries {
+		if now.After(entry.expires) {
+			delete(installationTokens.entries, id)
+		}
+	}
+
+	installationTokens.entries[installationID] = &installationToken{token: response.Token, expires: response.ExpiresAt}
+
+	return response.Token, nil
+}
+
+// sendGraphQLRequest runs a GraphQL query or mutation with the installation
+// token and decodes the response into result.
+func sendGraphQLRequest(ctx context.Context, token, query string, variables map[string]string, result interface{}) error {
+	payload := map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}
+
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+
+	if err := sendAppAPIRequest(ctx, http.MethodPost, cGithubGraphQLURL, "token "+token, payload, &response); err != nil {
+		return err
+	}
+
+	if len(response.Errors) > 0 {
+		return fmt.Errorf("github GraphQL request failed: %s", response.Errors[0].Message)
+	}
+
+	if result == nil || len(response.Data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(response.Data, result)
+}
+
+// updateCommentBody replaces the body of an issue or pull request review
+// comment through its REST API URL. The comment is fetched first and left
+// alone with errCommentChanged when it was edited after updatedAt, so a late
+// delivery doesn't overwrite a newer edit.
+func updateCommentBody(ctx context.Context, installationID int64, commentURL string, updatedAt time.Time, body string) error {
+	token, err := getInstallationToken(ctx, installationID)
+	if err != nil {
+		return err
+	}
+
+	var comment struct {
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+
+	if err := sendAppAPIRequest(ctx, http.MethodGet, commentURL, "token "+token, nil, &comment); err != nil {
+		return err
+	}
+
+	if !comment.UpdatedAt.Equal(updatedAt) {
+		return errCommentChanged
+	}
+
+	return sendAppAPIRequest(ctx, http.MethodPatch, commentURL, "token "+token, map[string]string{"body": body}, nil)
+}
+
+// updateDiscussionCommentBody replaces the body of a discussion comment, which
+// can only be edited through the GraphQL API. Like updateCommentBody, it
+// returns errCommentChanged when the comment was edited after updatedAt.
+func updateDiscussionCommentBody(ctx context.Context, installationID int64, nodeID string, updatedAt time.Time, body string) error {
+	token, err := getInstallationToken(ctx, installationID)
+	if err != nil {
+		return err
+	}
+
+	var comment struct {
+		Node struct {
+			UpdatedAt time.Time `json:"updatedAt"`
+		} `json:"node"`
+	}
+
+	if err := sendGraphQLRequest(ctx, token, cDiscussionCommentQuery, map[string]string{"id": nodeID}, &comment); err != nil {
+		return err
+	}
+
+	if !comment.Node.UpdatedAt.Equal(updatedAt) {
+		return errCommentChanged
+	}
+
+	variables := map[string]string{
+		"id":   nodeID,
+		"body": body,
+	}
+
+	if err := sendGraphQLRequest(ctx, token, cUpdateDiscussionMutation, variables, nil); err != nil {
+		return fmt.Errorf("failed to update discussion comment %s: %w", nodeID, err)
+	}
+
+	return nil
+}
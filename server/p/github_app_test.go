@@ -0,0 +1,125 @@
+package p
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAppKey(t *testing.T) (*rsa.PrivateKey, string, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	pkcs1PEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	pkcs8PEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+
+	return key, string(pkcs1PEM), string(pkcs8PEM)
+}
+
+func decodeJWTPart(t *testing.T, part string, value interface{}) {
+	t.Helper()
+
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		t.Fatalf("invalid JWT part %q: %v", part, err)
+	}
+
+	if err := json.Unmarshal(decoded, value); err != nil {
+		t.Fatalf("invalid JWT part %s: %v", decoded, err)
+	}
+}
+
+func TestCreateAppJWT(t *testing.T) {
+	key, pkcs1PEM, pkcs8PEM := newTestAppKey(t)
+	now := time.Unix(1700000000, 0)
+
+	setTestEnv(t, cGithubAppIDEnv, "12345")
+
+	for _, pemKey := range []string{pkcs1PEM, pkcs8PEM} {
+		setTestEnv(t, cGithubAppPrivateKeyEnv, pemKey)
+
+		jwt, err := createAppJWT(now)
+		if err != nil {
+			t.Fatalf("createAppJWT() = %v", err)
+		}
+
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			t.Fatalf("createAppJWT() = %q, want 3 parts", jwt)
+		}
+
+		var header map[string]string
+
+		decodeJWTPart(t, parts[0], &header)
+
+		if header["alg"] != "RS256" || header["typ"] != "JWT" {
+			t.Errorf("JWT header = %v, want RS256 JWT", header)
+		}
+
+		var claims struct {
+			IssuedAt  int64  `json:"iat"`
+			ExpiresAt int64  `json:"exp"`
+			Issuer    string `json:"iss"`
+		}
+
+		decodeJWTPart(t, parts[1], &claims)
+
+		if claims.Issuer != "12345" ||
+			claims.IssuedAt != now.Add(-cGithubAppJWTClockSkew).Unix() ||
+			claims.ExpiresAt != now.Add(cGithubAppJWTLifetime).Unix() {
+			t.Errorf("JWT claims = %+v, want the app ID, backdated iat and exp in %v", claims, cGithubAppJWTLifetime)
+		}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatalf("invalid JWT signature: %v", err)
+		}
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+			t.Errorf("JWT signature doesn't verify: %v", err)
+		}
+	}
+}
+
+func TestCreateAppJWTErrors(t *testing.T) {
+	_, pkcs1PEM, _ := newTestAppKey(t)
+
+	tests := []struct {
+		appID  string
+		pemKey string
+		err    error
+	}{
+		{"", pkcs1PEM, errGithubAppNotConfigured},
+		{"12345", "", errGithubAppNotConfigured},
+		{"12345", "not a key", errInvalidAppPrivateKey},
+		{"12345", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")})), errInvalidAppPrivateKey},
+	}
+
+	for _, test := range tests {
+		setTestEnv(t, cGithubAppIDEnv, test.appID)
+		setTestEnv(t, cGithubAppPrivateKeyEnv, test.pemKey)
+
+		if _, err := createAppJWT(time.Now()); !errors.Is(err, test.err) {
+			t.Errorf("createAppJWT() with app ID %q = %v, want %v", test.appID, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/avishail/github-macros/server/rpc/macrospb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	cAuthorizationMetadata = "authorization"
+	cAddJobPollInterval    = 500 * time.Millisecond
+)
+
+// macroServiceServer implements the gRPC MacroService on top of the logic of
+// the HTTP handlers.
+type macroServiceServer struct {
+	macrospb.UnimplementedMacroServiceServer
+}
+
+// NewGRPCServer returns a gRPC server serving the MacroService, with server
+// reflection enabled.
+func NewGRPCServer(options ...grpc.ServerOption) *grpc.Server {
+	options = append(options, grpc.ChainUnaryInterceptor(recoverUnaryInterceptor, timeoutUnaryInterceptor))
+
+	server := grpc.NewServer(options...)
+	macrospb.RegisterMacroServiceServer(server, &macroServiceServer{})
+	reflection.Register(server)
+
+	return server
+}
+
+// recoverUnaryInterceptor turns a panic of a handler into an Internal error,
+// like net/http does for the HTTP handlers, rather than crashing the server.
+func recoverUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (response interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s failed: %v", info.FullMethod, r)
+
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
+
+// timeoutUnaryInterceptor runs every call under the request stage deadline.
+func timeoutUnaryInterceptor(
+	ctx context.Context,
+	req interface{},
+	_ *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	ctx, cancel := withStageTimeout(ctx, stageRequest)
+	defer cancel()
+
+	return handler(ctx, req)
+}
+
+// getRPCCallerToken returns the GitHub token sent as the authorization
+// metadata, in the same formats as the Authorization header.
+func getRPCCallerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(cAuthorizationMetadata)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return parseAuthorization(values[0])
+}
+
+func newRPCBigQueryClient(ctx context.Context) *bigquery.Client {
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		log.Panicf("failed to create bigquery client: %v", err)
+	}
+
+	return client
+}
+
+func toProtoMacro(row *MacroRow) *macrospb.Macro {
+	if row == nil {
+		return nil
+	}
+
+	return &macrospb.Macro{
+		Name:            row.Name,
+		Url:             row.URL,
+		UrlSize:         row.URLSize,
+		Width:           row.Width,
+		Height:          row.Height,
+		GithubUrl:       row.GithubURL,
+		Frames:          row.Frames,
+		DurationMs:      row.DurationMs,
+		LoopCount:       row.LoopCount,
+		ThumbnailUrl:    resolveMediaURL(row.ThumbnailURL),
+		ThumbnailWidth:  row.ThumbnailWidth,
+		ThumbnailHeight: row.ThumbnailHeight,
+		PreviewUrl:      resolveMediaURL(row.PreviewURL),
+		PreviewWidth:    row.PreviewWidth,
+		PreviewHeight:   row.PreviewHeight,
+	}
+}
+
+func toProtoMacros(rows []*MacroRow) []*macrospb.Macro {
+	macros := make([]*macrospb.Macro, 0, len(rows))
+	for _, row := range rows {
+		macros = append(macros, toProtoMacro(row))
+	}
+
+	return macros
+}
+
+// listMacros runs a search or suggestion query for a page of macros.
+func (s *macroServiceServer) listMacros(ctx context.Context, query *macroQuery, collection string) *macrospb.MacroList {
+	if query.Page < 0 || query.Limit < 0 {
+		return &macrospb.MacroList{Code: InvalidRequest}
+	}
+
+	client := newRPCBigQueryClient(ctx)
+	defer client.Close()
+
+	result, err := queryMacros(ctx, client, query, getQueryScope(ctx, collection, getRPCCallerToken(ctx)))
+	if errors.Is(err, errInvalidCursor) {
+		return &macrospb.MacroList{Code: InvalidRequest}
+	}
+
+	if err != nil {
+		log.Panicf("failed to query macros: %v", err)
+	}
+
+	response := getMacroListResponse(query, result)
+
+	return &macrospb.MacroList{
+		Code:       Success,
+		Macros:     toProtoMacros(response.Data),
+		HasMore:    response.HasMore,
+		NextPage:   int32(response.NextPage),
+		NextCursor: response.NextCursor,
+	}
+}
+
+func (s *macroServiceServer) Search(ctx context.Context, req *macrospb.SearchRequest) (*macrospb.MacroList, error) {
+	query := &macroQuery{
+		Type:   queryTypeSearch,
+		Text:   req.Text,
+		Page:   int(req.Page),
+		Limit:  int(req.Limit),
+		Cursor: req.Cursor,
+	}
+
+	return s.listMacros(ctx, query, req.Collection), nil
+}
+
+func (s *macroServiceServer) Suggest(ctx context.Context, req *macrospb.SuggestRequest) (*macrospb.MacroList, error) {
+	query := &macroQuery{
+		Type:   queryTypeSuggestion,
+		Page:   int(req.Page),
+		Limit:  int(req.Limit),
+		Cursor: req.Cursor,
+	}
+
+	return s.listMacros(ctx, query, req.Collection), nil
+}
+
+func (s *macroServiceServer) Get(ctx context.Context, req *macrospb.GetRequest) (*macrospb.GetResponse, error) {
+	client := newRPCBigQueryClient(ctx)
+	defer client.Close()
+
+	scope := getQueryScope(ctx, "", getRPCCallerToken(ctx))
+
+	result, err := queryMacros(ctx, client, &macroQuery{Type: queryTypeGet, Text: req.Name}, scope)
+	if err != nil {
+		log.Panicf("failed to query macro: %v", err)
+	}
+
+	if len(result.Rows) == 0 {
+		return &macrospb.GetResponse{Code: MacroNotFound}, nil
+	}
+
+	return &macrospb.GetResponse{Code: Success, Macro: toProtoMacro(result.Rows[0])}, nil
+}
+
+// waitForAddJob polls the status of the add job until it's done.
+func waitForAddJob(ctx context.Context, jobID string) (*addResponse, error) {
+	_, store := getJobBackend()
+
+	ticker := time.NewTicker(cAddJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		jobStatus, err := store.Get(ctx, jobID)
+		if err != nil {
+			return nil, err
+		}
+
+		if jobStatus == nil {
+			return &addResponse{Code: JobNotFound}, nil
+		}
+
+		if jobStatus.Done {
+			return jobStatus.Response, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *macroServiceServer) Add(ctx context.Context, req *macrospb.AddRequest) (*macrospb.AddResponse, error) {
+	form := url.Values{
+		"name":       {req.Name},
+		"url":        {req.Url},
+		"github_url": {req.GithubUrl},
+	}
+
+	if req.AliasOf != "" {
+		form.Set("alias_of", req.AliasOf)
+	}
+
+	if req.AllowSimilar {
+		form.Set("allow_similar", "true")
+	}
+
+	if req.Optimize {
+		form.Set("optimize", "true")
+	}
+
+	jobID, errCode := submitAddJob(ctx, form, getRPCCallerToken(ctx))
+	if errCode != Success {
+		return &macrospb.AddResponse{Code: int32(errCode)}, nil
+	}
+
+	result, err := waitForAddJob(ctx, jobID)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+
+		log.Panicf("failed to wait for job %s: %v", jobID, err)
+	}
+
+	return &macrospb.AddResponse{
+		Code:             int32(result.Code),
+		JobId:            jobID,
+		Macro:            toProtoMacro(result.Data),
+		OriginalSize:     result.OriginalSize,
+		FinalSize:        result.FinalSize,
+		SupportedFormats: result.SupportedFormats,
+		Similar:          toProtoMacros(result.Similar),
+		Suggestions:      result.Suggestions,
+	}, nil
+}
+
+func (s *macroServiceServer) RecordUsage(
+	ctx context.Context,
+	req *macrospb.RecordUsageRequest,
+) (*macrospb.CodeResponse, error) {
+	if req.Name == "" {
+		return &macrospb.CodeResponse{Code: EmptyName}, nil
+	}
+
+	trigger := cClickTrigger
+	if req.Trigger == macrospb.UsageTrigger_USAGE_TRIGGER_DIRECT {
+		trigger = cDirectTrigger
+	}
+
+	recordMacroUsage(ctx, getRPCCallerToken(ctx), macroReference(splitMacroReference(req.Name)), trigger)
+
+	return &macrospb.CodeResponse{Code: Success}, nil
+}
+
+func (s *macroServiceServer) Report(ctx context.Context, req *macrospb.ReportRequest) (*macrospb.CodeResponse, error) {
+	if req.Name == "" {
+		return &macrospb.CodeResponse{Code: EmptyName}, nil
+	}
+
+	client := newRPCBigQueryClient(ctx)
+	defer client.Close()
+
+	return &macrospb.CodeResponse{Code: int32(reportMacro(ctx, client, macroReference(splitMacroReference(req.Name))))}, nil
+}
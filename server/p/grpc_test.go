@@ -0,0 +1,209 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/avishail/github-macros/server/rpc/macrospb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestMacroServiceClient serves the MacroService over an in-memory
+// connection for the duration of the test.
+func newTestMacroServiceClient(t *testing.T) macrospb.MacroServiceClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := NewGRPCServer()
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithInsecure(),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+	})
+
+	return macrospb.NewMacroServiceClient(conn)
+}
+
+func TestMacroServiceQueries(t *testing.T) {
+	useFakeBigQuery(t)
+	setTestEnv(t, "FUNCTION_TARGET", "")
+	setTestEnv(t, cCacheBackendEnv, "none")
+
+	rpcClient := newTestMacroServiceClient(t)
+	ctx := context.Background()
+
+	list, err := rpcClient.Search(ctx, &macrospb.SearchRequest{Text: "lgtm", Limit: 5})
+	if err != nil || list.Code != Success || len(list.Macros) != 1 || list.Macros[0].Name != "lgtm" {
+		t.Errorf("Search() = %v, %v, want lgtm", list, err)
+	}
+
+	list, err = rpcClient.Suggest(ctx, &macrospb.SuggestRequest{})
+	if err != nil || list.Code != Success || len(list.Macros) != 1 {
+		t.Errorf("Suggest() = %v, %v, want lgtm", list, err)
+	}
+
+	for _, req := range []*macrospb.SearchRequest{{Text: "lgtm", Page: -1}, {Text: "lgtm", Limit: -1}, {Text: "lgtm", Cursor: "garbage"}} {
+		if list, err := rpcClient.Search(ctx, req); err != nil || list.Code != InvalidRequest {
+			t.Errorf("Search(%v) = %v, %v, want code %d", req, list, err, InvalidRequest)
+		}
+	}
+
+	macro, err := rpcClient.Get(ctx, &macrospb.GetRequest{Name: "lgtm"})
+	if err != nil || macro.Code != Success || macro.Macro.GetUrl() != "https://example.com/lgtm.gif" {
+		t.Errorf("Get() = %v, %v, want lgtm", macro, err)
+	}
+
+	if macro, err := rpcClient.Get(ctx, &macrospb.GetRequest{Name: cMissingValue}); err != nil || macro.Code != MacroNotFound {
+		t.Errorf("Get() of a missing macro = %v, %v, want code %d", macro, err, MacroNotFound)
+	}
+}
+
+func TestMacroServiceMutations(t *testing.T) {
+	useFakeBigQuery(t)
+	resetUsageBuffer(t)
+	setTestEnv(t, "FUNCTION_TARGET", "")
+	setTestEnv(t, cCacheBackendEnv, "none")
+
+	rpcClient := newTestMacroServiceClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call func() (int32, error)
+		want int32
+	}{
+		{"usage", func() (int32, error) {
+			response, err := rpcClient.RecordUsage(ctx, &macrospb.RecordUsageRequest{
+				Name:    "lgtm",
+				Trigger: macrospb.UsageTrigger_USAGE_TRIGGER_DIRECT,
+			})
+			return response.GetCode(), err
+		}, Success},
+		{"usage without a name", func() (int32, error) {
+			response, err := rpcClient.RecordUsage(ctx, &macrospb.RecordUsageRequest{})
+			return response.GetCode(), err
+		}, EmptyName},
+		{"report", func() (int32, error) {
+			response, err := rpcClient.Report(ctx, &macrospb.ReportRequest{Name: "lgtm"})
+			return response.GetCode(), err
+		}, Success},
+		{"report of a missing macro", func() (int32, error) {
+			response, err := rpcClient.Report(ctx, &macrospb.ReportRequest{Name: cMissingValue})
+			return response.GetCode(), err
+		}, MacroNotFound},
+		{"report without a name", func() (int32, error) {
+			response, err := rpcClient.Report(ctx, &macrospb.ReportRequest{})
+			return response.GetCode(), err
+		}, EmptyName},
+		{"add without a name", func() (int32, error) {
+			response, err := rpcClient.Add(ctx, &macrospb.AddRequest{Url: "https://example.com/lgtm.gif"})
+			return response.GetCode(), err
+		}, EmptyName},
+		{"add to an organization without a token", func() (int32, error) {
+			response, err := rpcClient.Add(ctx, &macrospb.AddRequest{Name: "acme/lgtm", Url: "https://example.com/lgtm.gif"})
+			return response.GetCode(), err
+		}, AuthenticationRequired},
+	}
+
+	for _, test := range tests {
+		if got, err := test.call(); err != nil || got != test.want {
+			t.Errorf("%s = %d, %v, want %d", test.name, got, err, test.want)
+		}
+	}
+
+	if usages := getUsageBuffer().(*memoryUsageBuffer).batch.size(); usages != 1 {
+		t.Errorf("%d usages were buffered, want 1", usages)
+	}
+}
+
+func TestWaitForAddJob(t *testing.T) {
+	setTestEnv(t, "FUNCTION_TARGET", "")
+
+	_, store := getJobBackend()
+	ctx := context.Background()
+
+	done := &addJobStatus{ID: "grpc-done", Stage: cStageDone, Done: true, Response: getAddResponse(nil, FileIsTooBig)}
+	if err := store.Save(ctx, done); err != nil {
+		t.Fatalf("failed to save job: %v", err)
+	}
+
+	if response, err := waitForAddJob(ctx, "grpc-done"); err != nil || response.Code != FileIsTooBig {
+		t.Errorf("waitForAddJob() = %+v, %v, want code %d", response, err, FileIsTooBig)
+	}
+
+	if response, err := waitForAddJob(ctx, "grpc-unknown"); err != nil || response.Code != JobNotFound {
+		t.Errorf("waitForAddJob() of an unknown job = %+v, %v, want code %d", response, err, JobNotFound)
+	}
+
+	if err := store.Save(ctx, &addJobStatus{ID: "grpc-running", Stage: cStageFetching}); err != nil {
+		t.Fatalf("failed to save job: %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := waitForAddJob(timeoutCtx, "grpc-running"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitForAddJob() of a running job = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRecoverUnaryInterceptor(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/macros.MacroService/Get"}
+
+	_, err := recoverUnaryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("recoverUnaryInterceptor() of a panic = %v, want %v", err, codes.Internal)
+	}
+
+	response, err := recoverUnaryInterceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	})
+	if response != "ok" || err != nil {
+		t.Errorf("recoverUnaryInterceptor() = %v, %v, want the handler response", response, err)
+	}
+}
+
+func TestGetRPCCallerToken(t *testing.T) {
+	tests := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), ""},
+		{metadata.NewIncomingContext(context.Background(), metadata.Pairs()), ""},
+		{metadata.NewIncomingContext(context.Background(), metadata.Pairs(cAuthorizationMetadata, "token abc")), "abc"},
+		{metadata.NewIncomingContext(context.Background(), metadata.Pairs(cAuthorizationMetadata, "Bearer abc")), "abc"},
+		{metadata.NewIncomingContext(context.Background(), metadata.Pairs(cAuthorizationMetadata, "Basic abc")), ""},
+	}
+
+	for i, test := range tests {
+		if got := getRPCCallerToken(test.ctx); got != test.want {
+			t.Errorf("getRPCCallerToken() of case %d = %q, want %q", i, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package p
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	cPHashWidth            = 9
+	cPHashHeight           = 8
+	cPHashThresholdEnv     = "PHASH_DISTANCE_THRESHOLD"
+	cDefaultPHashThreshold = 8
+	cMaxSimilarMacros      = 5
+	cPHashBits             = 64
+	// the image is first scaled to cPHashBlock times the hash size, then
+	// every block is averaged into a hash pixel
+	cPHashBlock = 8
+)
+
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// perceptualHash computes a 64 bit difference hash: the image is scaled down
+// to 9x8 grayscale pixels and every bit tells whether a pixel is brighter
+// than its right neighbour. Resized or re-encoded copies of an image get the
+// same or a very close hash. Scaling straight to 9x8 only samples a few
+// source pixels and aliases, so the image is scaled to an intermediate size
+// whose blocks are averaged.
+func perceptualHash(img image.Image) int64 {
+	intermediate := image.NewGray(image.Rect(0, 0, cPHashWidth*cPHashBlock, cPHashHeight*cPHashBlock))
+	draw.CatmullRom.Scale(intermediate, intermediate.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	gray := image.NewGray(image.Rect(0, 0, cPHashWidth, cPHashHeight))
+
+	for y := 0; y < cPHashHeight; y++ {
+		for x := 0; x < cPHashWidth; x++ {
+			sum := 0
+
+			for by := 0; by < cPHashBlock; by++ {
+				for bx := 0; bx < cPHashBlock; bx++ {
+					sum += int(intermediate.GrayAt(x*cPHashBlock+bx, y*cPHashBlock+by).Y)
+				}
+			}
+
+			gray.SetGray(x, y, color.Gray{Y: uint8(sum / (cPHashBlock * cPHashBlock))})
+		}
+	}
+
+	var hash uint64
+
+	for y := 0; y < cPHashHeight; y++ {
+		for x := 0; x < cPHashWidth-1; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y > gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	// BigQuery has no unsigned integers, the bits are stored as is
+	return int64(hash)
+}
+
+// getPHashThreshold returns the maximal number of differing bits for two
+// hashes to be considered the same image.
+func getPHashThreshold() int64 {
+	value := os.Getenv(cPHashThresholdEnv)
+	if value == "" {
+		return cDefaultPHashThreshold
+	}
+
+	threshold, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || threshold < 0 || threshold > cPHashBits {
+		log.Printf("invalid %s value %q, using %d", cPHashThresholdEnv, value, cDefaultPHashThreshold)
+		return cDefaultPHashThreshold
+	}
+
+	return threshold
+}
@@ -0,0 +1,125 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// newGradientImage draws a diagonal gradient with a bright square, so its
+// hash has bits set and differs from the mirrored image.
+func newGradientImage(width, height int, mirrored bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px := x
+			if mirrored {
+				px = width - 1 - x
+			}
+
+			level := uint8((px*255/width + y*64/height) % 256)
+			if px > width/4 && px < width/2 && y > height/4 && y < height/2 {
+				level = 255
+			}
+
+			img.Set(x, y, color.RGBA{R: level, G: level, B: level, A: 255})
+		}
+	}
+
+	return img
+}
+
+func hashDistance(a, b int64) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := newGradientImage(320, 240, false)
+
+	resized := image.NewRGBA(image.Rect(0, 0, 97, 73))
+	draw.BiLinear.Scale(resized, resized.Bounds(), original, original.Bounds(), draw.Src, nil)
+
+	hash := perceptualHash(original)
+	if hash == 0 {
+		t.Fatalf("perceptualHash() = 0, want bits set for a gradient")
+	}
+
+	if distance := hashDistance(hash, perceptualHash(resized)); distance > cDefaultPHashThreshold {
+		t.Errorf("distance between the image and a resized copy = %d, want at most %d", distance, cDefaultPHashThreshold)
+	}
+
+	if distance := hashDistance(hash, perceptualHash(newGradientImage(320, 240, true))); distance <= cDefaultPHashThreshold {
+		t.Errorf("distance between different images = %d, want more than %d", distance, cDefaultPHashThreshold)
+	}
+}
+
+func TestGetPHashThreshold(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", cDefaultPHashThreshold},
+		{"12", 12},
+		{"0", 0},
+		{"-1", cDefaultPHashThreshold},
+		{"65", cDefaultPHashThreshold},
+		{"many", cDefaultPHashThreshold},
+	}
+
+	for _, test := range tests {
+		setTestEnv(t, cPHashThresholdEnv, test.value)
+
+		if got := getPHashThreshold(); got != test.want {
+			t.Errorf("getPHashThreshold() with %s=%q = %d, want %d", cPHashThresholdEnv, test.value, got, test.want)
+		}
+	}
+}
+
+func TestGetMediaErrorCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want ErrorCode
+	}{
+		{errFileTooBig, FileIsTooBig},
+		{fmt.Errorf("decode: %w", errImageTooLarge), FileIsTooBig},
+		{fmt.Errorf("decode: %w", errImageIsCorrupted), FileIsCorrupted},
+		{errUnsupportedImage, FileFormatNotSupported},
+		{fmt.Errorf("fetch: %w", errHostNotAllowed), URLHostnameNotSupported},
+		{&fetchStatusError{StatusCode: 503}, TransientError},
+		{&fetchStatusError{StatusCode: 404}, ImageFetchFailed},
+		{errors.New("no such host"), InvalidURL},
+	}
+
+	for _, test := range tests {
+		if got := getMediaErrorCode(test.err); got != test.want {
+			t.Errorf("getMediaErrorCode(%v) = %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+func TestQuerySimilarMacros(t *testing.T) {
+	useFakeBigQuery(t)
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	if similar := querySimilarMacros(ctx, client, "", &decodedImage{SHA256: "digest"}); len(similar) != 1 {
+		t.Errorf("querySimilarMacros() = %d macros, want 1", len(similar))
+	}
+
+	if similar := querySimilarMacros(ctx, client, "", &decodedImage{SHA256: cMissingValue}); len(similar) != 0 {
+		t.Errorf("querySimilarMacros() without a match = %d macros, want 0", len(similar))
+	}
+}
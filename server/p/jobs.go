@@ -0,0 +1,459 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	cJobQueueEnv       = "JOB_QUEUE"
+	cJobQueuePubSub    = "pubsub"
+	cJobQueueMemory    = "memory"
+	cJobTopicEnv       = "JOB_TOPIC"
+	cJobWorkersEnv     = "JOB_WORKERS"
+	cDefaultJobTopic   = "add-jobs"
+	cDefaultJobWorkers = 2
+	cJobQueueSize      = 100
+	cJobRetention      = time.Hour
+)
+
+// Stages an add job goes through, reported by the job status endpoint.
+const (
+	cStageQueued             = "queued"
+	cStageValidating         = "validating"
+	cStageFetching           = "fetching"
+	cStageOptimizing         = "optimizing"
+	cStageDecoding           = "decoding"
+	cStageDeduplicating      = "deduplicating"
+	cStageUploading          = "uploading"
+	cStageGeneratingVariants = "generating_variants"
+	cStageSaving             = "saving"
+	cStageDone               = "done"
+)
+
+var errJobQueueFull = errors.New("job queue is full")
+
+// addJob is a queued add request, Form holds the fields of the original request.
+type addJob struct {
+	ID   string     `json:"id"`
+	Form url.Values `json:"form"`
+}
+
+// addJobStatus is the progress of an add job. Response is the add response,
+// set once the job is done.
+type addJobStatus struct {
+	ID        string
+	Stage     string
+	Done      bool
+	Response  *addResponse
+	UpdatedAt time.Time
+}
+
+// jobQueue hands add jobs over to a worker.
+type jobQueue interface {
+	Enqueue(ctx context.Context, job *addJob) error
+}
+
+// jobStore keeps the latest status of every add job.
+type jobStore interface {
+	Save(ctx context.Context, status *addJobStatus) error
+	// SaveStage records the stage a running job moved to. Stores that can't
+	// afford a write per stage skip it, the job then reports the stage it was
+	// claimed at until it's done.
+	SaveStage(ctx context.Context, status *addJobStatus) error
+	Get(ctx context.Context, id string) (*addJobStatus, error)
+	// Claim atomically moves a queued job to the validating stage, so a job
+	// delivered more than once is processed by a single worker. It returns
+	// false when the job was already claimed.
+	Claim(ctx context.Context, id string) (bool, error)
+}
+
+// memoryJobQueue processes jobs by a pool of goroutines in the same process,
+// for self hosted servers.
+type memoryJobQueue struct {
+	jobs      chan *addJob
+	store     jobStore
+	startOnce sync.Once
+	pending   sync.WaitGroup
+}
+
+type memoryJobStore struct {
+	mu       sync.Mutex
+	statuses map[string]*addJobStatus
+}
+
+// pubSubJobQueue publishes jobs to a Pub/Sub topic, whose push subscription
+// delivers them to the AddWorker function.
+type pubSubJobQueue struct {
+	topic string
+}
+
+// bigqueryJobStore appends a row when the job is queued and when it's done,
+// and reads the latest one, avoiding concurrent DML updates of the same row.
+// Only the claim updates a row.
+type bigqueryJobStore struct{}
+
+var (
+	jobBackendOnce sync.Once
+	addJobQueue    jobQueue
+	addJobStore    jobStore
+)
+
+// getJobBackend returns Pub/Sub and BigQuery when JOB_QUEUE is pubsub or when
+// running as a Cloud Function, and the in-process queue otherwise.
+func getJobBackend() (jobQueue, jobStore) {
+	backend := os.Getenv(cJobQueueEnv)
+
+	// add, add_status and add_worker are separate deployments, an in-process
+	// queue would never be seen by the other functions
+	if isCloudFunction() && backend != "" && backend != cJobQueuePubSub {
+		log.Panicf("%s=%s can't be used by Cloud Functions, use %s", cJobQueueEnv, backend, cJobQueuePubSub)
+	}
+
+	jobBackendOnce.Do(func() {
+		if backend == cJobQueuePubSub || (backend == "" && isCloudFunction()) {
+			topic := os.Getenv(cJobTopicEnv)
+			if topic == "" {
+				topic = cDefaultJobTopic
+			}
+
+			addJobQueue = &pubSubJobQueue{topic: topic}
+			addJobStore = &bigqueryJobStore{}
+
+			return
+		}
+
+		store := &memoryJobStore{statuses: map[string]*addJobStatus{}}
+		addJobQueue = &memoryJobQueue{jobs: make(chan *addJob, cJobQueueSize), store: store}
+		addJobStore = store
+	})
+
+	return addJobQueue, addJobStore
+}
+
+func getJobWorkers() int {
+	workers, err := strconv.Atoi(os.Getenv(cJobWorkersEnv))
+	if err != nil || workers <= 0 {
+		return cDefaultJobWorkers
+	}
+
+	return workers
+}
+
+func (q *memoryJobQueue) Enqueue(_ context.Context, job *addJob) error {
+	q.startOnce.Do(func() {
+		for i := 0; i < getJobWorkers(); i++ {
+			go q.work()
+		}
+	})
+
+	q.pending.Add(1)
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		q.pending.Done()
+		return errJobQueueFull
+	}
+}
+
+func (q *memoryJobQueue) work() {
+	for job := range q.jobs {
+		ctx, cancel := withStageTimeout(context.Background(), stageRequest)
+		processAddJob(ctx, q.store, job)
+		cancel()
+		q.pending.Done()
+	}
+}
+
+// wait blocks until all the queued jobs are processed or ctx is done.
+func (q *memoryJobQueue) wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *memoryJobStore) Save(_ context.Context, status *addJobStatus) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	for id, saved := range s.statuses {
+		if saved.Done && now.Sub(saved.UpdatedAt) > cJobRetention {
+			delete(s.statuses, id)
+		}
+	}
+
+	saved := *status
+	saved.UpdatedAt = now
+	s.statuses[status.ID] = &saved
+
+	return nil
+}
+
+func (s *memoryJobStore) SaveStage(ctx context.Context, status *addJobStatus) error {
+	return s.Save(ctx, status)
+}
+
+func (s *memoryJobStore) Get(_ context.Context, id string) (*addJobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[id]
+	if !ok {
+		return nil, nil
+	}
+
+	saved := *status
+
+	return &saved, nil
+}
+
+// Claim claims queued jobs, the in-process queue never delivers a job twice.
+func (s *memoryJobStore) Claim(_ context.Context, id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.statuses[id]
+	if !ok || status.Stage != cStageQueued {
+		return false, nil
+	}
+
+	status.Stage = cStageValidating
+	status.UpdatedAt = time.Now()
+
+	return true, nil
+}
+
+func (q *pubSubJobQueue) Enqueue(ctx context.Context, job *addJob) error {
+	return publishMessage(ctx, q.topic, job)
+}
+
+func (s *bigqueryJobStore) Save(ctx context.Context, status *addJobStatus) error {
+	response := ""
+
+	if status.Response != nil {
+		responseBytes, err := json.Marshal(status.Response)
+		if err != nil {
+			return fmt.Errorf("failed to marshal job response: %v", err)
+		}
+
+		response = string(responseBytes)
+	}
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	query := client.Query(`
+		INSERT INTO github-macros.macros.add_jobs (id, stage, done, response, update_time)
+		VALUES (@id, @stage, @done, @response, CURRENT_TIMESTAMP())
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "id",
+			Value: status.ID,
+		},
+		{
+			Name:  "stage",
+			Value: status.Stage,
+		},
+		{
+			Name:  "done",
+			Value: status.Done,
+		},
+		{
+			Name:  "response",
+			Value: response,
+		},
+	}
+
+	_, err = runQuery(ctx, query)
+
+	return err
+}
+
+// SaveStage doesn't record the stages of running jobs: BigQuery limits the
+// DML statements per table and each takes seconds, so every add would be
+// slowed down by several of them.
+func (s *bigqueryJobStore) SaveStage(_ context.Context, _ *addJobStatus) error {
+	return nil
+}
+
+func (s *bigqueryJobStore) Get(ctx context.Context, id string) (*addJobStatus, error) {
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	query := client.Query(`
+		SELECT id, stage, done, response, update_time
+		FROM github-macros.macros.add_jobs
+		WHERE id=@id
+		ORDER BY done DESC, update_time DESC
+		LIMIT 1
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "id",
+			Value: id,
+		},
+	}
+
+	iter, err := runQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	var row struct {
+		ID         string    `bigquery:"id"`
+		Stage      string    `bigquery:"stage"`
+		Done       bool      `bigquery:"done"`
+		Response   string    `bigquery:"response"`
+		UpdateTime time.Time `bigquery:"update_time"`
+	}
+
+	if err = iter.Next(&row); err != nil {
+		if err == iterator.Done {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	status := &addJobStatus{
+		ID:        row.ID,
+		Stage:     row.Stage,
+		Done:      row.Done,
+		UpdatedAt: row.UpdateTime,
+	}
+
+	if row.Response != "" {
+		if err = json.Unmarshal([]byte(row.Response), &status.Response); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job response: %v", err)
+		}
+	}
+
+	return status, nil
+}
+
+// Claim updates the queued row of the job rather than appending one: BigQuery
+// fails one of two DML statements changing the same rows concurrently, so only
+// one of the workers a job was delivered to changes the row. A job claimed longer
+// than the request timeout ago and not done is claimed again, the worker that
+// claimed it is assumed to have crashed.
+func (s *bigqueryJobStore) Claim(ctx context.Context, id string) (bool, error) {
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return false, fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	query := client.Query(`
+		UPDATE github-macros.macros.add_jobs
+		SET stage = @claimed_stage, update_time = CURRENT_TIMESTAMP()
+		WHERE id = @id
+		AND (
+			stage = @queued_stage
+			OR (
+				stage = @claimed_stage
+				AND update_time < TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL @lease_seconds SECOND)
+			)
+		)
+		AND NOT EXISTS (SELECT 1 FROM github-macros.macros.add_jobs WHERE id = @id AND done)
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "id",
+			Value: id,
+		},
+		{
+			Name:  "queued_stage",
+			Value: cStageQueued,
+		},
+		{
+			Name:  "claimed_stage",
+			Value: cStageValidating,
+		},
+		{
+			Name:  "lease_seconds",
+			Value: int64(stageRequest.timeout().Seconds()),
+		},
+	}
+
+	updated, err := runDMLQuery(ctx, query)
+	if err != nil {
+		return false, err
+	}
+
+	return updated > 0, nil
+}
+
+// processAddJob runs the add job and records its progress and final response.
+func processAddJob(ctx context.Context, store jobStore, job *addJob) {
+	onStage := func(stage string) {
+		if err := store.SaveStage(ctx, &addJobStatus{ID: job.ID, Stage: stage}); err != nil {
+			log.Printf("failed to save stage %s of job %s: %v", stage, job.ID, err)
+		}
+	}
+
+	response := runAddJob(ctx, job, onStage)
+
+	if err := store.Save(ctx, &addJobStatus{ID: job.ID, Stage: cStageDone, Done: true, Response: response}); err != nil {
+		log.Printf("failed to save result of job %s: %v", job.ID, err)
+	}
+}
+
+// runAddJob executes the add request, turning a panic in any of its stages
+// into an InfraFailure response rather than losing the job.
+func runAddJob(ctx context.Context, job *addJob, onStage func(stage string)) (response *addResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("add job %s failed: %v", job.ID, r)
+
+			response = getAddResponse(nil, InfraFailure)
+		}
+	}()
+
+	result, errCode := executaAdd(ctx, job.Form, onStage)
+
+	return getAddResponse(result, errCode)
+}
+
+// WaitForPendingJobs blocks until the add jobs queued in this process are
+// processed, so a self hosted server can shut down gracefully.
+func WaitForPendingJobs(ctx context.Context) error {
+	queue, _ := getJobBackend()
+
+	if memoryQueue, ok := queue.(*memoryJobQueue); ok {
+		return memoryQueue.wait(ctx)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package p
+
+import (
+	"context"
+	"net/url"
+	"testing"
+)
+
+func TestProcessAddJob(t *testing.T) {
+	useFakeBigQuery(t)
+
+	ctx := context.Background()
+	store := &memoryJobStore{statuses: map[string]*addJobStatus{}}
+	job := &addJob{ID: "job", Form: url.Values{"name": {""}, "url": {"https://example.com/a.gif"}}}
+
+	if err := store.Save(ctx, &addJobStatus{ID: job.ID, Stage: cStageQueued}); err != nil {
+		t.Fatalf("Save() = %v", err)
+	}
+
+	if claimed, err := store.Claim(ctx, job.ID); !claimed || err != nil {
+		t.Fatalf("Claim() = %v, %v, want true", claimed, err)
+	}
+
+	if claimed, _ := store.Claim(ctx, job.ID); claimed {
+		t.Errorf("Claim() of a claimed job = true, want false")
+	}
+
+	processAddJob(ctx, store, job)
+
+	status, err := store.Get(ctx, job.ID)
+	if err != nil || status == nil {
+		t.Fatalf("Get() = %v, %v", status, err)
+	}
+
+	if !status.Done || status.Stage != cStageDone || status.Response == nil || status.Response.Code != EmptyName {
+		t.Errorf("Get() = %+v, want a done job failed with %d", status, EmptyName)
+	}
+}
+
+func TestBigQueryJobStoreSkipsStages(t *testing.T) {
+	fake := useFakeBigQuery(t)
+
+	ctx := context.Background()
+	store := &bigqueryJobStore{}
+	job := &addJob{ID: "job", Form: url.Values{"name": {""}, "url": {"https://example.com/a.gif"}}}
+
+	if err := store.SaveStage(ctx, &addJobStatus{ID: job.ID, Stage: cStageFetching}); err != nil {
+		t.Fatalf("SaveStage() = %v", err)
+	}
+
+	if queries := fake.queryCount(); queries != 0 {
+		t.Errorf("SaveStage() ran %d queries, want 0", queries)
+	}
+
+	// only the result is written, the job fails validation before any other
+	// query
+	processAddJob(ctx, store, job)
+
+	if queries := fake.queryCount(); queries != 1 {
+		t.Errorf("processAddJob() ran %d queries, want 1", queries)
+	}
+}
@@ -0,0 +1,132 @@
+package p
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	cMediaBucketEnv        = "MEDIA_BUCKET"
+	cMediaPrivateBucketEnv = "MEDIA_PRIVATE_BUCKET"
+	cMediaDirEnv           = "MEDIA_DIR"
+	cMediaBaseURLEnv       = "MEDIA_BASE_URL"
+
+	cMediaCacheControl        = "public, max-age=31536000, immutable"
+	cPrivateMediaCacheControl = "private, max-age=3600"
+	cMediaFileMode            = 0o644
+	cMediaDirMode             = 0o755
+)
+
+// mediaStore stores files generated by the server (e.g. thumbnails) and
+// returns the public URL they are served from, or the gs:// reference of
+// private media.
+type mediaStore interface {
+	Put(ctx context.Context, objectName, contentType string, data []byte) (string, error)
+}
+
+// gcsMediaStore stores media in a Cloud Storage bucket, publicly readable
+// unless it's the private bucket.
+type gcsMediaStore struct {
+	bucket       string
+	baseURL      string
+	cacheControl string
+}
+
+// localMediaStore stores media on the local disk, for self hosted servers
+// that serve the directory under MEDIA_BASE_URL.
+type localMediaStore struct {
+	dir     string
+	baseURL string
+}
+
+// getMediaStore returns the configured media store, or nil if none was
+// configured, in which case features depending on it are disabled.
+func getMediaStore() mediaStore {
+	if bucket := os.Getenv(cMediaBucketEnv); bucket != "" {
+		baseURL := os.Getenv(cMediaBaseURLEnv)
+		if baseURL == "" {
+			baseURL = fmt.Sprintf("https://storage.googleapis.com/%s", bucket)
+		}
+
+		return &gcsMediaStore{bucket: bucket, baseURL: strings.TrimSuffix(baseURL, "/"), cacheControl: cMediaCacheControl}
+	}
+
+	if dir := os.Getenv(cMediaDirEnv); dir != "" {
+		// stored media is referenced from GitHub comments, so its URLs have
+		// to be absolute
+		baseURL, err := url.Parse(os.Getenv(cMediaBaseURLEnv))
+		if err != nil || !baseURL.IsAbs() || baseURL.Host == "" {
+			log.Printf("%s is set without an absolute %s, the media store is disabled", cMediaDirEnv, cMediaBaseURLEnv)
+			return nil
+		}
+
+		return &localMediaStore{dir: dir, baseURL: strings.TrimSuffix(baseURL.String(), "/")}
+	}
+
+	return nil
+}
+
+// getNamespaceMediaStore returns the store of the media generated for macros
+// of namespace. Media of organization macros is only visible to members, so
+// it's kept in the private bucket and returned as gs:// references, which are
+// signed when sent to callers. It's nil when no private bucket is configured.
+func getNamespaceMediaStore(namespace string) mediaStore {
+	if namespace == "" {
+		return getMediaStore()
+	}
+
+	bucket := os.Getenv(cMediaPrivateBucketEnv)
+	if bucket == "" {
+		return nil
+	}
+
+	return &gcsMediaStore{bucket: bucket, baseURL: cPrivateMediaScheme + bucket, cacheControl: cPrivateMediaCacheControl}
+}
+
+func (s *gcsMediaStore) Put(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
+	ctx, cancel := withStageTimeout(ctx, stageStorage)
+	defer cancel()
+
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("storage.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	writer := client.Bucket(s.bucket).Object(objectName).NewWriter(ctx)
+	writer.ContentType = contentType
+	writer.CacheControl = s.cacheControl
+
+	if _, err = writer.Write(data); err != nil {
+		_ = writer.Close()
+		return "", fmt.Errorf("failed to write object %s: %v", objectName, err)
+	}
+
+	if err = writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close object %s: %v", objectName, err)
+	}
+
+	return s.baseURL + "/" + objectName, nil
+}
+
+func (s *localMediaStore) Put(_ context.Context, objectName, _ string, data []byte) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(objectName))
+
+	if err := os.MkdirAll(filepath.Dir(path), cMediaDirMode); err != nil {
+		return "", fmt.Errorf("failed to create media directory: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, data, cMediaFileMode); err != nil {
+		return "", fmt.Errorf("failed to write media file %s: %v", objectName, err)
+	}
+
+	return s.baseURL + "/" + objectName, nil
+}
@@ -0,0 +1,114 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	// cPrivateMediaScheme prefixes the references of media stored in the
+	// private bucket, they are replaced by signed URLs when sent to callers
+	cPrivateMediaScheme = "gs://"
+	cSignedMediaURLTTL  = 12 * time.Hour
+	// signed URLs are reused until they have less than this left, which is
+	// longer than results are cached for
+	cSignedMediaURLMinTTL = 6 * time.Hour
+)
+
+type signedMediaURL struct {
+	url     string
+	expires time.Time
+}
+
+var (
+	signingClientOnce sync.Once
+	signingClient     *storage.Client
+
+	// signedMediaURLs keeps the signed URLs of recently sent references, so
+	// every response doesn't cost a signature
+	signedMediaURLs = struct {
+		sync.Mutex
+		entries map[string]*signedMediaURL
+	}{entries: map[string]*signedMediaURL{}}
+)
+
+func getSigningClient() *storage.Client {
+	signingClientOnce.Do(func() {
+		var err error
+
+		if signingClient, err = storage.NewClient(context.Background()); err != nil {
+			log.Printf("storage.NewClient: %v", err)
+		}
+	})
+
+	return signingClient
+}
+
+// resolveMediaURL returns a short lived signed URL for references to the
+// private bucket, and other URLs as is. Variants are optional, so references
+// that can't be signed are dropped.
+func resolveMediaURL(reference string) string {
+	if !strings.HasPrefix(reference, cPrivateMediaScheme) {
+		return reference
+	}
+
+	signedMediaURLs.Lock()
+	cached, ok := signedMediaURLs.entries[reference]
+	signedMediaURLs.Unlock()
+
+	now := time.Now()
+
+	if ok && cached.expires.Sub(now) > cSignedMediaURLMinTTL {
+		return cached.url
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(reference, cPrivateMediaScheme), "/", 2)
+	client := getSigningClient()
+
+	if len(parts) != 2 || client == nil {
+		return ""
+	}
+
+	expires := now.Add(cSignedMediaURLTTL)
+
+	signed, err := client.Bucket(parts[0]).SignedURL(parts[1], &storage.SignedURLOptions{
+		Method:  "GET",
+		Expires: expires,
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		log.Printf("failed to sign %s: %v", reference, err)
+		return ""
+	}
+
+	signedMediaURLs.Lock()
+	defer signedMediaURLs.Unlock()
+
+	for key, entry := range signedMediaURLs.entries {
+		if now.After(entry.expires) {
+			delete(signedMediaURLs.entries, key)
+		}
+	}
+
+	signedMediaURLs.entries[reference] = &signedMediaURL{url: signed, expires: expires}
+
+	return signed
+}
+
+// MarshalJSON sends the variants of macros stored in the private bucket as
+// signed URLs, the stored rows keep the references.
+func (row MacroRow) MarshalJSON() ([]byte, error) {
+	type plainMacroRow MacroRow
+
+	resolved := plainMacroRow(row)
+	resolved.ThumbnailURL = resolveMediaURL(row.ThumbnailURL)
+	resolved.PreviewURL = resolveMediaURL(row.PreviewURL)
+
+	return json.Marshal(resolved)
+}
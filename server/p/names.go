@@ -0,0 +1,319 @@
+package p
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	cMinNameLength   = 2
+	cMaxNameLength   = 32
+	cBlockedNamesEnv = "BLOCKED_NAMES"
+	cNameSynonymsEnv = "NAME_SYNONYMS"
+	// suggestions are taken from names with a number appended, up to this one
+	cMaxSuggestionNumber = 9
+	cMaxNameSuggestions  = 3
+)
+
+// nameSuggestionSuffixes are appended to a taken name, after the numbered
+// variations ran out.
+var nameSuggestionSuffixes = []string{"-gif", "-meme", "-reaction", "-alt"}
+
+// nameSynonyms are groups of interchangeable words. Macros have no tags, so
+// the words of a taken name are replaced by their synonyms from these groups
+// and the ones of the NAME_SYNONYMS environment variable.
+var nameSynonyms = [][]string{
+	{"lgtm", "approved", "shipit"},
+	{"yes", "yep", "yeah"},
+	{"no", "nope", "nah"},
+	{"thanks", "thx", "ty"},
+	{"lol", "haha", "rofl"},
+	{"wow", "omg", "whoa"},
+	{"sad", "cry", "tears"},
+	{"angry", "mad", "rage"},
+	{"happy", "glad", "joy"},
+	{"party", "dance", "celebrate"},
+	{"facepalm", "doh"},
+	{"cat", "kitty"},
+	{"dog", "doggo", "puppy"},
+	{"fire", "lit"},
+	{"bug", "glitch"},
+	{"fix", "fixed", "patch"},
+	{"ship", "deploy", "release"},
+	{"wait", "hold", "pause"},
+	{"think", "hmm", "thinking"},
+	{"clap", "applause", "bravo"},
+	{"ok", "okay", "fine"},
+}
+
+// Names are embedded in comments as $name$, so they are limited to characters
+// that can't end the pattern or be mangled by the markdown renderer.
+var validNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+var nameWordPattern = regexp.MustCompile(`[^-_]+`)
+
+// reservedNames can't be used as macro names regardless of their case. More
+// names can be blocked with the BLOCKED_NAMES environment variable.
+var reservedNames = []string{
+	"add",
+	"admin",
+	"autocomplete",
+	"available",
+	"get",
+	"github",
+	"help",
+	"macro",
+	"macros",
+	"null",
+	"personal",
+	"report",
+	"search",
+	"suggestion",
+	"undefined",
+	"usage",
+}
+
+func isReservedName(macroName string) bool {
+	for _, reserved := range reservedNames {
+		if strings.EqualFold(macroName, reserved) {
+			return true
+		}
+	}
+
+	for _, blocked := range strings.Split(os.Getenv(cBlockedNamesEnv), ",") {
+		blocked = strings.TrimSpace(blocked)
+		if blocked != "" && strings.EqualFold(macroName, blocked) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateMacroName checks the name against the naming policy. Uniqueness is
+// case insensitive and checked against the stored macros separately.
+func validateMacroName(macroName string) ErrorCode {
+	if macroName == "" {
+		return EmptyName
+	}
+
+	if strings.IndexFunc(macroName, unicode.IsSpace) >= 0 {
+		return NameContainsSpaces
+	}
+
+	if !validNamePattern.MatchString(macroName) {
+		return NameContainsInvalidCharacters
+	}
+
+	if len(macroName) < cMinNameLength {
+		return NameIsTooShort
+	}
+
+	if len(macroName) > cMaxNameLength {
+		return NameIsTooLong
+	}
+
+	if isReservedName(macroName) {
+		return NameIsReserved
+	}
+
+	return Success
+}
+
+// getNameSynonyms returns the synonyms of the lower cased word. NAME_SYNONYMS
+// holds semicolon separated groups of comma separated words.
+func getNameSynonyms(word string) []string {
+	groups := nameSynonyms
+
+	for _, group := range strings.Split(os.Getenv(cNameSynonymsEnv), ";") {
+		words := []string{}
+
+		for _, synonym := range strings.Split(group, ",") {
+			if synonym = strings.ToLower(strings.TrimSpace(synonym)); synonym != "" {
+				words = append(words, synonym)
+			}
+		}
+
+		groups = append(groups, words)
+	}
+
+	synonyms := []string{}
+
+	for _, group := range groups {
+		for i, synonym := range group {
+			if synonym != word {
+				continue
+			}
+
+			synonyms = append(synonyms, group[:i]...)
+			synonyms = append(synonyms, group[i+1:]...)
+		}
+	}
+
+	return synonyms
+}
+
+// synonymNames replaces every word of the name, separated by dashes and
+// underscores, by its synonyms one at a time, so "lgtm-cat" suggests
+// "approved-cat" and "lgtm-kitty".
+func synonymNames(macroName string) []string {
+	names := []string{}
+	words := nameWordPattern.FindAllStringIndex(macroName, -1)
+
+	for _, word := range words {
+		for _, synonym := range getNameSynonyms(strings.ToLower(macroName[word[0]:word[1]])) {
+			names = append(names, macroName[:word[0]]+synonym+macroName[word[1]:])
+		}
+	}
+
+	return names
+}
+
+// nameSuggestionCandidates derives alternative names from a taken one: its
+// synonyms, then numbered and suffixed variations. Digits at the end of the
+// name are replaced, so "lgtm2" suggests "lgtm" and "lgtm3".
+func nameSuggestionCandidates(macroName string) []string {
+	base := strings.TrimRightFunc(macroName, unicode.IsDigit)
+	if base == "" {
+		base = macroName
+	}
+
+	candidates := []string{}
+	seen := map[string]bool{strings.ToLower(macroName): true}
+
+	addName := func(candidate string) {
+		if !seen[strings.ToLower(candidate)] && validateMacroName(candidate) == Success {
+			seen[strings.ToLower(candidate)] = true
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	addCandidate := func(suffix string) {
+		prefix := base
+		if len(prefix)+len(suffix) > cMaxNameLength {
+			prefix = prefix[:cMaxNameLength-len(suffix)]
+		}
+
+		addName(prefix + suffix)
+	}
+
+	addCandidate("")
+
+	for _, synonym := range synonymNames(base) {
+		addName(synonym)
+	}
+
+	for number := 2; number <= cMaxSuggestionNumber; number++ {
+		addCandidate(strconv.Itoa(number))
+	}
+
+	for _, suffix := range nameSuggestionSuffixes {
+		addCandidate(suffix)
+	}
+
+	return candidates
+}
+
+// queryTakenNames returns the lower cased names out of names that are
+// already used by a macro.
+func queryTakenNames(ctx context.Context, client *bigquery.Client, names []string) map[string]bool {
+	lowerNames := make([]string, 0, len(names))
+	for _, name := range names {
+		lowerNames = append(lowerNames, strings.ToLower(name))
+	}
+
+	query := client.Query(`
+		SELECT LOWER(name) AS name
+		FROM github-macros.macros.macros
+		WHERE LOWER(name) IN UNNEST(@names)
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "names",
+			Value: lowerNames,
+		},
+	}
+
+	taken := map[string]bool{}
+
+	for _, row := range getQueryResults(ctx, query) {
+		taken[row.Name] = true
+	}
+
+	return taken
+}
+
+// suggestAvailableNames returns a few available names similar to the taken
+// macroName, in the same namespace.
+func suggestAvailableNames(ctx context.Context, client *bigquery.Client, macroName string) []string {
+	namespace, name := splitMacroReference(macroName)
+
+	candidates := []string{}
+	for _, candidate := range nameSuggestionCandidates(name) {
+		candidates = append(candidates, macroReference(namespace, candidate))
+	}
+
+	if len(candidates) == 0 {
+		return []string{}
+	}
+
+	taken := queryTakenNames(ctx, client, candidates)
+	suggestions := []string{}
+
+	for _, candidate := range candidates {
+		if len(suggestions) == cMaxNameSuggestions {
+			break
+		}
+
+		if !taken[strings.ToLower(candidate)] {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+
+	return suggestions
+}
+
+// nameAvailabilityResponse tells whether a name is available, with a few
+// available alternatives when it's taken.
+type nameAvailabilityResponse struct {
+	Code        ErrorCode `json:"code"`
+	Available   bool      `json:"available"`
+	Suggestions []string  `json:"suggestions,omitempty"`
+}
+
+// getNameAvailabilityResponse tells whether macroName can be used for a new
+// macro. The code is the one Add would return for the name, taken names also
+// get suggestions. Names of a namespace can only be checked by its members.
+func getNameAvailabilityResponse(
+	ctx context.Context,
+	client *bigquery.Client,
+	macroName, token string,
+) *nameAvailabilityResponse {
+	errCode := validateMacroReference(macroName)
+
+	if errCode == Success {
+		namespace, _ := splitMacroReference(macroName)
+		errCode = checkNamespaceAccess(ctx, token, namespace)
+	}
+
+	if errCode != Success {
+		return &nameAvailabilityResponse{Code: errCode}
+	}
+
+	macroName = macroReference(splitMacroReference(macroName))
+
+	if taken := queryTakenNames(ctx, client, []string{macroName}); taken[strings.ToLower(macroName)] {
+		return &nameAvailabilityResponse{
+			Code:        NameAlreadyExist,
+			Suggestions: suggestAvailableNames(ctx, client, macroName),
+		}
+	}
+
+	return &nameAvailabilityResponse{Code: Success, Available: true}
+}
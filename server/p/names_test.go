@@ -0,0 +1,118 @@
+package p
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateMacroName(t *testing.T) {
+	setTestEnv(t, cBlockedNamesEnv, "nsfw, spam")
+
+	tests := []struct {
+		name string
+		want ErrorCode
+	}{
+		{"lgtm", Success},
+		{"LGTM_2", Success},
+		{"ship-it", Success},
+		{"", EmptyName},
+		{"ship it", NameContainsSpaces},
+		{"ship\tit", NameContainsSpaces},
+		{"ship\nit", NameContainsSpaces},
+		{"$lgtm", NameContainsInvalidCharacters},
+		{"-lgtm", NameContainsInvalidCharacters},
+		{"lgtm😀", NameContainsInvalidCharacters},
+		{"a", NameIsTooShort},
+		{strings.Repeat("a", cMaxNameLength), Success},
+		{strings.Repeat("a", cMaxNameLength+1), NameIsTooLong},
+		{"Admin", NameIsReserved},
+		{"SPAM", NameIsReserved},
+		{"spammer", Success},
+	}
+
+	for _, test := range tests {
+		if got := validateMacroName(test.name); got != test.want {
+			t.Errorf("validateMacroName(%q) = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStaticNameAndURLValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want ErrorCode
+	}{
+		{"lgtm", "https://example.com/lgtm.gif", Success},
+		{"acme/lgtm", "https://example.com/lgtm.gif", Success},
+		{"", "https://example.com/lgtm.gif", EmptyName},
+		{"lgtm", "", EmptyURL},
+		{"-acme/lgtm", "https://example.com/lgtm.gif", InvalidNamespace},
+		{"acme/l", "https://example.com/lgtm.gif", NameIsTooShort},
+	}
+
+	for _, test := range tests {
+		if got := staticNameAndURLValidation(test.name, test.url); got != test.want {
+			t.Errorf("staticNameAndURLValidation(%q, %q) = %d, want %d", test.name, test.url, got, test.want)
+		}
+	}
+}
+
+func TestNameSuggestionCandidates(t *testing.T) {
+	setTestEnv(t, cNameSynonymsEnv, "cat,meow")
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"lgtm2", []string{"lgtm", "approved", "shipit", "lgtm3", "lgtm4"}},
+		{"lgtm-cat", []string{"approved-cat", "shipit-cat", "lgtm-kitty", "lgtm-meow", "lgtm-cat2"}},
+		{"Dog", []string{"doggo", "puppy", "Dog2", "Dog3"}},
+		{"xyz", []string{"xyz2", "xyz3"}},
+	}
+
+	for _, test := range tests {
+		got := nameSuggestionCandidates(test.name)
+		if len(got) < len(test.want) || !reflect.DeepEqual(got[:len(test.want)], test.want) {
+			t.Errorf("nameSuggestionCandidates(%q) = %v, want it to start with %v", test.name, got, test.want)
+		}
+	}
+
+	long := strings.Repeat("a", cMaxNameLength)
+	for _, candidate := range nameSuggestionCandidates(long) {
+		if validateMacroName(candidate) != Success || strings.EqualFold(candidate, long) {
+			t.Errorf("nameSuggestionCandidates(%q) suggested %q", long, candidate)
+		}
+	}
+}
+
+func TestGetNameAvailabilityResponse(t *testing.T) {
+	useFakeBigQuery(t)
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name string
+		want *nameAvailabilityResponse
+	}{
+		{cMissingValue, &nameAvailabilityResponse{Code: Success, Available: true}},
+		// the fake BigQuery finds lgtm taken and every other name available
+		{"lgtm", &nameAvailabilityResponse{Code: NameAlreadyExist, Suggestions: []string{"approved", "shipit", "lgtm2"}}},
+		{"a", &nameAvailabilityResponse{Code: NameIsTooShort}},
+		{"acme/lgtm", &nameAvailabilityResponse{Code: AuthenticationRequired}},
+	}
+
+	for _, test := range tests {
+		if got := getNameAvailabilityResponse(ctx, client, test.name, ""); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("getNameAvailabilityResponse(%q) = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
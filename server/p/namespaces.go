@@ -0,0 +1,94 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+)
+
+const cNamespaceSeparator = "/"
+
+// cVisibleMacrosFilter restricts a query to the public macros and the macros
+// of the namespaces in @namespaces.
+const cVisibleMacrosFilter = `(IFNULL(namespace, '') = '' OR namespace IN UNNEST(@namespaces))`
+
+// Namespaces are GitHub organization logins.
+var validNamespacePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,38}$`)
+
+// splitMacroReference splits an "org/name" reference to the lower cased
+// namespace and the name. Public macros have an empty namespace.
+func splitMacroReference(reference string) (namespace, name string) {
+	parts := strings.SplitN(reference, cNamespaceSeparator, 2)
+	if len(parts) == 1 {
+		return "", reference
+	}
+
+	return strings.ToLower(parts[0]), parts[1]
+}
+
+// macroReference is the name a macro is stored and referenced by. Macros of
+// a namespace are prefixed by it, so they never collide with public macros.
+func macroReference(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + cNamespaceSeparator + name
+}
+
+// validateMacroReference validates both parts of an "org/name" reference.
+func validateMacroReference(reference string) ErrorCode {
+	namespace, name := splitMacroReference(reference)
+
+	if strings.Contains(reference, cNamespaceSeparator) && !validNamespacePattern.MatchString(namespace) {
+		return InvalidNamespace
+	}
+
+	return validateMacroName(name)
+}
+
+// getCallerNamespaces returns the namespaces whose macros the caller may see,
+// callers without a valid token only see public macros.
+func getCallerNamespaces(ctx context.Context, token string) []string {
+	if token == "" {
+		return []string{}
+	}
+
+	orgs, err := getCallerOrgs(ctx, token)
+	if err != nil {
+		log.Printf("failed to get the caller organizations: %v", err)
+		return []string{}
+	}
+
+	return orgs
+}
+
+// checkNamespaceAccess verifies that the owner of token is a member of the
+// namespace organization. Public macros are accessible to everyone, the
+// macros of a namespace need a valid token.
+func checkNamespaceAccess(ctx context.Context, token, namespace string) ErrorCode {
+	if namespace == "" {
+		return Success
+	}
+
+	orgs, err := getCallerOrgs(ctx, token)
+
+	switch {
+	case errors.Is(err, errMissingToken), errors.Is(err, errInvalidToken):
+		return AuthenticationRequired
+	case err != nil && isTimeoutOrCanceled(err):
+		return TransientError
+	case err != nil:
+		log.Panicf("failed to get the caller organizations: %v", err)
+	}
+
+	for _, org := range orgs {
+		if org == namespace {
+			return Success
+		}
+	}
+
+	return NamespaceAccessDenied
+}
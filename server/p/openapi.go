@@ -0,0 +1,378 @@
+package p
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	cOpenAPIPath         = "/openapi.json"
+	cOpenAPIVersion      = "3.0.3"
+	cOpenAPISchemaPrefix = "#/components/schemas/"
+	cOpenAPIServerURL    = "https://us-central1-github-macros.cloudfunctions.net/api"
+	cErrorCodeSchemaName = "ErrorCode"
+)
+
+// openAPIParameter is a path or query parameter of an operation.
+type openAPIParameter struct {
+	Name        string
+	In          string
+	Description string
+	Type        string
+}
+
+// openAPIOperation describes a route of the v1 API. Request and the values of
+// Responses are zero values of the types the handler decodes and encodes, the
+// document is generated from them so it can't drift from the handlers.
+// ErrorStatus are answered with a code response, PlainTextStatus with the
+// plain text body of the router, for unknown sub-resources and methods.
+// Cacheable responses carry an ETag and are answered with 304 when the
+// If-None-Match header matches it.
+type openAPIOperation struct {
+	Method          string
+	Path            string
+	Summary         string
+	Parameters      []openAPIParameter
+	Request         interface{}
+	Responses       map[int]interface{}
+	ErrorStatus     []int
+	PlainTextStatus []int
+	Cacheable       bool
+}
+
+var nameParameter = openAPIParameter{
+	Name:        "name",
+	In:          "path",
+	Description: "name of the macro, prefixed by its organization for organization macros (acme/lgtm)",
+	Type:        "string",
+}
+
+var apiOperations = []openAPIOperation{
+	{
+		Method:  http.MethodGet,
+		Path:    "/v1/macros",
+		Summary: "Search macros by name, or list the suggestions without q",
+		Parameters: []openAPIParameter{
+			{Name: "q", In: "query", Description: "text the names contain", Type: "string"},
+			{Name: "cursor", In: "query", Description: "next_cursor of the previous page", Type: "string"},
+			{Name: "page", In: "query", Description: "page of the results, starting at 0, when there is no cursor", Type: "integer"},
+			{Name: "limit", In: "query", Description: "macros per page, 20 by default and capped by the server", Type: "integer"},
+			{Name: "collection", In: "query", Description: "only the macros of this collection", Type: "string"},
+		},
+		Responses:       map[int]interface{}{http.StatusOK: macroListResponse{}},
+		ErrorStatus:     []int{http.StatusBadRequest},
+		PlainTextStatus: []int{http.StatusMethodNotAllowed},
+		Cacheable:       true,
+	},
+	{
+		Method:          http.MethodGet,
+		Path:            "/v1/macros/{name}",
+		Summary:         "Get a macro",
+		Parameters:      []openAPIParameter{nameParameter},
+		Responses:       map[int]interface{}{http.StatusOK: macroResponse{}},
+		ErrorStatus:     []int{http.StatusNotFound},
+		PlainTextStatus: []int{http.StatusMethodNotAllowed},
+		Cacheable:       true,
+	},
+	{
+		Method:    http.MethodPost,
+		Path:      "/v1/macros",
+		Summary:   "Queue a job adding a macro",
+		Request:   addMacroRequest{},
+		Responses: map[int]interface{}{http.StatusAccepted: addJobResponse{}},
+		ErrorStatus: []int{
+			http.StatusBadRequest,
+			http.StatusUnauthorized,
+			http.StatusForbidden,
+			http.StatusConflict,
+			http.StatusServiceUnavailable,
+		},
+		PlainTextStatus: []int{http.StatusMethodNotAllowed},
+	},
+	{
+		Method:  http.MethodGet,
+		Path:    "/v1/jobs/{id}",
+		Summary: "Get the progress of an add job, and its outcome once it's done",
+		Parameters: []openAPIParameter{
+			{Name: "id", In: "path", Description: "job_id of the add response", Type: "string"},
+		},
+		Responses:       map[int]interface{}{http.StatusOK: addStatusResponse{}},
+		ErrorStatus:     []int{http.StatusNotFound},
+		PlainTextStatus: []int{http.StatusNotFound, http.StatusMethodNotAllowed},
+	},
+	{
+		Method:          http.MethodPost,
+		Path:            "/v1/macros/{name}/usages",
+		Summary:         "Count a usage of a macro",
+		Parameters:      []openAPIParameter{nameParameter},
+		Request:         recordUsageRequest{},
+		Responses:       map[int]interface{}{http.StatusCreated: codeResponse{}},
+		ErrorStatus:     []int{http.StatusBadRequest},
+		PlainTextStatus: []int{http.StatusNotFound, http.StatusMethodNotAllowed},
+	},
+	{
+		Method:          http.MethodPost,
+		Path:            "/v1/macros/{name}/reports",
+		Summary:         "Report that the image of a macro is broken",
+		Parameters:      []openAPIParameter{nameParameter},
+		Responses:       map[int]interface{}{http.StatusCreated: codeResponse{}},
+		ErrorStatus:     []int{http.StatusNotFound},
+		PlainTextStatus: []int{http.StatusNotFound, http.StatusMethodNotAllowed},
+	},
+}
+
+// openAPISchemas builds the component schemas of the Go types, keyed by
+// their schema name.
+type openAPISchemas map[string]interface{}
+
+// schemaName returns the name of the component schema of t: MacroRow is
+// Macro and macroListResponse is MacroListResponse.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimSuffix(t.Name(), "Row")
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+
+	return string(runes)
+}
+
+// jsonFieldName returns the JSON name of the field and whether it's omitted
+// when empty, or an empty name when it isn't encoded.
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+
+	if name == "" {
+		name = field.Name
+	}
+
+	omitEmpty := false
+
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			omitEmpty = true
+		}
+	}
+
+	return name, omitEmpty
+}
+
+// addStructProperties adds the encoded fields of t to properties, inlining
+// embedded structs like encoding/json does.
+func (schemas openAPISchemas) addStructProperties(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.Anonymous && field.Tag.Get("json") == "" && field.Type.Kind() == reflect.Struct {
+			schemas.addStructProperties(field.Type, properties, required)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name, omitEmpty := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		if name == "code" {
+			properties[name] = map[string]interface{}{"$ref": cOpenAPISchemaPrefix + cErrorCodeSchemaName}
+		} else {
+			properties[name] = schemas.schemaOf(field.Type)
+		}
+
+		if !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaOf returns the schema of t, structs are added as component schemas
+// and referenced.
+func (schemas openAPISchemas) schemaOf(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemas.schemaOf(t.Elem())
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": schemas.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemas.schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		name := schemaName(t)
+
+		if _, ok := schemas[name]; !ok {
+			// registered before its fields, so recursive types terminate
+			schemas[name] = nil
+
+			properties := map[string]interface{}{}
+			required := []string{}
+			schemas.addStructProperties(t, properties, &required)
+
+			schema := map[string]interface{}{"type": "object", "properties": properties}
+			if len(required) > 0 {
+				sort.Strings(required)
+				schema["required"] = required
+			}
+
+			schemas[name] = schema
+		}
+
+		return map[string]interface{}{"$ref": cOpenAPISchemaPrefix + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// addResponseContent adds the content of status to responses, so a status
+// answered both with JSON and with plain text lists both media types.
+func addResponseContent(responses map[string]interface{}, status int, mediaType string, media interface{}) {
+	response, ok := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !ok {
+		response = map[string]interface{}{
+			"description": http.StatusText(status),
+			"content":     map[string]interface{}{},
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+
+	response["content"].(map[string]interface{})[mediaType] = media
+}
+
+// getOpenAPIDocument generates the OpenAPI document of the v1 API.
+func getOpenAPIDocument() map[string]interface{} {
+	schemas := openAPISchemas{
+		cErrorCodeSchemaName: map[string]interface{}{
+			"type":        "integer",
+			"description": "0 on success, the error code of the request otherwise",
+		},
+	}
+
+	paths := map[string]interface{}{}
+
+	for _, operation := range apiOperations {
+		parameters := []interface{}{}
+
+		for _, parameter := range operation.Parameters {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        parameter.Name,
+				"in":          parameter.In,
+				"description": parameter.Description,
+				"required":    parameter.In == "path",
+				"schema":      map[string]interface{}{"type": parameter.Type},
+			})
+		}
+
+		responses := map[string]interface{}{}
+
+		for status, response := range operation.Responses {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content":     jsonContent(schemas.schemaOf(reflect.TypeOf(response))),
+			}
+		}
+
+		for _, status := range operation.ErrorStatus {
+			addResponseContent(responses, status, "application/json", map[string]interface{}{
+				"schema": schemas.schemaOf(reflect.TypeOf(codeResponse{})),
+			})
+		}
+
+		for _, status := range operation.PlainTextStatus {
+			addResponseContent(responses, status, "text/plain", map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		if operation.Cacheable {
+			responses[strconv.Itoa(http.StatusNotModified)] = map[string]interface{}{
+				"description": "The ETag of the response matches If-None-Match",
+			}
+		}
+
+		operationObject := map[string]interface{}{
+			"summary":    operation.Summary,
+			"parameters": parameters,
+			"responses":  responses,
+		}
+
+		if operation.Request != nil {
+			operationObject["requestBody"] = map[string]interface{}{
+				"content": jsonContent(schemas.schemaOf(reflect.TypeOf(operation.Request))),
+			}
+		}
+
+		pathItem, ok := paths[operation.Path].(map[string]interface{})
+		if !ok {
+			pathItem = map[string]interface{}{}
+			paths[operation.Path] = pathItem
+		}
+
+		pathItem[strings.ToLower(operation.Method)] = operationObject
+	}
+
+	return map[string]interface{}{
+		"openapi": cOpenAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "Github Macros API",
+			"version": "1",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": cOpenAPIServerURL},
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+var (
+	openAPIDocumentOnce sync.Once
+	openAPIDocument     []byte
+)
+
+// serveOpenAPIDocument writes the OpenAPI document, generated once per
+// instance.
+func serveOpenAPIDocument(w http.ResponseWriter) {
+	openAPIDocumentOnce.Do(func() {
+		document, err := json.Marshal(getOpenAPIDocument())
+		if err != nil {
+			log.Panicf("failed to create the OpenAPI document: %v", err)
+		}
+
+		openAPIDocument = document
+	})
+
+	w.Header().Set("Content-Type", cAPIJSONContentType)
+
+	if _, err := w.Write(openAPIDocument); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,506 @@
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+)
+
+// cMissingValue is the parameter value the fake BigQuery finds no rows for.
+const cMissingValue = "missing"
+
+// fakeBigQuery serves the BigQuery REST calls the client makes for a query.
+// Every query finds a single macro, unless one of its parameters is
+// cMissingValue. Queries wait for hold to be closed when it's set.
+type fakeBigQuery struct {
+	mu      sync.Mutex
+	jobs    map[string]*bq.Job
+	queries int
+	hold    chan struct{}
+}
+
+// queryCount returns the number of queries run so far.
+func (fake *fakeBigQuery) queryCount() int {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	return fake.queries
+}
+
+// fakeMacroSchema has the columns of MacroRow and the reports count, so the
+// row loads into every struct the queries of the API read.
+func fakeMacroSchema(t *testing.T) (*bq.TableSchema, *bq.TableRow) {
+	schema, err := bigquery.InferSchema(MacroRow{})
+	if err != nil {
+		t.Fatalf("failed to infer the macro schema: %v", err)
+	}
+
+	schema = append(schema, &bigquery.FieldSchema{Name: "reports", Type: bigquery.IntegerFieldType})
+
+	tableSchema := &bq.TableSchema{}
+	row := &bq.TableRow{}
+
+	for _, field := range schema {
+		tableSchema.Fields = append(tableSchema.Fields, &bq.TableFieldSchema{Name: field.Name, Type: string(field.Type)})
+
+		var value interface{} = ""
+
+		switch {
+		case field.Type == bigquery.IntegerFieldType:
+			value = "1"
+		case strings.EqualFold(field.Name, "name"):
+			value = "lgtm"
+		case strings.EqualFold(field.Name, "url"), field.Name == "github_url":
+			value = "https://example.com/lgtm.gif"
+		}
+
+		row.F = append(row.F, &bq.TableCell{V: value})
+	}
+
+	return tableSchema, row
+}
+
+func hasMissingParameter(job *bq.Job) bool {
+	if job.Configuration == nil || job.Configuration.Query == nil {
+		return false
+	}
+
+	for _, parameter := range job.Configuration.Query.QueryParameters {
+		if parameter.ParameterValue == nil {
+			continue
+		}
+
+		if parameter.ParameterValue.Value == cMissingValue {
+			return true
+		}
+
+		for _, value := range parameter.ParameterValue.ArrayValues {
+			if value.Value == cMissingValue {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func newFakeBigQuery(t *testing.T, fake *fakeBigQuery) *httptest.Server {
+	schema, row := fakeMacroSchema(t)
+
+	writeResponse := func(w http.ResponseWriter, response interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Errorf("failed to write fake BigQuery response: %v", err)
+		}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+		switch {
+		case r.Method == http.MethodPost && parts[len(parts)-1] == "jobs":
+			var job bq.Job
+			if err := json.NewDecoder(r.Body).Decode(&job); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			fake.mu.Lock()
+			fake.queries++
+			hold := fake.hold
+			fake.mu.Unlock()
+
+			if hold != nil {
+				<-hold
+			}
+
+			job.Status = &bq.JobStatus{State: "DONE"}
+			job.Statistics = &bq.JobStatistics{Query: &bq.JobStatistics2{NumDmlAffectedRows: 1}}
+
+			fake.mu.Lock()
+			fake.jobs[job.JobReference.JobId] = &job
+			fake.mu.Unlock()
+
+			writeResponse(w, &job)
+		case r.Method == http.MethodGet && len(parts) >= 2 && (parts[len(parts)-2] == "jobs" || parts[len(parts)-2] == "queries"):
+			fake.mu.Lock()
+			job, ok := fake.jobs[parts[len(parts)-1]]
+			fake.mu.Unlock()
+
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+
+			if parts[len(parts)-2] == "jobs" {
+				writeResponse(w, job)
+				return
+			}
+
+			response := &bq.GetQueryResultsResponse{JobComplete: true, JobReference: job.JobReference, Schema: schema}
+			if !hasMissingParameter(job) && r.URL.Query().Get("maxResults") != "0" {
+				response.Rows = []*bq.TableRow{row}
+				response.TotalRows = 1
+			}
+
+			writeResponse(w, response)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+// useFakeBigQuery points the BigQuery clients of the package at a fake for
+// the duration of the test.
+func useFakeBigQuery(t *testing.T) *fakeBigQuery {
+	fake := &fakeBigQuery{jobs: map[string]*bq.Job{}}
+	server := newFakeBigQuery(t, fake)
+	previous := newBigQueryClient
+
+	newBigQueryClient = func(ctx context.Context) (*bigquery.Client, error) {
+		return bigquery.NewClient(
+			ctx,
+			"github-macros",
+			option.WithEndpoint(server.URL+"/"),
+			option.WithoutAuthentication(),
+		)
+	}
+
+	t.Cleanup(func() {
+		newBigQueryClient = previous
+		server.Close()
+	})
+
+	return fake
+}
+
+// openAPIValidator checks the responses of the API against the generated
+// OpenAPI document.
+type openAPIValidator struct {
+	document map[string]interface{}
+	used     map[string]bool
+}
+
+func newOpenAPIValidator(t *testing.T) *openAPIValidator {
+	encoded, err := json.Marshal(getOpenAPIDocument())
+	if err != nil {
+		t.Fatalf("failed to encode the OpenAPI document: %v", err)
+	}
+
+	validator := &openAPIValidator{used: map[string]bool{}}
+	if err := json.Unmarshal(encoded, &validator.document); err != nil {
+		t.Fatalf("failed to decode the OpenAPI document: %v", err)
+	}
+
+	return validator
+}
+
+func (v *openAPIValidator) paths() map[string]interface{} {
+	return v.document["paths"].(map[string]interface{})
+}
+
+// findOperation returns the operation of the request, keyed by its method and
+// path template, or nil when the document has none.
+func (v *openAPIValidator) findOperation(method, path string) (string, map[string]interface{}) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for template, item := range v.paths() {
+		templateSegments := strings.Split(strings.Trim(template, "/"), "/")
+		if len(templateSegments) != len(segments) {
+			continue
+		}
+
+		matches := true
+
+		for i, segment := range templateSegments {
+			if !strings.HasPrefix(segment, "{") && segment != segments[i] {
+				matches = false
+				break
+			}
+		}
+
+		if !matches {
+			continue
+		}
+
+		if operation, ok := item.(map[string]interface{})[strings.ToLower(method)].(map[string]interface{}); ok {
+			return method + " " + template, operation
+		}
+	}
+
+	return "", nil
+}
+
+// resolve follows the $ref of a schema.
+func (v *openAPIValidator) resolve(schema map[string]interface{}) map[string]interface{} {
+	ref, ok := schema["$ref"].(string)
+	if !ok {
+		return schema
+	}
+
+	schemas := v.document["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	return schemas[strings.TrimPrefix(ref, cOpenAPISchemaPrefix)].(map[string]interface{})
+}
+
+// validateValue checks that value, decoded from JSON, matches schema.
+func (v *openAPIValidator) validateValue(schema map[string]interface{}, value interface{}, path string) error {
+	schema = v.resolve(schema)
+
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+
+		return fmt.Errorf("%s is null", path)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, want an object", path, value)
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+
+		for name, property := range object {
+			propertySchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				propertySchema = additional
+			}
+
+			if propertySchema == nil {
+				return fmt.Errorf("%s.%s isn't in the document", path, name)
+			}
+
+			if err := v.validateValue(propertySchema, property, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s is %T, want an array", path, value)
+		}
+
+		for i, item := range items {
+			if err := v.validateValue(schema["items"].(map[string]interface{}), item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s is %T, want a string", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s is %T, want a boolean", path, value)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != math.Trunc(number) {
+			return fmt.Errorf("%s is %v, want an integer", path, value)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%s is %T, want a number", path, value)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the response to the request against the document. Requests
+// without an operation must get the plain text 404 or 405 of the router.
+func (v *openAPIValidator) validate(method, target string, response *httptest.ResponseRecorder) error {
+	path := strings.SplitN(target, "?", 2)[0]
+	mediaType, _, _ := mime.ParseMediaType(response.Header().Get("Content-Type"))
+
+	key, operation := v.findOperation(method, path)
+	if operation == nil {
+		if mediaType == "text/plain" &&
+			(response.Code == http.StatusNotFound || response.Code == http.StatusMethodNotAllowed) {
+			return nil
+		}
+
+		return fmt.Errorf("%s %s is served with %d but has no operation", method, target, response.Code)
+	}
+
+	// the router answers unknown sub-resources of an operation in plain text
+	if mediaType != "text/plain" {
+		v.used[key] = true
+	}
+
+	declared, ok := operation["responses"].(map[string]interface{})[strconv.Itoa(response.Code)].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s %s: status %d isn't declared", method, target, response.Code)
+	}
+
+	content, _ := declared["content"].(map[string]interface{})
+	if len(content) == 0 {
+		if response.Body.Len() != 0 {
+			return fmt.Errorf("%s %s: status %d has a body but no content is declared", method, target, response.Code)
+		}
+
+		return nil
+	}
+
+	media, ok := content[mediaType].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s %s: %s isn't declared for status %d", method, target, mediaType, response.Code)
+	}
+
+	if mediaType != "application/json" {
+		return nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		return fmt.Errorf("%s %s: invalid JSON body: %v", method, target, err)
+	}
+
+	if err := v.validateValue(media["schema"].(map[string]interface{}), body, "body"); err != nil {
+		return fmt.Errorf("%s %s: %v", method, target, err)
+	}
+
+	return nil
+}
+
+func serveAPI(method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	response := httptest.NewRecorder()
+	API(response, req)
+
+	return response
+}
+
+func TestAPIMatchesOpenAPIDocument(t *testing.T) {
+	useFakeBigQuery(t)
+	setTestEnv(t, "FUNCTION_TARGET", "")
+	setTestEnv(t, cCacheBackendEnv, "none")
+
+	validator := newOpenAPIValidator(t)
+
+	send := func(method, target, body string, header http.Header, status int) *httptest.ResponseRecorder {
+		response := serveAPI(method, target, body, header)
+
+		if response.Code != status {
+			t.Errorf("%s %s = %d %q, want %d", method, target, response.Code, response.Body.String(), status)
+		}
+
+		if err := validator.validate(method, target, response); err != nil {
+			t.Error(err)
+		}
+
+		return response
+	}
+
+	list := send(http.MethodGet, "/v1/macros?q=lgtm&limit=5", "", nil, http.StatusOK)
+	send(http.MethodGet, "/v1/macros?q=lgtm&limit=5", "", http.Header{"If-None-Match": {list.Header().Get("ETag")}}, http.StatusNotModified)
+	send(http.MethodGet, "/v1/macros?page=-1", "", nil, http.StatusBadRequest)
+	send(http.MethodGet, "/v1/macros?cursor=garbage", "", nil, http.StatusBadRequest)
+
+	macro := send(http.MethodGet, "/v1/macros/lgtm", "", nil, http.StatusOK)
+	send(http.MethodGet, "/v1/macros/lgtm", "", http.Header{"If-None-Match": {macro.Header().Get("ETag")}}, http.StatusNotModified)
+	send(http.MethodGet, "/v1/macros/"+cMissingValue, "", nil, http.StatusNotFound)
+
+	added := send(http.MethodPost, "/v1/macros", `{"name":"new-macro","url":"https://example.com/lgtm.gif"}`, nil, http.StatusAccepted)
+	send(http.MethodPost, "/v1/macros", `{"name":"new-macro"`, nil, http.StatusBadRequest)
+	send(http.MethodPost, "/v1/macros", `{"name":"acme/new-macro","url":"https://example.com/lgtm.gif"}`, nil, http.StatusUnauthorized)
+
+	if err := WaitForPendingJobs(context.Background()); err != nil {
+		t.Fatalf("failed to wait for the add job: %v", err)
+	}
+
+	var job addJobResponse
+	if err := json.Unmarshal(added.Body.Bytes(), &job); err != nil {
+		t.Fatalf("invalid add response %q: %v", added.Body.String(), err)
+	}
+
+	send(http.MethodGet, "/v1/jobs/"+job.JobID, "", nil, http.StatusOK)
+	send(http.MethodGet, "/v1/jobs/"+cMissingValue, "", nil, http.StatusNotFound)
+
+	send(http.MethodPost, "/v1/macros/lgtm/usages", `{"trigger":"click"}`, nil, http.StatusCreated)
+	send(http.MethodPost, "/v1/macros/lgtm/usages", `{"trigger":"typed"}`, nil, http.StatusBadRequest)
+
+	send(http.MethodPost, "/v1/macros/lgtm/reports", "", nil, http.StatusCreated)
+	send(http.MethodPost, "/v1/macros/"+cMissingValue+"/reports", "", nil, http.StatusNotFound)
+
+	// requests outside of the operations must get the router responses
+	routerRequests := []struct {
+		method, target string
+		status         int
+	}{
+		{http.MethodGet, "/v1/collections", http.StatusNotFound},
+		{http.MethodGet, "/v2/macros", http.StatusNotFound},
+		{http.MethodGet, "/v1/jobs", http.StatusNotFound},
+		{http.MethodPost, "/v1/jobs/" + job.JobID, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/v1/jobs/" + job.JobID, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/v1/macros/lgtm/likes", http.StatusNotFound},
+		{http.MethodPost, "/v1/macros/lgtm", http.StatusNotFound},
+		{http.MethodDelete, "/v1/macros", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/v1/macros/lgtm", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/v1/macros/lgtm/usages", http.StatusMethodNotAllowed},
+	}
+
+	for _, request := range routerRequests {
+		send(request.method, request.target, "", nil, request.status)
+	}
+
+	// every operation of the document must have been served by a route
+	operations := []string{}
+
+	for template, item := range validator.paths() {
+		for method := range item.(map[string]interface{}) {
+			operations = append(operations, strings.ToUpper(method)+" "+template)
+		}
+	}
+
+	sort.Strings(operations)
+
+	for _, operation := range operations {
+		if !validator.used[operation] {
+			t.Errorf("%s has no route", operation)
+		}
+	}
+
+	// the router answers the other methods of every path with 405
+	for template, item := range validator.paths() {
+		for method, operation := range item.(map[string]interface{}) {
+			responses := operation.(map[string]interface{})["responses"].(map[string]interface{})
+			if _, ok := responses[strconv.Itoa(http.StatusMethodNotAllowed)]; !ok {
+				t.Errorf("%s %s doesn't declare %d", strings.ToUpper(method), template, http.StatusMethodNotAllowed)
+			}
+		}
+	}
+
+	if len(operations) != len(apiOperations) {
+		t.Errorf("document has %d operations, want %d", len(operations), len(apiOperations))
+	}
+}
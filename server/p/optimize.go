@@ -0,0 +1,328 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// images up to this size are downloaded to be optimized
+	cOptimizeMaxInputSize = 1024 * 1024 * 50
+	cOptimizeMaxAttempts  = 10
+	cOptimizeJPEGQuality  = 85
+	cOptimizeMinDimension = 64
+	// every attempt scales the image down by cOptimizeScaleNum/cOptimizeScaleDen
+	cOptimizeScaleNum = 3
+	cOptimizeScaleDen = 4
+	cMaxPaletteSize   = 256
+	cMinPaletteSize   = 64
+	cMaxFrameStep     = 4
+	// colors are bucketed by their 5 most significant bits per channel
+	cPaletteBucketBits = 5
+	cPaletteSampleStep = 4
+	cOpaqueThreshold   = 0x80
+	cJPEGContentType   = "image/jpeg"
+	// GIFs are decoded in full and their frames rendered on an RGBA canvas
+	// to be optimized, so they get a smaller budget than the stored images
+	cOptimizeMaxFramePixels     = 8 * 1000 * 1000
+	cOptimizeMaxAnimationPixels = 32 * 1000 * 1000
+)
+
+var errCannotOptimize = errors.New("image can't be optimized to fit the size limit")
+
+// optimizedMedia is the re-encoded media along with its file extension.
+type optimizedMedia struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+}
+
+// gifOptimizationParams are tuned on every attempt, trading quality for size.
+type gifOptimizationParams struct {
+	maxDimension int
+	paletteSize  int
+	frameStep    int
+}
+
+func (params *gifOptimizationParams) next() {
+	switch {
+	case params.paletteSize > cMinPaletteSize:
+		params.paletteSize /= 2
+	case params.frameStep < cMaxFrameStep:
+		params.frameStep *= 2
+	default:
+		params.maxDimension = params.maxDimension * cOptimizeScaleNum / cOptimizeScaleDen
+	}
+}
+
+// optimizeMedia re-encodes the image so it fits in maxSize. Still images are
+// re-encoded and scaled down, GIFs also get a smaller palette and fewer
+// frames. Formats we can't encode (SVG, AVIF, animated WebP and APNG) can't
+// be optimized. The input may be much larger than the stored images, so
+// decodeImage checks its pixel budget before decoding it, and GIFs are
+// checked against the smaller optimization budget first.
+func optimizeMedia(buf []byte, maxSize int) (*optimizedMedia, error) {
+	if detectMediaType(buf) == cMediaTypeGIF {
+		if err := checkGIFPixelBudget(buf, cOptimizeMaxFramePixels, cOptimizeMaxAnimationPixels); err != nil {
+			return nil, err
+		}
+	}
+
+	decoded, err := decodeImage(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case decoded.Animation != nil:
+		return optimizeGIF(decoded.Animation, maxSize)
+	case decoded.Still != nil && decoded.Frames == 1:
+		return optimizeStill(decoded.Still, detectMediaType(buf), maxSize)
+	}
+
+	return nil, errCannotOptimize
+}
+
+func maxDimension(bounds image.Rectangle) int {
+	if bounds.Dx() > bounds.Dy() {
+		return bounds.Dx()
+	}
+
+	return bounds.Dy()
+}
+
+// isOpaque reports whether the image has no transparent pixels.
+func isOpaque(img image.Image) bool {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return opaque.Opaque()
+	}
+
+	return false
+}
+
+func optimizeStill(img image.Image, mediaType string, maxSize int) (*optimizedMedia, error) {
+	// lossy images are re-encoded as JPEG, unless they have transparent
+	// pixels JPEG can't keep
+	isLossy := (mediaType == cMediaTypeJPEG || mediaType == cMediaTypeWebP) && isOpaque(img)
+	dimension := maxDimension(img.Bounds())
+
+	for attempt := 0; attempt < cOptimizeMaxAttempts && dimension >= cOptimizeMinDimension; attempt++ {
+		var (
+			buf bytes.Buffer
+			err error
+		)
+
+		resized := resizeImage(img, dimension)
+
+		if isLossy {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: cOptimizeJPEGQuality})
+		} else {
+			encoder := png.Encoder{CompressionLevel: png.BestCompression}
+			err = encoder.Encode(&buf, resized)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if buf.Len() <= maxSize {
+			if isLossy {
+				return &optimizedMedia{Data: buf.Bytes(), ContentType: cJPEGContentType, Extension: "jpg"}, nil
+			}
+
+			return &optimizedMedia{Data: buf.Bytes(), ContentType: cPNGContentType, Extension: "png"}, nil
+		}
+
+		dimension = dimension * cOptimizeScaleNum / cOptimizeScaleDen
+	}
+
+	return nil, errCannotOptimize
+}
+
+func optimizeGIF(g *gif.GIF, maxSize int) (*optimizedMedia, error) {
+	params := &gifOptimizationParams{
+		maxDimension: maxDimension(image.Rect(0, 0, g.Config.Width, g.Config.Height)),
+		paletteSize:  cMaxPaletteSize,
+		frameStep:    1,
+	}
+
+	for attempt := 0; attempt < cOptimizeMaxAttempts && params.maxDimension >= cOptimizeMinDimension; attempt++ {
+		data, err := encodeOptimizedGIF(g, params)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(data) <= maxSize {
+			return &optimizedMedia{Data: data, ContentType: cGIFContentType, Extension: "gif"}, nil
+		}
+
+		params.next()
+	}
+
+	return nil, errCannotOptimize
+}
+
+// renderKeptGIFFrames calls render with one of every frameStep frames,
+// scaled down as soon as it's rendered.
+func renderKeptGIFFrames(g *gif.GIF, params *gifOptimizationParams, render func(i int, frame *image.RGBA)) error {
+	return compositeGIFFrames(g, func(i int, frame *image.RGBA) {
+		if i%params.frameStep == 0 {
+			render(i, resizeImage(frame, params.maxDimension))
+		}
+	})
+}
+
+// encodeOptimizedGIF keeps one of every frameStep frames, adding the delays of
+// the dropped frames to the kept one so the animation keeps its pace. The
+// frames are rendered twice, once to pick the palette and once to map them to
+// it, so only a single RGBA frame is held at a time.
+func encodeOptimizedGIF(g *gif.GIF, params *gifOptimizationParams) ([]byte, error) {
+	counts := newPaletteCounts()
+
+	err := renderKeptGIFFrames(g, params, func(_ int, frame *image.RGBA) {
+		counts.add(frame)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	palette := counts.palette(params.paletteSize)
+	optimized := &gif.GIF{LoopCount: g.LoopCount}
+
+	err = renderKeptGIFFrames(g, params, func(i int, frame *image.RGBA) {
+		delay := 0
+
+		for j := i; j < i+params.frameStep && j < len(g.Image); j++ {
+			if j < len(g.Delay) {
+				delay += g.Delay[j]
+			}
+		}
+
+		paletted := image.NewPaletted(frame.Bounds(), palette)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, image.Point{})
+
+		optimized.Image = append(optimized.Image, paletted)
+		optimized.Delay = append(optimized.Delay, delay)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+
+	if err := gif.EncodeAll(&buf, optimized); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+const cPaletteBucketShift = 8 - cPaletteBucketBits
+
+// paletteCounts counts the colors of frames, after bucketing similar colors
+// together.
+type paletteCounts struct {
+	counts          map[uint32]int
+	hasTransparency bool
+}
+
+func newPaletteCounts() *paletteCounts {
+	return &paletteCounts{counts: map[uint32]int{}}
+}
+
+func (p *paletteCounts) add(frame *image.RGBA) {
+	const shift = cPaletteBucketShift
+
+	for offset := 0; offset+3 < len(frame.Pix); offset += 4 * cPaletteSampleStep {
+		if frame.Pix[offset+3] < cOpaqueThreshold {
+			p.hasTransparency = true
+			continue
+		}
+
+		key := uint32(frame.Pix[offset]>>shift)<<(2*cPaletteBucketBits) |
+			uint32(frame.Pix[offset+1]>>shift)<<cPaletteBucketBits |
+			uint32(frame.Pix[offset+2]>>shift)
+		p.counts[key]++
+	}
+}
+
+// palette picks the most frequent colors. A transparent entry is kept if any
+// pixel is transparent.
+func (p *paletteCounts) palette(size int) color.Palette {
+	const shift = cPaletteBucketShift
+
+	counts := p.counts
+
+	keys := make([]uint32, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+
+		return keys[i] < keys[j]
+	})
+
+	palette := color.Palette{}
+	if p.hasTransparency {
+		palette = append(palette, color.Transparent)
+	}
+
+	const mask = 1<<cPaletteBucketBits - 1
+
+	for _, key := range keys {
+		if len(palette) >= size {
+			break
+		}
+
+		palette = append(palette, color.RGBA{
+			R: uint8(key>>(2*cPaletteBucketBits)&mask)<<shift | 1<<(shift-1),
+			G: uint8(key>>cPaletteBucketBits&mask)<<shift | 1<<(shift-1),
+			B: uint8(key&mask)<<shift | 1<<(shift-1),
+			A: 0xff,
+		})
+	}
+
+	if len(palette) == 0 {
+		palette = append(palette, color.Black)
+	}
+
+	return palette
+}
+
+// optimizeOversizedMedia re-encodes an image that exceeds cFileMaxSize to
+// fit. It returns the re-encoded image along with the name of the media store
+// object it's hosted as.
+func optimizeOversizedMedia(fetched *fetchedMedia) (*fetchedMedia, string, ErrorCode) {
+	if getMediaStore() == nil {
+		return nil, "", FileIsTooBig
+	}
+
+	optimized, err := optimizeMedia(fetched.Data, cFileMaxSize)
+	if err != nil {
+		log.Printf("failed to optimize image: %v", err)
+		return nil, "", FileIsTooBig
+	}
+
+	digest := sha256Hex(optimized.Data)
+	objectName := fmt.Sprintf("optimized/%s.%s", digest, optimized.Extension)
+
+	return &fetchedMedia{
+		Data:      optimized.Data,
+		MediaType: optimized.ContentType,
+		SHA256:    digest,
+	}, objectName, Success
+}
@@ -0,0 +1,183 @@
+package p
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+// newNoisyGIF encodes an animation of random pixels, which compresses badly
+// so it can be made to exceed a size limit.
+func newNoisyGIF(t *testing.T, size int, delays []int) []byte {
+	t.Helper()
+
+	random := rand.New(rand.NewSource(1))
+	palette := color.Palette{}
+
+	for i := 0; i < cMaxPaletteSize; i++ {
+		palette = append(palette, color.RGBA{R: uint8(i), G: uint8(i * 7), B: uint8(i * 13), A: 0xff})
+	}
+
+	g := &gif.GIF{}
+
+	for _, delay := range delays {
+		frame := image.NewPaletted(image.Rect(0, 0, size, size), palette)
+		random.Read(frame.Pix)
+
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, delay)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("failed to encode test GIF: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestOptimizeGIF(t *testing.T) {
+	delays := []int{10, 10, 10, 10, 10, 10}
+	buf := newNoisyGIF(t, 200, delays)
+	maxSize := len(buf) / 4
+
+	optimized, err := optimizeMedia(buf, maxSize)
+	if err != nil {
+		t.Fatalf("optimizeMedia() = %v", err)
+	}
+
+	if len(optimized.Data) > maxSize || optimized.ContentType != cGIFContentType || optimized.Extension != "gif" {
+		t.Fatalf("optimizeMedia() = %d bytes of %s (.%s), want at most %d bytes of %s",
+			len(optimized.Data), optimized.ContentType, optimized.Extension, maxSize, cGIFContentType)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(optimized.Data))
+	if err != nil {
+		t.Fatalf("optimized GIF doesn't decode: %v", err)
+	}
+
+	duration := 0
+	for _, delay := range g.Delay {
+		duration += delay
+	}
+
+	if len(g.Image) == 0 || len(g.Image) > len(delays) || duration != 60 {
+		t.Errorf("optimized GIF has %d frames lasting %d, want at most %d frames lasting 60", len(g.Image), duration, len(delays))
+	}
+}
+
+func TestOptimizeGIFBudget(t *testing.T) {
+	buf := newTestGIF(t, 1, 1, image.Point{X: 3000, Y: 3000}, []int{1, 1}, 0)
+
+	if _, err := optimizeMedia(buf, cFileMaxSize); !errors.Is(err, errImageTooLarge) {
+		t.Errorf("optimizeMedia() of a GIF over the optimization budget = %v, want %v", err, errImageTooLarge)
+	}
+
+	// the animation is within the budget of stored images
+	if _, err := decodeImage(buf); err != nil {
+		t.Errorf("decodeImage() = %v", err)
+	}
+}
+
+func TestOptimizeStill(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	opaque := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	random.Read(opaque.Pix)
+
+	for i := 3; i < len(opaque.Pix); i += 4 {
+		opaque.Pix[i] = 0xff
+	}
+
+	var jpegBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, opaque, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("failed to encode test JPEG: %v", err)
+	}
+
+	transparent := newTestPNG(t, 300, 200)
+
+	tests := []struct {
+		name      string
+		buf       []byte
+		maxSize   int
+		extension string
+	}{
+		{"opaque jpeg", jpegBuf.Bytes(), jpegBuf.Len() / 2, "jpg"},
+		{"transparent png", transparent, len(transparent), "png"},
+	}
+
+	for _, test := range tests {
+		optimized, err := optimizeMedia(test.buf, test.maxSize)
+		if err != nil {
+			t.Errorf("%s: optimizeMedia() = %v", test.name, err)
+			continue
+		}
+
+		if optimized.Extension != test.extension || len(optimized.Data) > test.maxSize {
+			t.Errorf("%s: optimizeMedia() = %d bytes of .%s, want at most %d bytes of .%s",
+				test.name, len(optimized.Data), optimized.Extension, test.maxSize, test.extension)
+		}
+
+		if _, _, err := image.Decode(bytes.NewReader(optimized.Data)); err != nil {
+			t.Errorf("%s: optimized image doesn't decode: %v", test.name, err)
+		}
+	}
+
+	if _, err := optimizeMedia(jpegBuf.Bytes(), 10); !errors.Is(err, errCannotOptimize) {
+		t.Errorf("optimizeMedia() below any reachable size = %v, want %v", err, errCannotOptimize)
+	}
+}
+
+func TestPaletteCounts(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 8, 8))
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			switch {
+			case y == 0:
+				frame.Set(x, y, color.Transparent)
+			case x < 4:
+				frame.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+			default:
+				frame.Set(x, y, color.RGBA{B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	counts := newPaletteCounts()
+	counts.add(frame)
+
+	palette := counts.palette(cMaxPaletteSize)
+	if len(palette) != 3 || palette[0] != color.Transparent {
+		t.Errorf("palette() = %v, want a transparent entry and two colors", palette)
+	}
+
+	if palette := counts.palette(2); len(palette) != 2 {
+		t.Errorf("palette(2) = %v, want 2 entries", palette)
+	}
+}
+
+func TestGIFOptimizationParams(t *testing.T) {
+	params := &gifOptimizationParams{maxDimension: 400, paletteSize: cMaxPaletteSize, frameStep: 1}
+
+	want := []gifOptimizationParams{
+		{400, 128, 1},
+		{400, 64, 1},
+		{400, 64, 2},
+		{400, 64, 4},
+		{300, 64, 4},
+	}
+
+	for _, step := range want {
+		params.next()
+
+		if *params != step {
+			t.Fatalf("next() = %+v, want %+v", *params, step)
+		}
+	}
+}
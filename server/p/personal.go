@@ -0,0 +1,267 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	cMaxFavoriteMacros = 50
+	cMaxRecentMacros   = 20
+)
+
+// getAuthenticatedLogin returns the login of the caller, or the error code to
+// respond with when it can't be authenticated.
+func getAuthenticatedLogin(ctx context.Context, token string) (string, ErrorCode) {
+	login, err := getCallerLogin(ctx, token)
+
+	switch {
+	case err == nil:
+		return login, Success
+	case errors.Is(err, errMissingToken), errors.Is(err, errInvalidToken):
+		return "", AuthenticationRequired
+	case isTimeoutOrCanceled(err):
+		return "", TransientError
+	}
+
+	log.Panicf("failed to get the caller login: %v", err)
+
+	return "", InfraFailure
+}
+
+func isMacroVisible(ctx context.Context, client *bigquery.Client, macroName string, namespaces []string) bool {
+	query := client.Query(`
+		SELECT name
+		FROM github-macros.macros.macros
+		WHERE name=@name AND ` + cVisibleMacrosFilter + `
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "name",
+			Value: macroName,
+		},
+		{
+			Name:  "namespaces",
+			Value: namespaces,
+		},
+	}
+
+	return len(getQueryResults(ctx, query)) > 0
+}
+
+func starMacro(ctx context.Context, client *bigquery.Client, login, macroName string) {
+	query := client.Query(`
+		MERGE github-macros.macros.favorites F
+		USING (SELECT @user_login AS user_login, @macro_name AS macro_name) S
+		ON F.user_login = S.user_login AND F.macro_name = S.macro_name
+		WHEN NOT MATCHED THEN
+			INSERT (user_login, macro_name, create_time)
+			VALUES (S.user_login, S.macro_name, CURRENT_TIMESTAMP())
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "macro_name",
+			Value: macroName,
+		},
+	}
+
+	if _, err := runQuery(ctx, query); err != nil {
+		log.Panicf("failed to star macro: %v", err)
+	}
+}
+
+func unstarMacro(ctx context.Context, client *bigquery.Client, login, macroName string) {
+	query := client.Query(`
+		DELETE FROM github-macros.macros.favorites
+		WHERE user_login=@user_login AND macro_name=@macro_name
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "macro_name",
+			Value: macroName,
+		},
+	}
+
+	if _, err := runQuery(ctx, query); err != nil {
+		log.Panicf("failed to unstar macro: %v", err)
+	}
+}
+
+// queryPersonalMacros returns the macros listed in table for the user, most
+// recent first. Macros the user can no longer see are skipped.
+func queryPersonalMacros(
+	ctx context.Context,
+	client *bigquery.Client,
+	table, timeColumn, login string,
+	namespaces []string,
+	limit int,
+) []*MacroRow {
+	query := client.Query(`
+		SELECT
+			name,
+			github_url AS url,
+			width,
+			height,
+			` + cMacroMediaColumns + `
+		FROM github-macros.macros.` + table + ` P
+		JOIN github-macros.macros.macros Macros
+		ON Macros.name = P.macro_name
+		WHERE P.user_login=@user_login AND ` + cVisibleMacrosFilter + `
+		ORDER BY P.` + timeColumn + ` DESC
+		LIMIT @limit
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "user_login",
+			Value: login,
+		},
+		{
+			Name:  "namespaces",
+			Value: namespaces,
+		},
+		{
+			Name:  "limit",
+			Value: limit,
+		},
+	}
+
+	return getQueryResults(ctx, query)
+}
+
+// personalResponse lists the personal macros of the caller, Favorites holds
+// the names of the starred ones.
+type personalResponse struct {
+	macroListResponse
+	Favorites []string `json:"favorites"`
+}
+
+// getPersonalResponse returns the favorites of the caller followed by the
+// macros they recently used and the macros of the collections they subscribed
+// to. Favorites lists the names of the starred macros.
+func getPersonalResponse(ctx context.Context, client *bigquery.Client, token string) (string, error) {
+	response := &personalResponse{
+		macroListResponse: macroListResponse{Data: []*MacroRow{}},
+		Favorites:         []string{},
+	}
+
+	login, errCode := getAuthenticatedLogin(ctx, token)
+	if errCode != Success {
+		response.Code = errCode
+	} else {
+		namespaces := getCallerNamespaces(ctx, token)
+		favorites := queryPersonalMacros(ctx, client, "favorites", "create_time", login, namespaces, cMaxFavoriteMacros)
+		recent := queryPersonalMacros(ctx, client, "recent_usages", "use_time", login, namespaces, cMaxRecentMacros)
+		subscribed := querySubscribedMacros(ctx, client, login, namespaces)
+
+		rows := []*MacroRow{}
+		favoriteNames := []string{}
+		seen := map[string]bool{}
+
+		for _, row := range favorites {
+			rows = append(rows, row)
+			favoriteNames = append(favoriteNames, row.Name)
+			seen[row.Name] = true
+		}
+
+		for _, row := range append(recent, subscribed...) {
+			if !seen[row.Name] {
+				rows = append(rows, row)
+				seen[row.Name] = true
+			}
+		}
+
+		response.Code = Success
+		response.Data = rows
+		response.Favorites = favoriteNames
+	}
+
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling results: %v", err)
+	}
+
+	return string(responseBytes), nil
+}
+
+func executeFavoriteChange(ctx context.Context, r *http.Request, star bool) ErrorCode {
+	macroName := r.Form.Get("name")
+	if macroName == "" {
+		return EmptyName
+	}
+
+	token := getCallerToken(r)
+
+	login, errCode := getAuthenticatedLogin(ctx, token)
+	if errCode != Success {
+		return errCode
+	}
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		log.Panicf("failed to create bigquery client: %v", err)
+	}
+	defer client.Close()
+
+	macroName = macroReference(splitMacroReference(macroName))
+
+	if !star {
+		unstarMacro(ctx, client, login, macroName)
+		return Success
+	}
+
+	if !isMacroVisible(ctx, client, macroName, getCallerNamespaces(ctx, token)) {
+		return MacroNotFound
+	}
+
+	starMacro(ctx, client, login, macroName)
+
+	return Success
+}
+
+func handleFavoriteChange(w http.ResponseWriter, r *http.Request, star bool) {
+	if allowCORS(w, r) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Panicf("failed to parse form: %v", err)
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	response, err := json.Marshal(&codeResponse{Code: executeFavoriteChange(ctx, r, star)})
+	if err != nil {
+		log.Panicf("failed to create response: %v", err)
+	}
+
+	if _, err = fmt.Fprint(w, string(response)); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
+
+// Star adds the macro to the favorites of the caller, identified by their
+// GitHub token.
+func Star(w http.ResponseWriter, r *http.Request) {
+	handleFavoriteChange(w, r, true)
+}
+
+// Unstar removes the macro from the favorites of the caller.
+func Unstar(w http.ResponseWriter, r *http.Request) {
+	handleFavoriteChange(w, r, false)
+}
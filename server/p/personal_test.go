@@ -0,0 +1,189 @@
+package p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheTestCaller makes token authenticate as login, a member of orgs,
+// without calling the GitHub API.
+func cacheTestCaller(t *testing.T, token, login string, orgs []string) {
+	t.Helper()
+
+	digest := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(digest[:])
+	expires := time.Now().Add(time.Hour)
+
+	callerCache.Lock()
+	callerCache.entries["login:"+hash] = &callerCacheEntry{value: login, expires: expires}
+	callerCache.entries["orgs:"+hash] = &callerCacheEntry{value: orgs, expires: expires}
+	callerCache.Unlock()
+
+	t.Cleanup(func() {
+		callerCache.Lock()
+		delete(callerCache.entries, "login:"+hash)
+		delete(callerCache.entries, "orgs:"+hash)
+		callerCache.Unlock()
+	})
+}
+
+func TestParseAuthorization(t *testing.T) {
+	tests := map[string]string{
+		"token abc":    "abc",
+		"Bearer abc ":  "abc",
+		"Basic abc":    "",
+		"abc":          "",
+		"":             "",
+		"tokenabc":     "",
+		"token  abc  ": "abc",
+	}
+
+	for authorization, want := range tests {
+		if got := parseAuthorization(authorization); got != want {
+			t.Errorf("parseAuthorization(%q) = %q, want %q", authorization, got, want)
+		}
+	}
+}
+
+func TestGetNextPageURL(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{
+			`<https://api.github.com/user/orgs?page=2>; rel="next", <https://api.github.com/user/orgs?page=5>; rel="last"`,
+			"https://api.github.com/user/orgs?page=2",
+		},
+		{`<https://api.github.com/user/orgs?page=1>; rel="prev"`, ""},
+		{`<https://evil.example/user/orgs?page=2>; rel="next"`, ""},
+		{`<http://api.github.com/user/orgs?page=2>; rel="next"`, ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := getNextPageURL(test.link); got != test.want {
+			t.Errorf("getNextPageURL(%q) = %q, want %q", test.link, got, test.want)
+		}
+	}
+}
+
+func TestGetCachedCallerValue(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+
+	fetch := func(ctx context.Context, token string) (interface{}, error) {
+		calls++
+
+		if token == "invalid" {
+			return nil, errInvalidToken
+		}
+
+		return "octocat", nil
+	}
+
+	if _, err := getCachedCallerValue(ctx, "", "test", fetch); !errors.Is(err, errMissingToken) {
+		t.Errorf("getCachedCallerValue() without a token = %v, want %v", err, errMissingToken)
+	}
+
+	for i := 0; i < 2; i++ {
+		if value, err := getCachedCallerValue(ctx, "cached-token", "test", fetch); value != "octocat" || err != nil {
+			t.Errorf("getCachedCallerValue() = %v, %v, want octocat", value, err)
+		}
+	}
+
+	// failures aren't cached
+	for i := 0; i < 2; i++ {
+		if _, err := getCachedCallerValue(ctx, "invalid", "test", fetch); !errors.Is(err, errInvalidToken) {
+			t.Errorf("getCachedCallerValue() = %v, want %v", err, errInvalidToken)
+		}
+	}
+
+	if calls != 3 {
+		t.Errorf("fetch was called %d times, want 3", calls)
+	}
+}
+
+func TestGetPersonalResponse(t *testing.T) {
+	useFakeBigQuery(t)
+	cacheTestCaller(t, "personal-token", "octocat", []string{})
+
+	ctx := context.Background()
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	var response personalResponse
+
+	encoded, err := getPersonalResponse(ctx, client, "")
+	if err != nil {
+		t.Fatalf("getPersonalResponse() = %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(encoded), &response); err != nil || response.Code != AuthenticationRequired {
+		t.Errorf("getPersonalResponse() without a token = %s, want code %d", encoded, AuthenticationRequired)
+	}
+
+	encoded, err = getPersonalResponse(ctx, client, "personal-token")
+	if err != nil {
+		t.Fatalf("getPersonalResponse() = %v", err)
+	}
+
+	response = personalResponse{}
+	if err := json.Unmarshal([]byte(encoded), &response); err != nil {
+		t.Fatalf("invalid response %s: %v", encoded, err)
+	}
+
+	// the fake BigQuery returns lgtm as a favorite, a recent usage and a
+	// subscribed macro, it's only listed once
+	if response.Code != Success || len(response.Data) != 1 || !reflect.DeepEqual(response.Favorites, []string{"lgtm"}) {
+		t.Errorf("getPersonalResponse() = %s, want lgtm listed once as a favorite", encoded)
+	}
+}
+
+func TestExecuteFavoriteChange(t *testing.T) {
+	useFakeBigQuery(t)
+	cacheTestCaller(t, "favorite-token", "octocat", []string{})
+
+	tests := []struct {
+		name  string
+		token string
+		star  bool
+		want  ErrorCode
+	}{
+		{"lgtm", "favorite-token", true, Success},
+		{"lgtm", "favorite-token", false, Success},
+		{cMissingValue, "favorite-token", true, MacroNotFound},
+		{"", "favorite-token", true, EmptyName},
+		{"lgtm", "", true, AuthenticationRequired},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/star", strings.NewReader(url.Values{"name": {test.name}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		if test.token != "" {
+			r.Header.Set("Authorization", "token "+test.token)
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("failed to parse form: %v", err)
+		}
+
+		if got := executeFavoriteChange(context.Background(), r, test.star); got != test.want {
+			t.Errorf("executeFavoriteChange(%q, star %v) = %d, want %d", test.name, test.star, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"google.golang.org/api/pubsub/v1"
+)
+
+// pubSubPushRequest is the body of a Pub/Sub push subscription request.
+type pubSubPushRequest struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+var (
+	pubSubServiceOnce sync.Once
+	pubSubService     *pubsub.Service
+	errPubSubService  error
+)
+
+// getPubSubService returns the Pub/Sub client shared by the requests of the
+// instance.
+func getPubSubService() (*pubsub.Service, error) {
+	pubSubServiceOnce.Do(func() {
+		if pubSubService, errPubSubService = pubsub.NewService(context.Background()); errPubSubService != nil {
+			errPubSubService = fmt.Errorf("pubsub.NewService: %v", errPubSubService)
+		}
+	})
+
+	return pubSubService, errPubSubService
+}
+
+// publishMessage publishes message, encoded as JSON, to the Pub/Sub topic.
+func publishMessage(ctx context.Context, topic string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	service, err := getPubSubService()
+	if err != nil {
+		return err
+	}
+
+	_, err = service.Projects.Topics.Publish(
+		fmt.Sprintf("projects/github-macros/topics/%s", topic),
+		&pubsub.PublishRequest{
+			Messages: []*pubsub.PubsubMessage{
+				{Data: base64.StdEncoding.EncodeToString(payload)},
+			},
+		},
+	).Context(ctx).Do()
+
+	return err
+}
+
+// decodePushMessage decodes the JSON message of a push subscription request
+// into message, returning the ID Pub/Sub assigned it.
+func decodePushMessage(r *http.Request, message interface{}) (string, error) {
+	var pushRequest pubSubPushRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&pushRequest); err != nil {
+		return "", err
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(pushRequest.Message.Data)
+	if err != nil {
+		return pushRequest.Message.MessageID, err
+	}
+
+	return pushRequest.Message.MessageID, json.Unmarshal(payload, message)
+}
+
+// writeWorkerAck acknowledges the push message.
+func writeWorkerAck(w http.ResponseWriter) {
+	if _, err := fmt.Fprint(w, "OK"); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
@@ -4,6 +4,7 @@ package p
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -15,6 +16,10 @@ import (
 const queryTypeSearch = "search"
 const queryTypeGet = "get"
 const queryTypeSuggestion = "suggestion"
+const queryTypeAvailable = "available"
+const queryTypePersonal = "personal"
+const queryTypeCollections = "collections"
+const queryTypeAutocomplete = "autocomplete"
 const resultsPerPage = 20
 
 func getPage(r *http.Request) int {
@@ -27,15 +32,113 @@ func getPage(r *http.Request) int {
 	return page
 }
 
-func getQuery(r *http.Request, client *bigquery.Client) (*bigquery.Query, error) {
-	queryText := r.URL.Query().Get("text")
-	page := getPage(r)
+// cCursorFilter keeps the macros sorted after the cursor, it matches every
+// macro when there is no cursor. It must follow the ORDER BY of the queries.
+const cCursorFilter = `(@cursor_name = '' OR ` + cUsagesColumn + ` > @cursor_usages OR (` +
+	cUsagesColumn + ` = @cursor_usages AND Macros.name < @cursor_name))`
 
-	offset := page * resultsPerPage
+// macroQuery is a query for macros: a search of Text, a get of the macro
+// called Text or the suggestions. Pages of Limit macros are selected either by
+// Cursor, the next_cursor of the previous page, or by their Page number.
+type macroQuery struct {
+	Type   string
+	Text   string
+	Page   int
+	Limit  int
+	Cursor string
+}
+
+// macroQueryResult holds a page of the results, HasMore is set when there
+// are more pages, get queries always have a single page. NextCursor selects
+// the next page.
+type macroQueryResult struct {
+	Rows       []*MacroRow
+	HasMore    bool
+	NextCursor string
+}
+
+// queryScope limits the macros a query returns: Namespaces are the private
+// namespaces the caller may see, Collection optionally restricts the results
+// to the macros of a collection published or owned by Login.
+type queryScope struct {
+	Namespaces []string
+	Collection string
+	Login      string
+}
+
+func getQueryScope(ctx context.Context, collection, token string) *queryScope {
+	scope := &queryScope{
+		Namespaces: getCallerNamespaces(ctx, token),
+		Collection: collection,
+	}
+
+	// the login is only needed to show unpublished collections to their owner
+	if scope.Collection != "" && token != "" {
+		login, err := getCallerLogin(ctx, token)
+		if err != nil {
+			log.Printf("failed to get the caller login: %v", err)
+		}
+
+		scope.Login = login
+	}
+
+	return scope
+}
+
+// getListQueryParameters returns the parameters the search and suggestion
+// queries share: the scope, the page and the cursor.
+func getListQueryParameters(scope *queryScope, pageSize, offset int, cursor *pageCursor) []bigquery.QueryParameter {
+	return []bigquery.QueryParameter{
+		{
+			Name:  "namespaces",
+			Value: scope.Namespaces,
+		},
+		{
+			Name:  "collection",
+			Value: scope.Collection,
+		},
+		{
+			Name:  "user_login",
+			Value: scope.Login,
+		},
+		{
+			Name:  "limit",
+			Value: pageSize + 1,
+		},
+		{
+			Name:  "offset",
+			Value: offset,
+		},
+		{
+			Name:  "cursor_usages",
+			Value: cursor.Usages,
+		},
+		{
+			Name:  "cursor_name",
+			Value: cursor.Name,
+		},
+	}
+}
+
+// getQuery builds the query, limited to the macros in scope.
+func getQuery(
+	client *bigquery.Client,
+	macroQuery *macroQuery,
+	scope *queryScope,
+	cursor *pageCursor,
+) (*bigquery.Query, error) {
+	queryText := macroQuery.Text
+	pageSize := getPageSize(macroQuery.Limit)
+	offset := macroQuery.Page * pageSize
+
+	// the cursor replaces the page number
+	if cursor.Name != "" {
+		offset = 0
+	}
 
 	var query *bigquery.Query
 
-	switch r.URL.Query().Get("type") {
+	switch macroQuery.Type {
 	case queryTypeSearch:
 		log.Printf("search: %s, offset: %v", queryText, offset)
 		query = client.Query(`
@@ -44,36 +147,41 @@ func getQuery(r *http.Request, client *bigquery.Client) (*bigquery.Query, error)
 				github_url AS url,
 				width,
 				height,
-				(CASE WHEN Usages.clicks is NULL THEN 0 ELSE Usages.clicks END) + (CASE WHEN Usages.directs is NULL THEN 0 ELSE Usages.directs END) as usages,
+				` + cMacroMediaColumns + `,
+				` + cUsagesColumn + ` AS usages,
 			FROM github-macros.macros.macros Macros
 			LEFT JOIN github-macros.macros.usages Usages
 			ON Macros.name = Usages.macro_name
-			WHERE name LIKE @name
+			WHERE name LIKE @name AND ` + cVisibleMacrosFilter + ` AND ` + cCollectionMacrosFilter + `
+				AND ` + cCursorFilter + `
 			ORDER BY usages, Macros.name DESC 
 			LIMIT @limit
 			OFFSET @offset
 		`)
-		query.Parameters = []bigquery.QueryParameter{
-			{
-				Name:  "name",
-				Value: "%" + queryText + "%",
-			},
-			{
-				Name:  "limit",
-				Value: resultsPerPage + 1,
-			},
-			{
-				Name:  "offset",
-				Value: offset,
-			},
-		}
+		query.Parameters = append(
+			[]bigquery.QueryParameter{{Name: "name", Value: "%" + queryText + "%"}},
+			getListQueryParameters(scope, pageSize, offset, cursor)...,
+		)
 	case queryTypeGet:
 		log.Printf("get: %s", queryText)
-		query = client.Query("SELECT name, github_url AS url, width, height FROM `github-macros.macros.macros` WHERE name=@name")
+		query = client.Query(`
+			SELECT
+				name,
+				github_url AS url,
+				width,
+				height,
+				` + cMacroMediaColumns + `
+			FROM github-macros.macros.macros
+			WHERE name=@name AND ` + cVisibleMacrosFilter + `
+		`)
 		query.Parameters = []bigquery.QueryParameter{
 			{
 				Name:  "name",
-				Value: queryText,
+				Value: macroReference(splitMacroReference(queryText)),
+			},
+			{
+				Name:  "namespaces",
+				Value: scope.Namespaces,
 			},
 		}
 	case "", queryTypeSuggestion:
@@ -84,68 +192,121 @@ func getQuery(r *http.Request, client *bigquery.Client) (*bigquery.Query, error)
 				github_url AS url,
 				width,
 				height,
-				(CASE WHEN Usages.clicks is NULL THEN 0 ELSE Usages.clicks END) + (CASE WHEN Usages.directs is NULL THEN 0 ELSE Usages.directs END) as usages
+				` + cMacroMediaColumns + `,
+				` + cUsagesColumn + ` AS usages
 			FROM github-macros.macros.macros Macros
 			LEFT JOIN github-macros.macros.usages Usages
 			ON Macros.name = Usages.macro_name
+			WHERE ` + cVisibleMacrosFilter + ` AND ` + cCollectionMacrosFilter + `
+				AND ` + cCursorFilter + `
 			ORDER BY usages, Macros.name DESC
 			LIMIT @limit
 			OFFSET @offset
 		`)
-		query.Parameters = []bigquery.QueryParameter{
-			{
-				Name:  "limit",
-				Value: resultsPerPage + 1,
-			},
-			{
-				Name:  "offset",
-				Value: offset,
-			},
-		}
+		query.Parameters = getListQueryParameters(scope, pageSize, offset, cursor)
 	default:
-		return nil, fmt.Errorf("unknown query type: %s", r.URL.Query().Get("type"))
+		return nil, fmt.Errorf("unknown query type: %s", macroQuery.Type)
 	}
 
 	return query, nil
 }
 
-func execQuery(r *http.Request) (string, error) {
-	ctx := context.Background()
+// queryMacros runs the query and returns its page of results, from the cache
+// when it holds them. It fails with errInvalidCursor when the cursor wasn't
+// issued for the same query.
+func queryMacros(
+	ctx context.Context,
+	client *bigquery.Client,
+	macroQuery *macroQuery,
+	scope *queryScope,
+) (*macroQueryResult, error) {
+	fingerprint := getQueryFingerprint(macroQuery, scope.Collection)
+	cursor := &pageCursor{Query: fingerprint}
 
-	client, err := bigquery.NewClient(ctx, "github-macros")
-	if err != nil {
-		return "", fmt.Errorf("bigquery.NewClient: %v", err)
+	if macroQuery.Cursor != "" {
+		var err error
+		if cursor, err = decodeCursor(macroQuery.Cursor, fingerprint); err != nil {
+			return nil, err
+		}
+	}
+
+	cacheKey, generation, cacheable := "", "", false
+
+	if isCacheableQuery(macroQuery.Type) {
+		cacheKey = getQueryCacheKey(macroQuery, scope)
+
+		if cached := getCachedQueryResult(ctx, cacheKey); cached != nil {
+			return cached, nil
+		}
+
+		// read before the query runs, so results computed before a purge aren't
+		// cached as the results of the generation following it
+		generation, cacheable = getCacheGeneration(ctx)
 	}
-	defer client.Close()
 
-	query, err := getQuery(r, client)
+	query, err := getQuery(client, macroQuery, scope, cursor)
 	if err != nil {
-		return "", fmt.Errorf("getQuery: %v", err)
+		return nil, fmt.Errorf("getQuery: %v", err)
 	}
 
-	rows := getQueryResults(query)
+	rows := getQueryResults(ctx, query)
+	pageSize := getPageSize(macroQuery.Limit)
 
-	hasMore := len(rows) > resultsPerPage
+	hasMore := len(rows) > pageSize && macroQuery.Type != queryTypeGet
 
 	// remove the extra item we fetched just to verify if we have more
+	if len(rows) > pageSize {
+		rows = rows[:pageSize]
+	}
+
+	result := &macroQueryResult{Rows: rows, HasMore: hasMore}
+
 	if hasMore {
-		rows = rows[:resultsPerPage]
+		last := rows[len(rows)-1]
+		result.NextCursor = encodeCursor(&pageCursor{Usages: last.Usages, Name: last.Name, Query: fingerprint})
 	}
 
-	isQueryGet := r.URL.Query().Get("type") == queryTypeGet
+	// a macro that isn't found may be added in a moment, and the purge may
+	// not reach every cache
+	if cacheable && (macroQuery.Type != queryTypeGet || len(rows) > 0) {
+		setCachedQueryResult(ctx, cacheKey, generation, result, getCacheTTL(macroQuery.Type))
+	}
 
-	responseMap := map[string]interface{}{
-		"data": rows,
+	return result, nil
+}
+
+// getMacroListResponse builds the response of a page of macros. The next page
+// number is only set for queries paged by number, the next cursor always is
+// unless cursors are disabled.
+func getMacroListResponse(macroQuery *macroQuery, result *macroQueryResult) *macroListResponse {
+	response := &macroListResponse{
+		Code:       Success,
+		Data:       result.Rows,
+		HasMore:    result.HasMore,
+		NextCursor: result.NextCursor,
 	}
 
-	if !isQueryGet {
-		responseMap["has_more"] = hasMore
-		if hasMore {
-			responseMap["next_page"] = getPage(r) + 1
-		}
+	if result.HasMore && macroQuery.Cursor == "" {
+		response.NextPage = macroQuery.Page + 1
 	}
 
-	response, err := json.Marshal(responseMap)
+	return response
+}
+
+// getAutocompleteResponse returns the most used macros whose name starts
+// with prefix, from the in-memory index.
+func getAutocompleteResponse(ctx context.Context, prefix, token string, limit int) (string, error) {
+	if limit <= 0 {
+		limit = cDefaultAutocompleteResults
+	}
+
+	if maxPageSize := getMaxPageSize(); limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	rows := macroAutocompleteIndex.lookup(ctx, prefix, getCallerNamespaces(ctx, token), limit)
+
+	response, err := json.Marshal(&macroListResponse{Code: Success, Data: rows})
 	if err != nil {
 		return "", fmt.Errorf("error marshaling results: %v", err)
 	}
@@ -153,18 +314,98 @@ func execQuery(r *http.Request) (string, error) {
 	return string(response), nil
 }
 
+// getLimit returns the limit parameter, 0 when it's not set or invalid so
+// the default page size is used.
+func getLimit(r *http.Request) int {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		return 0
+	}
+
+	return limit
+}
+
+func execQuery(ctx context.Context, r *http.Request) (string, error) {
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return "", fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	token := getCallerToken(r)
+
+	if r.URL.Query().Get("type") == queryTypeAvailable {
+		response, err := json.Marshal(getNameAvailabilityResponse(ctx, client, r.URL.Query().Get("text"), token))
+		if err != nil {
+			return "", fmt.Errorf("error marshaling results: %v", err)
+		}
+
+		return string(response), nil
+	}
+
+	if r.URL.Query().Get("type") == queryTypePersonal {
+		return getPersonalResponse(ctx, client, token)
+	}
+
+	if r.URL.Query().Get("type") == queryTypeCollections {
+		return getCollectionsResponse(ctx, client, token, r.URL.Query().Get("text"))
+	}
+
+	if r.URL.Query().Get("type") == queryTypeAutocomplete {
+		return getAutocompleteResponse(ctx, r.URL.Query().Get("text"), token, getLimit(r))
+	}
+
+	macroQuery := &macroQuery{
+		Type:   r.URL.Query().Get("type"),
+		Text:   r.URL.Query().Get("text"),
+		Page:   getPage(r),
+		Limit:  getLimit(r),
+		Cursor: r.URL.Query().Get("cursor"),
+	}
+
+	var response interface{}
+
+	result, err := queryMacros(ctx, client, macroQuery, getQueryScope(ctx, r.URL.Query().Get("collection"), token))
+
+	switch {
+	case errors.Is(err, errInvalidCursor):
+		response = &codeResponse{Code: InvalidRequest}
+	case err != nil:
+		return "", err
+	default:
+		response = getMacroListResponse(macroQuery, result)
+	}
+
+	responseBytes, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling results: %v", err)
+	}
+
+	return string(responseBytes), nil
+}
+
 func Query(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if allowCORS(w, r) {
+		return
+	}
 
-	response, err := execQuery(r)
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	response, err := execQuery(ctx, r)
 
 	if err != nil {
-		log.Fatalf("error executing query: %v", err)
+		log.Panicf("error executing query: %v", err)
+	}
+
+	if queryType := r.URL.Query().Get("type"); isCacheableQuery(queryType) {
+		writeCacheableResponse(w, r, queryType, http.StatusOK, []byte(response))
+		return
 	}
 
 	_, err = fmt.Fprint(w, response)
 
 	if err != nil {
-		log.Fatalf("error writing response: %v", err)
+		log.Panicf("error writing response: %v", err)
 	}
 }
@@ -0,0 +1,171 @@
+package p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cCacheTTLEnv         = "CACHE_TTL"
+	cDefaultSearchTTL    = time.Minute
+	cDefaultSuggestTTL   = 5 * time.Minute
+	cDefaultGetTTL       = 10 * time.Minute
+	cCacheKeyPrefix      = "query:"
+	cCacheKeyPartDivider = "\x00"
+)
+
+// getCacheTTL returns how long the results of a query type are kept.
+// CACHE_TTL overrides the defaults of all the types.
+func getCacheTTL(queryType string) time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv(cCacheTTLEnv)); err == nil && ttl >= 0 {
+		return ttl
+	}
+
+	switch queryType {
+	case queryTypeGet:
+		return cDefaultGetTTL
+	case queryTypeSearch:
+		return cDefaultSearchTTL
+	default:
+		return cDefaultSuggestTTL
+	}
+}
+
+// getQueryCacheKey identifies the results of a query in scope. Callers that
+// see the same private namespaces share the cached results.
+func getQueryCacheKey(macroQuery *macroQuery, scope *queryScope) string {
+	namespaces := append([]string{}, scope.Namespaces...)
+	sort.Strings(namespaces)
+
+	parts := []string{
+		macroQuery.Type,
+		macroQuery.Text,
+		strconv.Itoa(macroQuery.Page),
+		strconv.Itoa(getPageSize(macroQuery.Limit)),
+		macroQuery.Cursor,
+		scope.Collection,
+		scope.Login,
+		strings.Join(namespaces, ","),
+	}
+
+	digest := sha256.Sum256([]byte(strings.Join(parts, cCacheKeyPartDivider)))
+
+	return cCacheKeyPrefix + hex.EncodeToString(digest[:])
+}
+
+// cachedQueryResult is the cached form of macroQueryResult.
+type cachedQueryResult struct {
+	Rows       []*MacroRow `json:"rows"`
+	HasMore    bool        `json:"has_more"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+func getCachedQueryResult(ctx context.Context, key string) *macroQueryResult {
+	value, found, err := getQueryCache().Get(ctx, key)
+	if err != nil {
+		log.Printf("failed to read cached results: %v", err)
+		return nil
+	}
+
+	if !found {
+		return nil
+	}
+
+	var cached cachedQueryResult
+	if err := json.Unmarshal(value, &cached); err != nil {
+		log.Printf("failed to decode cached results: %v", err)
+		return nil
+	}
+
+	return &macroQueryResult{Rows: cached.Rows, HasMore: cached.HasMore, NextCursor: cached.NextCursor}
+}
+
+// getCacheGeneration returns the generation of the cache, or false when it
+// can't be read and the results shouldn't be cached.
+func getCacheGeneration(ctx context.Context) (string, bool) {
+	generation, err := getQueryCache().Generation(ctx)
+	if err != nil {
+		log.Printf("failed to read the cache generation: %v", err)
+		return "", false
+	}
+
+	return generation, true
+}
+
+// setCachedQueryResult caches the result of a query that started at
+// generation, it's dropped when the cache was purged since.
+func setCachedQueryResult(ctx context.Context, key, generation string, result *macroQueryResult, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	value, err := json.Marshal(&cachedQueryResult{Rows: result.Rows, HasMore: result.HasMore, NextCursor: result.NextCursor})
+	if err != nil {
+		log.Printf("failed to encode results: %v", err)
+		return
+	}
+
+	if err := getQueryCache().Set(ctx, key, value, ttl, generation); err != nil {
+		log.Printf("failed to cache results: %v", err)
+	}
+}
+
+// isCacheableQuery tells whether the results of a query type are cached.
+func isCacheableQuery(queryType string) bool {
+	switch queryType {
+	case "", queryTypeSuggestion, queryTypeSearch, queryTypeGet:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeCacheableResponse writes body with the caching headers of a query
+// type, or 304 Not Modified when it matches the ETag the client has.
+// Responses depending on the caller token are only cached by the client.
+func writeCacheableResponse(w http.ResponseWriter, r *http.Request, queryType string, status int, body []byte) {
+	digest := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(digest[:16]) + `"`
+
+	visibility := "public"
+	if getCallerToken(r) != "" {
+		visibility = "private"
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, int(getCacheTTL(queryType).Seconds())))
+	w.Header().Set("Vary", "Authorization")
+
+	if status == http.StatusOK && matchesETag(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
+
+// matchesETag tells whether the If-None-Match header lists etag.
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -8,8 +8,12 @@ import (
 	"google.golang.org/api/iterator"
 )
 
-func getQueryResults(query *bigquery.Query) []*MacroRow {
-	ctx := context.Background()
+// cUsagesColumn sums the clicks and direct usages of a macro, which the
+// search and suggestion results are sorted by.
+const cUsagesColumn = `(CASE WHEN Usages.clicks is NULL THEN 0 ELSE Usages.clicks END) + ` +
+	`(CASE WHEN Usages.directs is NULL THEN 0 ELSE Usages.directs END)`
+
+func getQueryResults(ctx context.Context, query *bigquery.Query) []*MacroRow {
 	iter, err := runQuery(ctx, query)
 
 	if err != nil {
@@ -0,0 +1,253 @@
+package p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cRedisAddrEnv       = "REDIS_ADDR"
+	cRedisPasswordEnv   = "REDIS_PASSWORD"
+	cDefaultRedisAddr   = "localhost:6379"
+	cRedisPoolSize      = 8
+	cRedisGenerationKey = "ghm:generation"
+	cRedisKeyPrefix     = "ghm:"
+)
+
+var errRedisProtocol = errors.New("unexpected redis reply")
+
+// redisError is an error reply of the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisCache keeps the entries in a Redis compatible server, shared by all
+// the instances. Keys are prefixed by a generation number, purging
+// increments it so the previous entries are no longer read and expire.
+type redisCache struct {
+	addr     string
+	password string
+	conns    chan *redisConn
+}
+
+// redisConn is a connection speaking the RESP protocol.
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisCache(addr, password string) *redisCache {
+	if addr == "" {
+		addr = cDefaultRedisAddr
+	}
+
+	return &redisCache{addr: addr, password: password, conns: make(chan *redisConn, cRedisPoolSize)}
+}
+
+func (c *redisCache) dial(ctx context.Context) (*redisConn, error) {
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	redis := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	if c.password != "" {
+		if _, err := redis.do(ctx, "AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return redis, nil
+}
+
+// do sends a command over a pooled connection and returns its reply.
+// Connections are only returned to the pool after a complete reply.
+func (c *redisCache) do(ctx context.Context, args ...string) (interface{}, error) {
+	var conn *redisConn
+
+	select {
+	case conn = <-c.conns:
+	default:
+		var err error
+		if conn, err = c.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := conn.do(ctx, args...)
+
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.conns <- conn:
+	default:
+		conn.conn.Close()
+	}
+
+	return reply, err
+}
+
+func (conn *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(stageStorage.timeout())
+	}
+
+	if err := conn.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var command strings.Builder
+
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := io.WriteString(conn.conn, command.String()); err != nil {
+		return nil, err
+	}
+
+	return conn.readReply()
+}
+
+// readReply reads a reply: strings and bulk strings are returned as strings,
+// integers as int64, a nil bulk string as nil and arrays as []interface{}.
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errRedisProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+
+		if length < 0 {
+			return nil, nil
+		}
+
+		value := make([]byte, length+2)
+		if _, err := io.ReadFull(conn.reader, value); err != nil {
+			return nil, err
+		}
+
+		return string(value[:length]), nil
+	case '*':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+
+		if length < 0 {
+			return nil, nil
+		}
+
+		items := make([]interface{}, 0, length)
+
+		for i := 0; i < length; i++ {
+			item, err := conn.readReply()
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+	default:
+		return nil, errRedisProtocol
+	}
+}
+
+func (c *redisCache) Generation(ctx context.Context) (string, error) {
+	reply, err := c.do(ctx, "GET", cRedisGenerationKey)
+	if err != nil {
+		return "", err
+	}
+
+	generation, _ := reply.(string)
+	if generation == "" {
+		generation = "0"
+	}
+
+	return generation, nil
+}
+
+// generationKey prefixes key by generation.
+func generationKey(generation, key string) string {
+	return cRedisKeyPrefix + generation + ":" + key
+}
+
+func (c *redisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	generation, err := c.Generation(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	reply, err := c.do(ctx, "GET", generationKey(generation, key))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if reply == nil {
+		return nil, false, nil
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return nil, false, errRedisProtocol
+	}
+
+	return []byte(value), true, nil
+}
+
+// Set stores value under generation rather than the current one, after a
+// purge it's never read and expires.
+func (c *redisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration, generation string) error {
+	if ttl <= 0 {
+		return nil
+	}
+
+	_, err := c.do(ctx, "SET", generationKey(generation, key), string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+
+	return err
+}
+
+func (c *redisCache) Purge(ctx context.Context) error {
+	_, err := c.do(ctx, "INCR", cRedisGenerationKey)
+
+	return err
+}
@@ -0,0 +1,338 @@
+package p
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory server speaking the subset of RESP the cache
+// uses: AUTH, GET, SET with PX and INCR.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu          sync.Mutex
+	values      map[string]string
+	expires     map[string]time.Time
+	connections int
+	gets        int
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	fake := &fakeRedis{
+		listener: listener,
+		password: password,
+		values:   map[string]string{},
+		expires:  map[string]time.Time{},
+	}
+
+	go fake.serve()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return fake
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+// getCount returns the number of GET commands executed so far.
+func (f *fakeRedis) getCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.gets
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		f.mu.Lock()
+		f.connections++
+		f.mu.Unlock()
+
+		go f.serveConn(conn)
+	}
+}
+
+// readCommand reads a command, sent as an array of bulk strings.
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	readLine := func(prefix byte) (int, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+
+		if line[0] != prefix {
+			return 0, fmt.Errorf("unexpected line %q", line)
+		}
+
+		return strconv.Atoi(strings.TrimSuffix(line[1:], "\r\n"))
+	}
+
+	count, err := readLine('*')
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+
+	for i := range args {
+		length, err := readLine('$')
+		if err != nil {
+			return nil, err
+		}
+
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+
+		args[i] = string(arg[:length])
+	}
+
+	return args, nil
+}
+
+func (f *fakeRedis) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		reply := f.execute(args, &authenticated)
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) execute(args []string, authenticated *bool) string {
+	command := strings.ToUpper(args[0])
+
+	if command == "AUTH" {
+		if len(args) != 2 || args[1] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+
+		*authenticated = true
+
+		return "+OK\r\n"
+	}
+
+	if !*authenticated {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case command == "GET" && len(args) == 2:
+		f.gets++
+
+		if expires, ok := f.expires[args[1]]; ok && time.Now().After(expires) {
+			delete(f.values, args[1])
+			delete(f.expires, args[1])
+		}
+
+		value, ok := f.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case command == "SET" && len(args) == 5 && strings.ToUpper(args[3]) == "PX":
+		milliseconds, err := strconv.Atoi(args[4])
+		if err != nil || milliseconds <= 0 {
+			return "-ERR invalid expire time in 'set' command\r\n"
+		}
+
+		f.values[args[1]] = args[2]
+		f.expires[args[1]] = time.Now().Add(time.Duration(milliseconds) * time.Millisecond)
+
+		return "+OK\r\n"
+	case command == "INCR" && len(args) == 2:
+		value, err := strconv.ParseInt(f.values[args[1]], 10, 64)
+		if err != nil && f.values[args[1]] != "" {
+			return "-ERR value is not an integer or out of range\r\n"
+		}
+
+		f.values[args[1]] = strconv.FormatInt(value+1, 10)
+
+		return fmt.Sprintf(":%d\r\n", value+1)
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+}
+
+func TestRedisCache(t *testing.T) {
+	fake := newFakeRedis(t, "")
+	cache := newRedisCache(fake.addr(), "")
+	ctx := context.Background()
+
+	if _, found, err := cache.Get(ctx, "a"); found || err != nil {
+		t.Fatalf("Get of a missing key = %v, %v, want not found", found, err)
+	}
+
+	value := "line\r\nwith binary \x00 data"
+	if err := cache.Set(ctx, "a", []byte(value), time.Minute, "0"); err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+
+	if cached, found, err := cache.Get(ctx, "a"); !found || err != nil || string(cached) != value {
+		t.Fatalf("Get = %q, %v, %v, want %q", cached, found, err, value)
+	}
+
+	if err := cache.Purge(ctx); err != nil {
+		t.Fatalf("Purge = %v", err)
+	}
+
+	if _, found, err := cache.Get(ctx, "a"); found || err != nil {
+		t.Errorf("Get after Purge = %v, %v, want not found", found, err)
+	}
+
+	// a value computed before the purge isn't served after it
+	if err := cache.Set(ctx, "a", []byte(value), time.Minute, "0"); err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+
+	if _, found, err := cache.Get(ctx, "a"); found || err != nil {
+		t.Errorf("Get of a value set as of the purged generation = %v, %v, want not found", found, err)
+	}
+
+	if err := cache.Set(ctx, "b", []byte("b"), time.Millisecond, "1"); err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	if _, found, err := cache.Get(ctx, "b"); found || err != nil {
+		t.Errorf("Get of an expired key = %v, %v, want not found", found, err)
+	}
+
+	fake.mu.Lock()
+	connections := fake.connections
+	fake.mu.Unlock()
+
+	if connections != 1 {
+		t.Errorf("sequential commands used %d connections, want 1", connections)
+	}
+}
+
+func TestRedisCachePurgeIsShared(t *testing.T) {
+	fake := newFakeRedis(t, "")
+	first := newRedisCache(fake.addr(), "")
+	second := newRedisCache(fake.addr(), "")
+	ctx := context.Background()
+
+	if err := first.Set(ctx, "a", []byte("a"), time.Minute, "0"); err != nil {
+		t.Fatalf("Set = %v", err)
+	}
+
+	if _, found, _ := second.Get(ctx, "a"); !found {
+		t.Fatalf("entry set by another instance not found")
+	}
+
+	if err := second.Purge(ctx); err != nil {
+		t.Fatalf("Purge = %v", err)
+	}
+
+	if _, found, _ := first.Get(ctx, "a"); found {
+		t.Errorf("entry found after another instance purged the cache")
+	}
+}
+
+func TestRedisCacheAuth(t *testing.T) {
+	fake := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	if err := newRedisCache(fake.addr(), "secret").Set(ctx, "a", []byte("a"), time.Minute, "0"); err != nil {
+		t.Errorf("Set with the password = %v", err)
+	}
+
+	var replyErr redisError
+
+	if err := newRedisCache(fake.addr(), "wrong").Set(ctx, "a", []byte("a"), time.Minute, "0"); !errors.As(err, &replyErr) {
+		t.Errorf("Set with a wrong password = %v, want a redis error", err)
+	}
+
+	if _, _, err := newRedisCache(fake.addr(), "").Get(ctx, "a"); !errors.As(err, &replyErr) {
+		t.Errorf("Get without the password = %v, want a redis error", err)
+	}
+}
+
+func TestRedisCacheUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	if _, _, err := newRedisCache(addr, "").Get(context.Background(), "a"); err == nil {
+		t.Errorf("Get from an unreachable server succeeded")
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		reply string
+		value interface{}
+		err   error
+	}{
+		{"+OK\r\n", "OK", nil},
+		{":42\r\n", int64(42), nil},
+		{"$5\r\nhello\r\n", "hello", nil},
+		{"$0\r\n\r\n", "", nil},
+		{"$-1\r\n", nil, nil},
+		{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{"a", int64(1)}, nil},
+		{"*-1\r\n", nil, nil},
+		{"-ERR wrong\r\n", nil, redisError("ERR wrong")},
+		{"\r\n", nil, errRedisProtocol},
+		{"?x\r\n", nil, errRedisProtocol},
+		{"$x\r\n", nil, errRedisProtocol},
+		{"$5\r\nhi\r\n", nil, io.ErrUnexpectedEOF},
+	}
+
+	for _, test := range tests {
+		conn := &redisConn{reader: bufio.NewReader(strings.NewReader(test.reply))}
+
+		value, err := conn.readReply()
+		if !errors.Is(err, test.err) {
+			t.Errorf("readReply(%q) = %v, want %v", test.reply, err, test.err)
+			continue
+		}
+
+		if err == nil && !reflect.DeepEqual(value, test.value) {
+			t.Errorf("readReply(%q) = %#v, want %#v", test.reply, value, test.value)
+		}
+	}
+}
@@ -8,11 +8,12 @@ import (
 	"net/http"
 
 	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
 )
 
 const cReportsThreshold = 50
 
-func createNewReportsEntryIfNotExist(client *bigquery.Client, macroName string) {
+func createNewReportsEntryIfNotExist(ctx context.Context, client *bigquery.Client, macroName string) {
 	query := client.Query(`
 		INSERT INTO github-macros.macros.reports (macro_name, reports)
 		SELECT @macro_name, 1 FROM (SELECT 1) 
@@ -27,12 +28,12 @@ func createNewReportsEntryIfNotExist(client *bigquery.Client, macroName string)
 		},
 	}
 
-	if _, err := runQuery(context.Background(), query); err != nil {
+	if _, err := runQuery(ctx, query); err != nil {
 		log.Panicf("failed to create reports entry: %v", err)
 	}
 }
 
-func incrementNumberOfReports(client *bigquery.Client, macroName string) {
+func incrementNumberOfReports(ctx context.Context, client *bigquery.Client, macroName string) {
 	query := client.Query("UPDATE `github-macros.macros.reports` SET reports = reports + 1 WHERE macro_name=@macro_name")
 	query.Parameters = []bigquery.QueryParameter{
 		{
@@ -41,17 +42,25 @@ func incrementNumberOfReports(client *bigquery.Client, macroName string) {
 		},
 	}
 
-	if _, err := runQuery(context.Background(), query); err != nil {
+	if _, err := runQuery(ctx, query); err != nil {
 		log.Panicf("incrementNumberOfReports: %v", err)
 	}
 }
 
-func revalidateMacro(client *bigquery.Client, macroName, macroURL string) {
-	_, err := getImageConfig(macroURL)
+func revalidateMacro(ctx context.Context, client *bigquery.Client, macroName, macroURL string) {
+	_, err := getDecodedImage(ctx, macroURL)
+
+	// the image may still be fine, it's revalidated by the next report
+	if isTimeoutOrCanceled(err) || isTransientFetchStatusError(err) {
+		log.Panicf("revalidateMacro: %v", err)
+	}
 
 	var query *bigquery.Query
 
-	if err != nil {
+	// broken images get the macro deleted
+	deleted := err != nil
+
+	if deleted {
 		query = client.Query(`
 			DELETE FROM github-macros.macros.macros WHERE name=@macro_name;
 			DELETE FROM github-macros.macros.reports WHERE macro_name=@macro_name;
@@ -68,12 +77,23 @@ func revalidateMacro(client *bigquery.Client, macroName, macroURL string) {
 		},
 	}
 
-	if _, err = runQuery(context.Background(), query); err != nil {
+	if _, err = runQuery(ctx, query); err != nil {
 		log.Panicf("revalidateMacro: %v", err)
 	}
+
+	if deleted {
+		invalidateQueryCache(ctx)
+		invalidateAutocompleteIndex()
+	}
 }
 
-func getURLAndReports(client *bigquery.Client, macroName string) (string, int64, bool) {
+// getURLAndReports returns the URL of the macro and its number of reports,
+// found is false when there's no such macro.
+func getURLAndReports(
+	ctx context.Context,
+	client *bigquery.Client,
+	macroName string,
+) (macroURL string, reports int64, hasReports, found bool) {
 	query := client.Query(`
 		SELECT M.url, R.reports FROM github-macros.macros.macros M
 		LEFT JOIN github-macros.macros.reports R 
@@ -87,7 +107,7 @@ func getURLAndReports(client *bigquery.Client, macroName string) (string, int64,
 		},
 	}
 
-	iter, err := runQuery(context.Background(), query)
+	iter, err := runQuery(ctx, query)
 	if err != nil {
 		log.Panicf("failed to increment number of reports: %v", err)
 	}
@@ -97,15 +117,41 @@ func getURLAndReports(client *bigquery.Client, macroName string) (string, int64,
 		Reports bigquery.NullInt64
 	}
 
-	if err = iter.Next(&row); err != nil {
+	err = iter.Next(&row)
+	if err == iterator.Done {
+		return "", 0, false, false
+	}
+
+	if err != nil {
 		log.Panicf("failed to read reports: %v", err)
 	}
 
-	return row.URL, row.Reports.Int64, row.Reports.Valid
+	return row.URL, row.Reports.Int64, row.Reports.Valid, true
+}
+
+// reportMacro counts a report of the macro, once it was reported enough
+// times its image is checked again and the macro is deleted if it's broken.
+func reportMacro(ctx context.Context, client *bigquery.Client, macroName string) ErrorCode {
+	macroURL, reports, hasReports, found := getURLAndReports(ctx, client, macroName)
+
+	switch {
+	case !found:
+		return MacroNotFound
+	case !hasReports:
+		createNewReportsEntryIfNotExist(ctx, client, macroName)
+	case reports < cReportsThreshold:
+		incrementNumberOfReports(ctx, client, macroName)
+	default:
+		revalidateMacro(ctx, client, macroName, macroURL)
+	}
+
+	return Success
 }
 
 func Report(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if allowCORS(w, r) {
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		log.Panicf("failed to parse form: %v", err)
@@ -116,21 +162,18 @@ func Report(w http.ResponseWriter, r *http.Request) {
 		log.Panicf("missing marco name")
 	}
 
-	ctx := context.Background()
-	client, err := bigquery.NewClient(ctx, "github-macros")
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	client, err := newBigQueryClient(ctx)
 
 	if err != nil {
 		log.Panicf("failed to create bigquery client: %v", err)
 	}
 	defer client.Close()
 
-	macroURL, reports, exist := getURLAndReports(client, macroName)
-	if !exist {
-		createNewReportsEntryIfNotExist(client, macroName)
-	} else if reports < cReportsThreshold {
-		incrementNumberOfReports(client, macroName)
-	} else {
-		revalidateMacro(client, macroName, macroURL)
+	if errCode := reportMacro(ctx, client, macroName); errCode != Success {
+		log.Printf("failed to report %s: %v", macroName, errCode)
 	}
 
 	_, err = fmt.Fprint(w, "OK")
@@ -0,0 +1,276 @@
+package p
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// svgRemovedElements can execute code or embed other documents, they are
+// removed along with their content.
+var svgRemovedElements = map[string]bool{
+	"script":        true,
+	"foreignobject": true,
+	"iframe":        true,
+	"object":        true,
+	"embed":         true,
+	"handler":       true,
+	"listener":      true,
+}
+
+var (
+	svgExternalURLPattern = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s)]`)
+	svgLengthPattern      = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+)\s*(px)?\s*$`)
+	svgListSeparator      = regexp.MustCompile(`[\s,]+`)
+)
+
+func isSVG(head []byte) bool {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	if len(trimmed) == 0 || trimmed[0] != '<' {
+		return false
+	}
+
+	return bytes.Contains(bytes.ToLower(trimmed), []byte("<svg"))
+}
+
+// isSafeSVGReference allows only same-document fragments and inline images.
+func isSafeSVGReference(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+
+	return ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:image/")
+}
+
+// isUnsafeSVGAttribute reports whether the attribute is an event handler or
+// references a script or an external resource.
+func isUnsafeSVGAttribute(attr *xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return true
+	case name == "href" && !isSafeSVGReference(attr.Value):
+		return true
+	case strings.Contains(strings.ToLower(attr.Value), "javascript:"):
+		return true
+	}
+
+	return svgExternalURLPattern.MatchString(attr.Value)
+}
+
+func isUnsafeSVGStyle(style []byte) bool {
+	return bytes.Contains(bytes.ToLower(style), []byte("@import")) || svgExternalURLPattern.Match(style)
+}
+
+func svgQualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// writeSVGStartElement writes the start tag of element, which was read with
+// its namespace prefixes untranslated.
+func writeSVGStartElement(out *bytes.Buffer, element *xml.StartElement, selfClosing bool) {
+	out.WriteString("<" + svgQualifiedName(element.Name))
+
+	for _, attr := range element.Attr {
+		out.WriteString(" " + svgQualifiedName(attr.Name) + `="`)
+		_ = xml.EscapeText(out, []byte(attr.Value))
+		out.WriteString(`"`)
+	}
+
+	if selfClosing {
+		out.WriteString("/>")
+	} else {
+		out.WriteString(">")
+	}
+}
+
+// parseSVGLength parses a unitless or pixel length. Relative units can't be
+// resolved without a viewport, so they are reported as missing.
+func parseSVGLength(value string) int64 {
+	match := svgLengthPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+
+	length, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0
+	}
+
+	return int64(length + 0.5)
+}
+
+func getSVGDimensions(root *xml.StartElement) (width, height int64) {
+	var viewBox string
+
+	for _, attr := range root.Attr {
+		switch strings.ToLower(attr.Name.Local) {
+		case "width":
+			width = parseSVGLength(attr.Value)
+		case "height":
+			height = parseSVGLength(attr.Value)
+		case "viewbox":
+			viewBox = attr.Value
+		}
+	}
+
+	if width > 0 && height > 0 {
+		return width, height
+	}
+
+	const viewBoxParts = 4
+
+	parts := svgListSeparator.Split(strings.TrimSpace(viewBox), -1)
+	if len(parts) != viewBoxParts {
+		return 0, 0
+	}
+
+	return parseSVGLength(parts[2]), parseSVGLength(parts[3])
+}
+
+// sanitizeSVG parses the whole document and removes what could run scripts or
+// load external resources, since the stored file is what ends up being
+// served: scripts and embedded documents, event handlers, external references,
+// entity declarations and external stylesheets. Everything else is copied as
+// is. It returns the sanitized document, or nil if nothing was removed, along
+// with the root element.
+func sanitizeSVG(buf []byte) ([]byte, *xml.StartElement, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(buf))
+	decoder.Strict = true
+
+	var (
+		out      bytes.Buffer
+		root     *xml.StartElement
+		open     []xml.Name
+		removing int
+		inStyle  bool
+		changed  bool
+		offset   int64
+	)
+
+	for {
+		// raw tokens keep the namespace prefixes, so kept elements are
+		// written back as they were
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, errImageIsCorrupted
+		}
+
+		raw := buf[offset:decoder.InputOffset()]
+		offset = decoder.InputOffset()
+
+		switch t := token.(type) {
+		case xml.Directive:
+			if bytes.Contains(bytes.ToUpper(t), []byte("ENTITY")) {
+				changed = true
+				continue
+			}
+		case xml.ProcInst:
+			if strings.EqualFold(t.Target, "xml-stylesheet") {
+				changed = true
+				continue
+			}
+		case xml.StartElement:
+			if root == nil {
+				if !strings.EqualFold(t.Name.Local, "svg") {
+					return nil, nil, errImageIsCorrupted
+				}
+
+				element := t.Copy()
+				root = &element
+			}
+
+			open = append(open, t.Name)
+
+			if removing > 0 || svgRemovedElements[strings.ToLower(t.Name.Local)] {
+				removing++
+				changed = true
+
+				continue
+			}
+
+			inStyle = strings.EqualFold(t.Name.Local, "style")
+
+			attrs := make([]xml.Attr, 0, len(t.Attr))
+
+			for i := range t.Attr {
+				if !isUnsafeSVGAttribute(&t.Attr[i]) {
+					attrs = append(attrs, t.Attr[i])
+				}
+			}
+
+			if len(attrs) != len(t.Attr) {
+				changed = true
+				t.Attr = attrs
+				writeSVGStartElement(&out, &t, bytes.HasSuffix(raw, []byte("/>")))
+
+				continue
+			}
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != t.Name {
+				return nil, nil, errImageIsCorrupted
+			}
+
+			open = open[:len(open)-1]
+			inStyle = false
+
+			if removing > 0 {
+				removing--
+				continue
+			}
+		case xml.CharData:
+			if removing == 0 && inStyle && isUnsafeSVGStyle(t) {
+				changed = true
+				continue
+			}
+		}
+
+		if removing == 0 {
+			out.Write(raw)
+		}
+	}
+
+	if root == nil || len(open) > 0 {
+		return nil, nil, errImageIsCorrupted
+	}
+
+	if !changed {
+		return nil, root, nil
+	}
+
+	return out.Bytes(), root, nil
+}
+
+// decodeSVGMetadata sanitizes the document and reads its dimensions. The
+// sanitized document is returned when something was removed, it replaces the
+// original one.
+func decodeSVGMetadata(buf []byte) (*decodedImage, error) {
+	sanitized, root, err := sanitizeSVG(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := getSVGDimensions(root)
+	if width <= 0 || height <= 0 {
+		return nil, errImageIsCorrupted
+	}
+
+	return &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:  width,
+			Height: height,
+			Frames: 1,
+		},
+		Sanitized: sanitized,
+	}, nil
+}
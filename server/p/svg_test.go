@@ -0,0 +1,87 @@
+package p
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSanitizeSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		want string
+	}{
+		{
+			name: "safe document is kept",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"><rect width="10" height="10"/></svg>`,
+			want: "",
+		},
+		{
+			name: "script",
+			svg:  `<svg width="10" height="10"><script>alert(1)</script><rect/></svg>`,
+			want: `<svg width="10" height="10"><rect/></svg>`,
+		},
+		{
+			name: "self closing script",
+			svg:  `<svg width="10" height="10"><script href="x.js"/><rect/></svg>`,
+			want: `<svg width="10" height="10"><rect/></svg>`,
+		},
+		{
+			name: "event handler",
+			svg:  `<svg width="10" height="10" onload="alert(1)"><rect/></svg>`,
+			want: `<svg width="10" height="10"><rect/></svg>`,
+		},
+		{
+			name: "foreign object",
+			svg:  `<svg width="10" height="10"><foreignObject><div>x</div></foreignObject></svg>`,
+			want: `<svg width="10" height="10"></svg>`,
+		},
+		{
+			name: "external xlink href",
+			svg: `<svg xmlns:xlink="http://www.w3.org/1999/xlink" width="10" height="10">` +
+				`<image xlink:href="https://example.com/a.png" width="1"/><use xlink:href="#a"/></svg>`,
+			want: `<svg xmlns:xlink="http://www.w3.org/1999/xlink" width="10" height="10">` +
+				`<image width="1"/><use xlink:href="#a"/></svg>`,
+		},
+		{
+			name: "external stylesheet",
+			svg:  `<svg width="10" height="10"><style>@import url(https://example.com/a.css);</style></svg>`,
+			want: `<svg width="10" height="10"><style></style></svg>`,
+		},
+		{
+			name: "entity declaration",
+			svg:  `<!DOCTYPE svg [<!ENTITY a "b">]><svg width="10" height="10"></svg>`,
+			want: `<svg width="10" height="10"></svg>`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sanitized, root, err := sanitizeSVG([]byte(test.svg))
+			if err != nil {
+				t.Fatalf("sanitizeSVG() failed: %v", err)
+			}
+
+			if root == nil || root.Name.Local != "svg" {
+				t.Fatalf("sanitizeSVG() root = %v", root)
+			}
+
+			if string(sanitized) != test.want {
+				t.Errorf("sanitizeSVG() = %q, want %q", sanitized, test.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeSVGCorrupted(t *testing.T) {
+	for _, svg := range []string{
+		`<svg width="10" height="10"><rect></svg>`,
+		`<svg width="10" height="10">`,
+		`<html></html>`,
+		`<svg width="10" height="10">&undefined;</svg>`,
+	} {
+		if _, _, err := sanitizeSVG([]byte(svg)); !errors.Is(err, errImageIsCorrupted) {
+			t.Errorf("sanitizeSVG(%q) error = %v, want %v", svg, err, errImageIsCorrupted)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package p
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+type stage struct {
+	envName        string
+	defaultTimeout time.Duration
+}
+
+// Every handler runs under the request stage deadline, and each outbound call
+// gets its own deadline on top of it. Deadlines can be overridden with the
+// matching environment variable using time.ParseDuration syntax (e.g. "45s").
+var (
+	stageRequest = stage{envName: "REQUEST_TIMEOUT", defaultTimeout: 60 * time.Second}
+	stageStorage = stage{envName: "STORAGE_TIMEOUT", defaultTimeout: 20 * time.Second}
+	stageGithub  = stage{envName: "GITHUB_TIMEOUT", defaultTimeout: 15 * time.Second}
+	stageFetch   = stage{envName: "FETCH_TIMEOUT", defaultTimeout: 30 * time.Second}
+)
+
+func (s stage) timeout() time.Duration {
+	value := os.Getenv(s.envName)
+	if value == "" {
+		return s.defaultTimeout
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		log.Printf("invalid %s value %q, using %v", s.envName, value, s.defaultTimeout)
+		return s.defaultTimeout
+	}
+
+	return timeout
+}
+
+// withStageTimeout derives a context bounded by the stage deadline. The parent
+// deadline still applies, so a stage never outlives the request it serves.
+func withStageTimeout(ctx context.Context, s stage) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, s.timeout())
+}
+
+func isTimeoutOrCanceled(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
@@ -6,8 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-
-	"cloud.google.com/go/bigquery"
+	"time"
 )
 
 const (
@@ -15,30 +14,40 @@ const (
 	cDirectTrigger = "direct"
 )
 
-func createNewUsagesEntryIfNotExist(client *bigquery.Client, macroName string) error {
-	query := client.Query(`
-		INSERT INTO github-macros.macros.usages (macro_name, clicks, directs)
-		SELECT @macro_name, 0, 0 FROM (SELECT 1)
-		LEFT JOIN github-macros.macros.macros M
-		ON M.name = @macro_name
-		LEFT JOIN github-macros.macros.usages U
-		ON U.macro_name = @macro_name
-		WHERE M.name IS NOT NULL AND U.macro_name IS NULL
-	`)
-	query.Parameters = []bigquery.QueryParameter{
-		{
-			Name:  "macro_name",
-			Value: macroName,
-		},
+// incrementUsages counts a usage of the macro by the given trigger. The usage
+// is buffered and written with the other usages of the macro later on.
+func incrementUsages(ctx context.Context, macroName, trigger string) {
+	bufferUsage(ctx, &usageEvent{MacroName: macroName, Trigger: trigger})
+}
+
+// recordMacroUsage counts a usage of the macro and, for authenticated
+// callers, adds it to their recently used macros. Both are buffered, the list
+// is a convenience so a caller whose login can't be resolved is only counted.
+func recordMacroUsage(ctx context.Context, token, macroName, trigger string) {
+	event := &usageEvent{MacroName: macroName, Trigger: trigger, UsedAt: time.Now()}
+
+	if token != "" {
+		login, err := getCallerLogin(ctx, token)
+		if err != nil {
+			log.Printf("failed to get the caller login: %v", err)
+		}
+
+		event.UserLogin = login
 	}
 
-	_, err := runQuery(context.Background(), query)
+	bufferUsage(ctx, event)
+}
 
-	return err
+func bufferUsage(ctx context.Context, event *usageEvent) {
+	if err := getUsageBuffer().Add(ctx, event); err != nil {
+		log.Panicf("failed to buffer usage: %v", err)
+	}
 }
 
 func Usage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Access-Control-Allow-Origin", "*")
+	if allowCORS(w, r) {
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		log.Panicf("failed to parse form: %v", err)
@@ -54,43 +63,12 @@ func Usage(w http.ResponseWriter, r *http.Request) {
 		log.Panicf("macro name is missing")
 	}
 
-	ctx := context.Background()
-	client, err := bigquery.NewClient(ctx, "github-macros")
-
-	if err != nil {
-		log.Panicf("failed to create bigquery client: %v", err)
-	}
-
-	defer func() {
-		if err = client.Close(); err != nil {
-			log.Printf("client.Close(): %v", err)
-		}
-	}()
-
-	if err = createNewUsagesEntryIfNotExist(client, macroName); err != nil {
-		log.Panicf("createNewUsagesEntryIfNotExist: %v", err)
-	}
-
-	var query *bigquery.Query
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
 
-	if trigger == cClickTrigger {
-		query = client.Query("UPDATE `github-macros.macros.usages` SET clicks = clicks + 1 WHERE macro_name=@macro_name")
-	} else {
-		query = client.Query("UPDATE `github-macros.macros.usages` SET directs = directs + 1 WHERE macro_name=@macro_name")
-	}
-
-	query.Parameters = []bigquery.QueryParameter{
-		{
-			Name:  "macro_name",
-			Value: macroName,
-		},
-	}
-
-	if _, err = runQuery(ctx, query); err != nil {
-		log.Panicf("failed to increment number of usages: %v", err)
-	}
+	recordMacroUsage(ctx, getCallerToken(r), macroName, trigger)
 
-	_, err = fmt.Fprint(w, "OK")
+	_, err := fmt.Fprint(w, "OK")
 
 	if err != nil {
 		log.Panicf("failed to write response: %v", err)
@@ -0,0 +1,459 @@
+package p
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/pubsub/v1"
+)
+
+const (
+	cUsageBufferEnv           = "USAGE_BUFFER"
+	cUsageBufferPubSub        = "pubsub"
+	cUsageBufferMemory        = "memory"
+	cUsageTopicEnv            = "USAGE_TOPIC"
+	cUsageSubscriptionEnv     = "USAGE_SUBSCRIPTION"
+	cUsageFlushIntervalEnv    = "USAGE_FLUSH_INTERVAL"
+	cDefaultUsageTopic        = "usage-events"
+	cDefaultUsageSubscription = "usage-events-flush"
+	cDefaultUsageFlushEvery   = 10 * time.Second
+	// the memory buffer is flushed right away once it holds this many macros
+	// or recent usages
+	cUsageBufferMaxMacros = 1000
+	cUsagePullBatchSize   = 1000
+	cUsagePullMaxBatches  = 20
+)
+
+// usageEvent is a single usage of a macro, UserLogin is set for
+// authenticated callers whose recently used macros it updates.
+type usageEvent struct {
+	MacroName string    `json:"macro_name"`
+	Trigger   string    `json:"trigger"`
+	UserLogin string    `json:"user_login,omitempty"`
+	UsedAt    time.Time `json:"used_at"`
+}
+
+// usageIncrement is the number of usages of a macro to add to its counters.
+type usageIncrement struct {
+	MacroName string `bigquery:"macro_name"`
+	Clicks    int64  `bigquery:"clicks"`
+	Directs   int64  `bigquery:"directs"`
+}
+
+// recentUsage is the last time a user used a macro.
+type recentUsage struct {
+	UserLogin string    `bigquery:"user_login"`
+	MacroName string    `bigquery:"macro_name"`
+	UseTime   time.Time `bigquery:"use_time"`
+}
+
+type recentUsageKey struct {
+	login     string
+	macroName string
+}
+
+// usageBatch aggregates usage events: an increment per macro and the last
+// usage per user and macro.
+type usageBatch struct {
+	increments map[string]*usageIncrement
+	recents    map[recentUsageKey]*recentUsage
+}
+
+// usageBuffer accepts usage events and writes them to the usages and recent
+// usages tables aggregated, rather than running DML statements per usage.
+// Events are written at least once: batches that failed to be written are
+// retried, so a write that failed after it was committed is counted twice.
+type usageBuffer interface {
+	Add(ctx context.Context, event *usageEvent) error
+	// Flush writes the buffered increments.
+	Flush(ctx context.Context) error
+}
+
+// memoryUsageBuffer aggregates the events in the process and flushes them
+// every USAGE_FLUSH_INTERVAL, for self hosted servers. Events still buffered
+// when the process is killed are lost, so servers flush it on shutdown.
+type memoryUsageBuffer struct {
+	mu        sync.Mutex
+	batch     *usageBatch
+	startOnce sync.Once
+	// flushes run one at a time, so failed increments are put back before
+	// the next one
+	flushMu sync.Mutex
+	// set while a flush started by a full batch is running
+	flushPending int32
+}
+
+// pubSubUsageBuffer publishes every event to a Pub/Sub topic. The UsageFlush
+// function, triggered periodically, pulls them from a subscription and
+// acknowledges them once their increments were written.
+type pubSubUsageBuffer struct {
+	topic string
+}
+
+var (
+	usageBufferOnce  sync.Once
+	macroUsageBuffer usageBuffer
+)
+
+// getUsageBuffer returns the buffer selected by USAGE_BUFFER. Cloud Functions
+// use Pub/Sub, an in-process buffer would lose the usages of instances that
+// are throttled or stopped before flushing.
+func getUsageBuffer() usageBuffer {
+	backend := os.Getenv(cUsageBufferEnv)
+
+	if isCloudFunction() && backend != "" && backend != cUsageBufferPubSub {
+		log.Panicf("%s=%s can't be used by Cloud Functions, use %s", cUsageBufferEnv, backend, cUsageBufferPubSub)
+	}
+
+	usageBufferOnce.Do(func() {
+		if backend == cUsageBufferPubSub || (backend == "" && isCloudFunction()) {
+			topic := os.Getenv(cUsageTopicEnv)
+			if topic == "" {
+				topic = cDefaultUsageTopic
+			}
+
+			macroUsageBuffer = &pubSubUsageBuffer{topic: topic}
+
+			return
+		}
+
+		macroUsageBuffer = &memoryUsageBuffer{batch: newUsageBatch()}
+	})
+
+	return macroUsageBuffer
+}
+
+func getUsageFlushInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv(cUsageFlushIntervalEnv))
+	if err != nil || interval <= 0 {
+		return cDefaultUsageFlushEvery
+	}
+
+	return interval
+}
+
+func newUsageBatch() *usageBatch {
+	return &usageBatch{increments: map[string]*usageIncrement{}, recents: map[recentUsageKey]*recentUsage{}}
+}
+
+// size returns the number of rows the batch writes.
+func (batch *usageBatch) size() int {
+	return len(batch.increments) + len(batch.recents)
+}
+
+// add aggregates the event into the batch.
+func (batch *usageBatch) add(event *usageEvent) {
+	increment, ok := batch.increments[event.MacroName]
+	if !ok {
+		increment = &usageIncrement{MacroName: event.MacroName}
+		batch.increments[event.MacroName] = increment
+	}
+
+	if event.Trigger == cClickTrigger {
+		increment.Clicks++
+	} else {
+		increment.Directs++
+	}
+
+	if event.UserLogin == "" {
+		return
+	}
+
+	usedAt := event.UsedAt
+	if usedAt.IsZero() {
+		usedAt = time.Now()
+	}
+
+	batch.addRecent(&recentUsage{UserLogin: event.UserLogin, MacroName: event.MacroName, UseTime: usedAt})
+}
+
+// addRecent keeps the latest usage of the user and macro.
+func (batch *usageBatch) addRecent(recent *recentUsage) {
+	key := recentUsageKey{login: recent.UserLogin, macroName: recent.MacroName}
+
+	if current, ok := batch.recents[key]; !ok || recent.UseTime.After(current.UseTime) {
+		batch.recents[key] = recent
+	}
+}
+
+// merge adds the usages of other into the batch.
+func (batch *usageBatch) merge(other *usageBatch) {
+	for name, increment := range other.increments {
+		if current, ok := batch.increments[name]; ok {
+			current.Clicks += increment.Clicks
+			current.Directs += increment.Directs
+		} else {
+			batch.increments[name] = increment
+		}
+	}
+
+	for _, recent := range other.recents {
+		batch.addRecent(recent)
+	}
+}
+
+// writeUsageBatch writes the increments and the recent usages of the batch in
+// a single transaction, so a batch that failed to be written is retried as a
+// whole without counting its usages twice.
+func writeUsageBatch(ctx context.Context, batch *usageBatch) error {
+	if batch.size() == 0 {
+		return nil
+	}
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		return fmt.Errorf("bigquery.NewClient: %v", err)
+	}
+	defer client.Close()
+
+	query := client.Query(`
+		BEGIN TRANSACTION;
+		` + cUsageIncrementsStatement + `;
+		` + cRecentUsagesStatement + `;
+		COMMIT TRANSACTION;
+	`)
+	query.Parameters = []bigquery.QueryParameter{
+		{
+			Name:  "increments",
+			Value: getSortedIncrements(batch.increments),
+		},
+		{
+			Name:  "recents",
+			Value: getSortedRecents(batch.recents),
+		},
+	}
+
+	_, err = runQuery(ctx, query)
+
+	return err
+}
+
+// cUsageIncrementsStatement adds the increments to the counters of the
+// macros. Rows of macros used for the first time are created, and increments
+// of macros that don't exist are dropped.
+const cUsageIncrementsStatement = `
+		MERGE github-macros.macros.usages U
+		USING (
+			SELECT I.macro_name, I.clicks, I.directs
+			FROM UNNEST(@increments) I
+			JOIN github-macros.macros.macros M
+			ON M.name = I.macro_name
+		) I
+		ON U.macro_name = I.macro_name
+		WHEN MATCHED THEN
+			UPDATE SET clicks = U.clicks + I.clicks, directs = U.directs + I.directs
+		WHEN NOT MATCHED THEN
+			INSERT (macro_name, clicks, directs) VALUES (I.macro_name, I.clicks, I.directs)`
+
+// cRecentUsagesStatement moves the macros to the top of the recently used
+// lists of their users. A usage older than the stored one, which was
+// delivered late, is ignored.
+const cRecentUsagesStatement = `
+		MERGE github-macros.macros.recent_usages R
+		USING (SELECT S.user_login, S.macro_name, S.use_time FROM UNNEST(@recents) S) S
+		ON R.user_login = S.user_login AND R.macro_name = S.macro_name
+		WHEN MATCHED AND S.use_time > R.use_time THEN
+			UPDATE SET use_time = S.use_time
+		WHEN NOT MATCHED THEN
+			INSERT (user_login, macro_name, use_time)
+			VALUES (S.user_login, S.macro_name, S.use_time)`
+
+// getSortedIncrements returns the increments ordered by macro, so the
+// statements of equal batches are identical.
+func getSortedIncrements(increments map[string]*usageIncrement) []usageIncrement {
+	values := make([]usageIncrement, 0, len(increments))
+	for _, increment := range increments {
+		values = append(values, *increment)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].MacroName < values[j].MacroName
+	})
+
+	return values
+}
+
+// getSortedRecents returns the recent usages ordered by user and macro.
+func getSortedRecents(recents map[recentUsageKey]*recentUsage) []recentUsage {
+	values := make([]recentUsage, 0, len(recents))
+	for _, recent := range recents {
+		values = append(values, *recent)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].UserLogin != values[j].UserLogin {
+			return values[i].UserLogin < values[j].UserLogin
+		}
+
+		return values[i].MacroName < values[j].MacroName
+	})
+
+	return values
+}
+
+func (b *memoryUsageBuffer) Add(_ context.Context, event *usageEvent) error {
+	b.startOnce.Do(func() {
+		go b.flushPeriodically()
+	})
+
+	b.mu.Lock()
+	b.batch.add(event)
+	full := b.batch.size() >= cUsageBufferMaxMacros
+	b.mu.Unlock()
+
+	// a single flush is started however many usages arrive while it runs
+	if full && atomic.CompareAndSwapInt32(&b.flushPending, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&b.flushPending, 0)
+
+			b.flushInBackground()
+		}()
+	}
+
+	return nil
+}
+
+func (b *memoryUsageBuffer) flushPeriodically() {
+	ticker := time.NewTicker(getUsageFlushInterval())
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.flushInBackground()
+	}
+}
+
+func (b *memoryUsageBuffer) flushInBackground() {
+	ctx, cancel := withStageTimeout(context.Background(), stageStorage)
+	defer cancel()
+
+	if err := b.Flush(ctx); err != nil {
+		log.Printf("failed to flush usages: %v", err)
+	}
+}
+
+// Flush writes the buffered usages, they are put back in the buffer when the
+// write fails.
+func (b *memoryUsageBuffer) Flush(ctx context.Context) error {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	b.mu.Lock()
+	batch := b.batch
+	b.batch = newUsageBatch()
+	b.mu.Unlock()
+
+	err := writeUsageBatch(ctx, batch)
+	if err == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.batch.merge(batch)
+
+	return err
+}
+
+func (b *pubSubUsageBuffer) Add(ctx context.Context, event *usageEvent) error {
+	return publishMessage(ctx, b.topic, event)
+}
+
+// Flush does nothing, published events are already durable and are written
+// by the UsageFlush function.
+func (b *pubSubUsageBuffer) Flush(context.Context) error {
+	return nil
+}
+
+// pullUsageEvents writes the events waiting in the subscription, a batch at a
+// time. A batch is acknowledged only after its increments were written, so
+// events of a failed batch are delivered again. It returns the number of
+// events written.
+func pullUsageEvents(ctx context.Context, subscription string) (int, error) {
+	service, err := getPubSubService()
+	if err != nil {
+		return 0, err
+	}
+
+	subscriptionName := fmt.Sprintf("projects/github-macros/subscriptions/%s", subscription)
+	written := 0
+
+	for batch := 0; batch < cUsagePullMaxBatches; batch++ {
+		response, err := service.Projects.Subscriptions.Pull(
+			subscriptionName,
+			&pubsub.PullRequest{MaxMessages: cUsagePullBatchSize},
+		).Context(ctx).Do()
+		if err != nil {
+			return written, fmt.Errorf("failed to pull usages: %v", err)
+		}
+
+		if len(response.ReceivedMessages) == 0 {
+			break
+		}
+
+		batch := newUsageBatch()
+		ackIDs := make([]string, 0, len(response.ReceivedMessages))
+
+		for _, received := range response.ReceivedMessages {
+			ackIDs = append(ackIDs, received.AckId)
+
+			var event usageEvent
+
+			payload, err := base64.StdEncoding.DecodeString(received.Message.Data)
+			if err == nil {
+				err = json.Unmarshal(payload, &event)
+			}
+
+			// malformed events are acknowledged, they would fail forever
+			if err != nil || event.MacroName == "" {
+				log.Printf("dropping malformed usage %s: %v", received.Message.MessageId, err)
+				continue
+			}
+
+			batch.add(&event)
+		}
+
+		if err := writeUsageBatch(ctx, batch); err != nil {
+			return written, fmt.Errorf("failed to write usages: %v", err)
+		}
+
+		_, err = service.Projects.Subscriptions.Acknowledge(
+			subscriptionName,
+			&pubsub.AcknowledgeRequest{AckIds: ackIDs},
+		).Context(ctx).Do()
+		if err != nil {
+			return written, fmt.Errorf("failed to acknowledge usages: %v", err)
+		}
+
+		written += len(ackIDs)
+
+		if len(response.ReceivedMessages) < cUsagePullBatchSize {
+			break
+		}
+	}
+
+	return written, nil
+}
+
+func getUsageSubscription() string {
+	if subscription := os.Getenv(cUsageSubscriptionEnv); subscription != "" {
+		return subscription
+	}
+
+	return cDefaultUsageSubscription
+}
+
+// FlushUsages writes the usages buffered in this process, so a self hosted
+// server doesn't lose them when it shuts down.
+func FlushUsages(ctx context.Context) error {
+	return getUsageBuffer().Flush(ctx)
+}
@@ -0,0 +1,170 @@
+package p
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetUsageBuffer makes the next getUsageBuffer select the buffer again.
+func resetUsageBuffer(t *testing.T) {
+	usageBufferOnce = sync.Once{}
+	macroUsageBuffer = nil
+
+	t.Cleanup(func() {
+		usageBufferOnce = sync.Once{}
+		macroUsageBuffer = nil
+	})
+}
+
+func TestGetUsageBuffer(t *testing.T) {
+	tests := []struct {
+		function string
+		backend  string
+		want     string
+	}{
+		{"", "", "*p.memoryUsageBuffer"},
+		{"", cUsageBufferMemory, "*p.memoryUsageBuffer"},
+		{"", cUsageBufferPubSub, "*p.pubSubUsageBuffer"},
+		{"usage", "", "*p.pubSubUsageBuffer"},
+		{"usage", cUsageBufferPubSub, "*p.pubSubUsageBuffer"},
+		{"usage", cUsageBufferMemory, "panic"},
+	}
+
+	for _, test := range tests {
+		resetUsageBuffer(t)
+		setTestEnv(t, "FUNCTION_TARGET", test.function)
+		setTestEnv(t, cUsageBufferEnv, test.backend)
+
+		got := func() (got string) {
+			defer func() {
+				if recover() != nil {
+					got = "panic"
+				}
+			}()
+
+			return fmt.Sprintf("%T", getUsageBuffer())
+		}()
+
+		if got != test.want {
+			t.Errorf("getUsageBuffer() with FUNCTION_TARGET=%q %s=%q = %s, want %s",
+				test.function, cUsageBufferEnv, test.backend, got, test.want)
+		}
+	}
+}
+
+func TestUsageBatch(t *testing.T) {
+	first := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(time.Minute)
+
+	batch := newUsageBatch()
+	batch.add(&usageEvent{MacroName: "lgtm", Trigger: cClickTrigger, UserLogin: "octocat", UsedAt: last})
+	batch.add(&usageEvent{MacroName: "lgtm", Trigger: cClickTrigger, UserLogin: "octocat", UsedAt: first})
+	batch.add(&usageEvent{MacroName: "lgtm", Trigger: cDirectTrigger, UserLogin: "hubot", UsedAt: first})
+	batch.add(&usageEvent{MacroName: "shipit", Trigger: cDirectTrigger})
+
+	other := newUsageBatch()
+	other.add(&usageEvent{MacroName: "shipit", Trigger: cClickTrigger, UserLogin: "hubot", UsedAt: last})
+	other.add(&usageEvent{MacroName: "lgtm", Trigger: cClickTrigger, UserLogin: "hubot", UsedAt: last})
+	batch.merge(other)
+
+	wantIncrements := map[string]usageIncrement{
+		"lgtm":   {MacroName: "lgtm", Clicks: 3, Directs: 1},
+		"shipit": {MacroName: "shipit", Clicks: 1, Directs: 1},
+	}
+
+	if len(batch.increments) != len(wantIncrements) {
+		t.Errorf("got %d increments, want %d", len(batch.increments), len(wantIncrements))
+	}
+
+	for name, want := range wantIncrements {
+		if got, ok := batch.increments[name]; !ok || *got != want {
+			t.Errorf("increment of %s = %+v, want %+v", name, got, want)
+		}
+	}
+
+	wantRecents := map[recentUsageKey]time.Time{
+		{login: "octocat", macroName: "lgtm"}: last,
+		{login: "hubot", macroName: "lgtm"}:   last,
+		{login: "hubot", macroName: "shipit"}: last,
+	}
+
+	if len(batch.recents) != len(wantRecents) {
+		t.Errorf("got %d recent usages, want %d", len(batch.recents), len(wantRecents))
+	}
+
+	for key, want := range wantRecents {
+		if got, ok := batch.recents[key]; !ok || !got.UseTime.Equal(want) {
+			t.Errorf("recent usage of %s by %s = %+v, want %v", key.macroName, key.login, got, want)
+		}
+	}
+
+	if got, want := batch.size(), len(wantIncrements)+len(wantRecents); got != want {
+		t.Errorf("size() = %d, want %d", got, want)
+	}
+}
+
+func TestWriteUsageBatchIsOneStatement(t *testing.T) {
+	fake := useFakeBigQuery(t)
+
+	batch := newUsageBatch()
+	batch.add(&usageEvent{MacroName: "lgtm", Trigger: cClickTrigger, UserLogin: "octocat"})
+
+	if err := writeUsageBatch(context.Background(), batch); err != nil {
+		t.Fatalf("writeUsageBatch() = %v", err)
+	}
+
+	// the increments and the recent usages are committed together, or not at all
+	if queries := fake.queryCount(); queries != 1 {
+		t.Errorf("writeUsageBatch() ran %d queries, want 1", queries)
+	}
+
+	if err := writeUsageBatch(context.Background(), newUsageBatch()); err != nil || fake.queryCount() != 1 {
+		t.Errorf("writeUsageBatch() of an empty batch = %v after %d queries, want no query", err, fake.queryCount()-1)
+	}
+}
+
+func TestMemoryUsageBufferStartsOneFlush(t *testing.T) {
+	fake := useFakeBigQuery(t)
+	fake.hold = make(chan struct{})
+
+	buffer := &memoryUsageBuffer{batch: newUsageBatch()}
+	buffer.startOnce.Do(func() {})
+
+	ctx := context.Background()
+	goroutines := runtime.NumGoroutine()
+
+	// every usage past the limit finds the batch full
+	for i := 0; i < cUsageBufferMaxMacros+100; i++ {
+		if err := buffer.Add(ctx, &usageEvent{MacroName: fmt.Sprintf("macro-%d", i), Trigger: cDirectTrigger}); err != nil {
+			t.Fatalf("Add() = %v", err)
+		}
+	}
+
+	for deadline := time.Now().Add(time.Second); fake.queryCount() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("a full buffer wasn't flushed")
+		}
+	}
+
+	// flushes wait for each other, the ones started meanwhile would pile up
+	if started := runtime.NumGoroutine() - goroutines; started > 10 {
+		t.Errorf("a full buffer started %d goroutines while flushing", started)
+	}
+
+	close(fake.hold)
+
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&buffer.flushPending) != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the flush of a full buffer didn't complete")
+		}
+	}
+
+	if queries := fake.queryCount(); queries != 1 {
+		t.Errorf("a full buffer ran %d writes, want 1", queries)
+	}
+}
@@ -0,0 +1,50 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+const (
+	cUsageFlushSecretEnv    = "USAGE_FLUSH_SECRET"
+	cUsageFlushSecretHeader = "X-Usage-Flush-Secret"
+)
+
+// isValidUsageFlushSecret checks the secret the scheduler sends, requests are
+// refused when USAGE_FLUSH_SECRET isn't set.
+func isValidUsageFlushSecret(secret string) bool {
+	expected := os.Getenv(cUsageFlushSecretEnv)
+
+	return expected != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
+
+// UsageFlush writes the usage events published to the usage topic, when
+// USAGE_BUFFER is pubsub. It's meant to be triggered periodically, e.g. by
+// Cloud Scheduler sending the USAGE_FLUSH_SECRET header, and events it fails
+// to write are pulled again by the next run.
+func UsageFlush(w http.ResponseWriter, r *http.Request) {
+	if !isValidUsageFlushSecret(r.Header.Get(cUsageFlushSecretHeader)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	written, err := pullUsageEvents(ctx, getUsageSubscription())
+	if err != nil {
+		log.Panicf("failed to flush usages after %d events: %v", written, err)
+	}
+
+	log.Printf("flushed %d usage events", written)
+
+	_, err = fmt.Fprint(w, "OK")
+
+	if err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
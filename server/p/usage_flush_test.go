@@ -0,0 +1,40 @@
+package p
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUsageFlushRequiresSecret(t *testing.T) {
+	tests := []struct {
+		secret string
+		header string
+		want   bool
+	}{
+		{"flush-secret", "flush-secret", true},
+		{"flush-secret", "other-secret", false},
+		{"flush-secret", "", false},
+		{"", "", false},
+	}
+
+	for _, test := range tests {
+		setTestEnv(t, cUsageFlushSecretEnv, test.secret)
+
+		if got := isValidUsageFlushSecret(test.header); got != test.want {
+			t.Errorf("isValidUsageFlushSecret(%q) with secret %q = %v, want %v", test.header, test.secret, got, test.want)
+		}
+	}
+
+	setTestEnv(t, cUsageFlushSecretEnv, "flush-secret")
+
+	r := httptest.NewRequest(http.MethodPost, "/usage_flush", nil)
+	r.Header.Set(cUsageFlushSecretHeader, "other-secret")
+
+	w := httptest.NewRecorder()
+	UsageFlush(w, r)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("UsageFlush() with a wrong secret = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
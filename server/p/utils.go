@@ -2,27 +2,177 @@ package p
 
 import (
 	"context"
+	"net/http"
+	"os"
 
 	"cloud.google.com/go/bigquery"
 )
 
+const (
+	Success                       = 0
+	EmptyName                     = 1
+	NameContainsSpaces            = 2
+	NameAlreadyExist              = 3
+	EmptyURL                      = 4
+	InvalidURL                    = 5
+	URLHostnameNotSupported       = 6
+	FileIsTooBig                  = 7
+	FileFormatNotSupported        = 8
+	TransientError                = 9
+	MissingMandatoryFields        = 10
+	InfraFailure                  = 11
+	PermanentError                = 12
+	FileIsCorrupted               = 13
+	SVGContainsUnsafeContent      = 14
+	SimilarMacroExists            = 15
+	AliasTargetNotFound           = 16
+	JobNotFound                   = 17
+	NameContainsInvalidCharacters = 18
+	NameIsTooShort                = 19
+	NameIsTooLong                 = 20
+	NameIsReserved                = 21
+	NamespaceAccessDenied         = 22
+	InvalidNamespace              = 23
+	AuthenticationRequired        = 24
+	MacroNotFound                 = 25
+	CollectionNotFound            = 26
+	InvalidCollectionName         = 27
+	InvalidCollectionDescription  = 28
+	TooManyMacros                 = 29
+	InvalidRequest                = 30
+	ImageFetchFailed              = 31
+)
+
+type ErrorCode = int
+
+// codeResponse is the response of requests that only report their outcome.
+type codeResponse struct {
+	Code ErrorCode `json:"code"`
+}
+
+// macroListResponse is a page of macros, NextPage and NextCursor are only set
+// when HasMore is.
+type macroListResponse struct {
+	Code       ErrorCode   `json:"code"`
+	Data       []*MacroRow `json:"data"`
+	HasMore    bool        `json:"has_more"`
+	NextPage   int         `json:"next_page,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// allowCORS sets the CORS headers of the response. Preflight requests, sent
+// by browsers before requests carrying a token, are answered right away, in
+// which case it returns true.
+func allowCORS(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	w.Header().Add("Access-Control-Expose-Headers", "ETag")
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+
+	w.Header().Add("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+	w.Header().Add("Access-Control-Allow-Methods", "GET, POST")
+	w.Header().Add("Access-Control-Max-Age", "3600")
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
+
+// MacroRow describes a single macro. Frames, DurationMs and LoopCount are only
+// meaningful for animated media: static images have a single frame. LoopCount
+// follows the GIF semantics, 0 loops forever and -1 plays the animation once,
+// it's -2 (cLoopCountNotAnimated) for static images and when it's unknown.
+// The thumbnail and the animated preview are optional smaller variants, their
+// URLs are empty when they weren't generated. SHA256 and PHash identify the
+// media content and are used to detect duplicates.
 type MacroRow struct {
-	Name      string `json:"name"`
-	URL       string `json:"url"`
-	URLSize   int64  `json:"url_size" bigquery:"url_size"`
-	Width     int64  `json:"width"`
-	Height    int64  `json:"height"`
-	GithubURL string `json:"github_url" bigquery:"github_url"`
+	Name            string             `json:"name"`
+	URL             string             `json:"url"`
+	URLSize         int64              `json:"url_size" bigquery:"url_size"`
+	Width           int64              `json:"width"`
+	Height          int64              `json:"height"`
+	GithubURL       string             `json:"github_url" bigquery:"github_url"`
+	Frames          int64              `json:"frames" bigquery:"frames"`
+	DurationMs      int64              `json:"duration_ms" bigquery:"duration_ms"`
+	LoopCount       int64              `json:"loop_count" bigquery:"loop_count"`
+	ThumbnailURL    string             `json:"thumbnail_url" bigquery:"thumbnail_url"`
+	ThumbnailWidth  int64              `json:"thumbnail_width" bigquery:"thumbnail_width"`
+	ThumbnailHeight int64              `json:"thumbnail_height" bigquery:"thumbnail_height"`
+	PreviewURL      string             `json:"preview_url" bigquery:"preview_url"`
+	PreviewWidth    int64              `json:"preview_width" bigquery:"preview_width"`
+	PreviewHeight   int64              `json:"preview_height" bigquery:"preview_height"`
+	SHA256          string             `json:"-" bigquery:"sha256"`
+	PHash           bigquery.NullInt64 `json:"-" bigquery:"phash"`
+	Usages          int64              `json:"-" bigquery:"usages"`
+}
+
+// cMacroMediaColumns selects the media metadata of a macro, with defaults for
+// macros added before the columns existed.
+const cMacroMediaColumns = `
+	IFNULL(frames, 1) AS frames,
+	IFNULL(duration_ms, 0) AS duration_ms,
+	IFNULL(loop_count, -2) AS loop_count,
+	IFNULL(thumbnail_url, '') AS thumbnail_url,
+	IFNULL(thumbnail_width, 0) AS thumbnail_width,
+	IFNULL(thumbnail_height, 0) AS thumbnail_height,
+	IFNULL(preview_url, '') AS preview_url,
+	IFNULL(preview_width, 0) AS preview_width,
+	IFNULL(preview_height, 0) AS preview_height
+`
+
+// isCloudFunction reports whether the code runs as a deployed Cloud Function.
+// Instances of a function don't share memory, the functions of the project
+// are separate deployments, and background work is throttled once the
+// response is sent, so in-process queues and caches can't be relied on.
+func isCloudFunction() bool {
+	return os.Getenv("FUNCTION_TARGET") != ""
+}
+
+// newBigQueryClient creates a client of the github-macros project, tests
+// point it at a fake server.
+var newBigQueryClient = func(ctx context.Context) (*bigquery.Client, error) {
+	return bigquery.NewClient(ctx, "github-macros")
+}
+
+// runDMLQuery runs a DML statement and returns the number of rows it changed.
+func runDMLQuery(ctx context.Context, query *bigquery.Query) (int64, error) {
+	jobCtx, cancel := withStageTimeout(ctx, stageStorage)
+	defer cancel()
+
+	job, err := query.Run(jobCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	status, err := job.Wait(jobCtx)
+	if err != nil {
+		return 0, err
+	}
+
+	if status.Err() != nil {
+		return 0, status.Err()
+	}
+
+	statistics, ok := status.Statistics.Details.(*bigquery.QueryStatistics)
+	if !ok {
+		return 0, nil
+	}
+
+	return statistics.NumDMLAffectedRows, nil
 }
 
 func runQuery(ctx context.Context, query *bigquery.Query) (*bigquery.RowIterator, error) {
-	job, err := query.Run(ctx)
+	jobCtx, cancel := withStageTimeout(ctx, stageStorage)
+	defer cancel()
+
+	job, err := query.Run(jobCtx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	status, err := job.Wait(ctx)
+	status, err := job.Wait(jobCtx)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,225 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"log"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	cThumbnailMaxSize = 240
+	cPNGContentType   = "image/png"
+	cGIFContentType   = "image/gif"
+	// the preview replaces the thumbnail while it's hovered, so it's shown at
+	// the same size
+	cPreviewMaxSize = cThumbnailMaxSize
+)
+
+// mediaVariant is a smaller rendition of a macro, used by the picker so it
+// doesn't have to load the full size media to show search results.
+type mediaVariant struct {
+	URL    string
+	Width  int64
+	Height int64
+}
+
+type macroVariants struct {
+	Thumbnail *mediaVariant
+	Preview   *mediaVariant
+}
+
+// fitWithin scales width and height down so both fit in maxSize, keeping the
+// aspect ratio. Images are never scaled up.
+func fitWithin(width, height, maxSize int) (int, int) {
+	if width <= maxSize && height <= maxSize {
+		return width, height
+	}
+
+	if width >= height {
+		return maxSize, atLeastOne(height * maxSize / width)
+	}
+
+	return atLeastOne(width * maxSize / height), maxSize
+}
+
+func atLeastOne(value int) int {
+	if value < 1 {
+		return 1
+	}
+
+	return value
+}
+
+func resizeImage(src image.Image, maxSize int) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), maxSize)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	return dst
+}
+
+func encodeThumbnail(src image.Image) ([]byte, image.Rectangle, error) {
+	thumbnail := resizeImage(src, cThumbnailMaxSize)
+
+	var buf bytes.Buffer
+
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, thumbnail); err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	return buf.Bytes(), thumbnail.Bounds(), nil
+}
+
+// compositeGIFFrames renders the frames of the animation one at a time on
+// the full canvas, honoring the disposal method of the previous frame, since
+// GIF frames are often only the part of the canvas that changed. render is
+// called with every rendered frame, the canvas is reused so it must not keep
+// it. Animations exceeding the pixel budget are not rendered.
+func compositeGIFFrames(g *gif.GIF, render func(i int, frame *image.RGBA)) error {
+	err := checkPixelBudget(int64(g.Config.Width), int64(g.Config.Height), int64(len(g.Image)))
+	if err != nil {
+		return err
+	}
+
+	canvasRect := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(canvasRect)
+
+	var previous *image.RGBA
+
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(canvasRect)
+			}
+
+			draw.Draw(previous, canvasRect, canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		render(i, canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, canvasRect, previous, image.Point{}, draw.Src)
+		}
+	}
+
+	return nil
+}
+
+// newPreviewFrame scales a rendered frame down to the preview size, with the
+// palette of the original frame.
+func newPreviewFrame(frame *image.RGBA, palette color.Palette) *image.Paletted {
+	resized := resizeImage(frame, cPreviewMaxSize)
+	paletted := image.NewPaletted(resized.Bounds(), palette)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), resized, image.Point{})
+
+	return paletted
+}
+
+func encodeAnimatedPreview(preview *gif.GIF) ([]byte, image.Rectangle, error) {
+	var buf bytes.Buffer
+
+	if err := gif.EncodeAll(&buf, preview); err != nil {
+		return nil, image.Rectangle{}, err
+	}
+
+	return buf.Bytes(), preview.Image[0].Bounds(), nil
+}
+
+func storeVariant(
+	ctx context.Context,
+	store mediaStore,
+	objectName, contentType string,
+	data []byte,
+	bounds image.Rectangle,
+) (*mediaVariant, error) {
+	variantURL, err := store.Put(ctx, objectName, contentType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mediaVariant{
+		URL:    variantURL,
+		Width:  int64(bounds.Dx()),
+		Height: int64(bounds.Dy()),
+	}, nil
+}
+
+// generateVariants creates a static thumbnail for every decodable image and
+// a small animated preview for GIFs, and stores them in store. The variants
+// are optional, so failures are logged and the macro is added without them.
+func generateVariants(ctx context.Context, store mediaStore, macroGithubURL string, decoded *decodedImage) *macroVariants {
+	variants := &macroVariants{}
+
+	if store == nil || decoded.Still == nil {
+		return variants
+	}
+
+	digest := sha256.Sum256([]byte(macroGithubURL))
+	prefix := fmt.Sprintf("variants/%s", hex.EncodeToString(digest[:]))
+
+	still := decoded.Still
+
+	var preview *gif.GIF
+
+	if g := decoded.Animation; g != nil {
+		if len(g.Image) > 1 {
+			preview = &gif.GIF{Delay: g.Delay, LoopCount: g.LoopCount}
+		}
+
+		// every frame is scaled down as soon as it's rendered, so only the
+		// canvas is kept at full size
+		err := compositeGIFFrames(g, func(i int, frame *image.RGBA) {
+			if i == 0 {
+				still = resizeImage(frame, cThumbnailMaxSize)
+			}
+
+			if preview != nil {
+				preview.Image = append(preview.Image, newPreviewFrame(frame, g.Image[i].Palette))
+			}
+		})
+		if err != nil {
+			log.Printf("failed to render animation: %v", err)
+			return variants
+		}
+	}
+
+	if data, bounds, err := encodeThumbnail(still); err != nil {
+		log.Printf("failed to encode thumbnail: %v", err)
+	} else if variants.Thumbnail, err = storeVariant(ctx, store, prefix+"/thumbnail.png", cPNGContentType, data, bounds); err != nil {
+		log.Printf("failed to store thumbnail: %v", err)
+	}
+
+	if preview == nil {
+		return variants
+	}
+
+	if data, bounds, err := encodeAnimatedPreview(preview); err != nil {
+		log.Printf("failed to encode animated preview: %v", err)
+	} else if variants.Preview, err = storeVariant(ctx, store, prefix+"/preview.gif", cGIFContentType, data, bounds); err != nil {
+		log.Printf("failed to store animated preview: %v", err)
+	}
+
+	return variants
+}
@@ -0,0 +1,207 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	cWebhookSecretEnv       = "GITHUB_WEBHOOK_SECRET"
+	cWebhookSignatureHeader = "X-Hub-Signature-256"
+	cWebhookEventHeader     = "X-GitHub-Event"
+	cWebhookDeliveryHeader  = "X-GitHub-Delivery"
+	cWebhookSignaturePrefix = "sha256="
+	cWebhookMaxPayloadSize  = 1024 * 1024
+	cGithubAPIURLPrefix     = "https://api.github.com/"
+	cGithubBotSenderType    = "Bot"
+	cGithubOrgOwnerType     = "Organization"
+)
+
+// webhookCommentEvents are the events of comments the app expands macros in.
+var webhookCommentEvents = map[string]bool{
+	"issue_comment":               true,
+	"pull_request_review_comment": true,
+	"discussion_comment":          true,
+}
+
+var webhookCommentActions = map[string]bool{
+	"created": true,
+	"edited":  true,
+}
+
+type webhookAccount struct {
+	Login string `json:"login"`
+	Type  string `json:"type"`
+}
+
+type commentEventPayload struct {
+	Action  string `json:"action"`
+	Comment struct {
+		URL       string    `json:"url"`
+		NodeID    string    `json:"node_id"`
+		Body      string    `json:"body"`
+		UpdatedAt time.Time `json:"updated_at"`
+	} `json:"comment"`
+	Repository struct {
+		Private bool           `json:"private"`
+		Owner   webhookAccount `json:"owner"`
+	} `json:"repository"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+	Sender webhookAccount `json:"sender"`
+}
+
+// isValidWebhookSignature checks the HMAC GitHub signs every delivery with,
+// using the secret the app was configured with.
+func isValidWebhookSignature(payload []byte, signature string) bool {
+	secret := os.Getenv(cWebhookSecretEnv)
+	if secret == "" || !strings.HasPrefix(signature, cWebhookSignaturePrefix) {
+		return false
+	}
+
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, cWebhookSignaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// getRepositoryNamespaces returns the namespaces whose macros can be used in
+// the repository, organization macros are only expanded in private
+// repositories of the organization so they don't leak to the public.
+func getRepositoryNamespaces(event *commentEventPayload) []string {
+	owner := event.Repository.Owner
+	if !event.Repository.Private || owner.Type != cGithubOrgOwnerType {
+		return []string{}
+	}
+
+	return []string{strings.ToLower(owner.Login)}
+}
+
+// updateEventComment writes the expanded body back to the comment of event.
+func updateEventComment(ctx context.Context, eventName string, event *commentEventPayload, body string) error {
+	if eventName == "discussion_comment" {
+		return updateDiscussionCommentBody(ctx, event.Installation.ID, event.Comment.NodeID, event.Comment.UpdatedAt, body)
+	}
+
+	if !strings.HasPrefix(event.Comment.URL, cGithubAPIURLPrefix) {
+		return fmt.Errorf("unexpected comment URL %q", event.Comment.URL)
+	}
+
+	return updateCommentBody(ctx, event.Installation.ID, event.Comment.URL, event.Comment.UpdatedAt, body)
+}
+
+// isExpandableCommentEvent tells whether the comment of event is new or
+// edited by a user and references macros.
+func isExpandableCommentEvent(event *commentEventPayload) bool {
+	if !webhookCommentActions[event.Action] || event.Sender.Type == cGithubBotSenderType {
+		return false
+	}
+
+	return len(findMacroReferences(event.Comment.Body)) > 0
+}
+
+// handleCommentEvent expands the macros referenced by the comment of event
+// and counts a direct usage for each of them.
+func handleCommentEvent(ctx context.Context, eventName string, event *commentEventPayload) {
+	if !isExpandableCommentEvent(event) {
+		return
+	}
+
+	client, err := newBigQueryClient(ctx)
+	if err != nil {
+		log.Panicf("failed to create bigquery client: %v", err)
+	}
+	defer client.Close()
+
+	expansion, errCode := expandMarkdown(ctx, client, event.Comment.Body, getRepositoryNamespaces(event))
+	if errCode != Success || len(expansion.Resolved) == 0 {
+		return
+	}
+
+	err = updateEventComment(ctx, eventName, event, expansion.Markdown)
+
+	// the newer edit has its own delivery, which expands it
+	if errors.Is(err, errCommentChanged) {
+		log.Printf("skipping comment %s: %v", event.Comment.NodeID, err)
+		return
+	}
+
+	if err != nil {
+		log.Panicf("failed to update comment: %v", err)
+	}
+
+	for _, macroName := range expansion.Resolved {
+		incrementUsages(ctx, macroName, cDirectTrigger)
+	}
+}
+
+// Webhook receives the deliveries of the GitHub App and replaces the $name$
+// references of new and edited comments with their images. GitHub gives up on
+// deliveries after 10 seconds, so the comments are expanded by a worker and
+// the delivery is acknowledged once it's queued.
+func Webhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, cWebhookMaxPayloadSize+1))
+	if err != nil {
+		log.Panicf("failed to read payload: %v", err)
+	}
+
+	if len(payload) > cWebhookMaxPayloadSize {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if !isValidWebhookSignature(payload, r.Header.Get(cWebhookSignatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	eventName := r.Header.Get(cWebhookEventHeader)
+
+	if webhookCommentEvents[eventName] {
+		var event commentEventPayload
+		if err := json.Unmarshal(payload, &event); err != nil {
+			http.Error(w, "invalid payload", http.StatusBadRequest)
+			return
+		}
+
+		if isExpandableCommentEvent(&event) {
+			delivery := &webhookDelivery{ID: r.Header.Get(cWebhookDeliveryHeader), Event: eventName, Payload: &event}
+
+			if err := getWebhookQueue().Enqueue(r.Context(), delivery); err != nil {
+				log.Printf("failed to enqueue delivery %s: %v", delivery.ID, err)
+				http.Error(w, "failed to queue the delivery", http.StatusServiceUnavailable)
+
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}
+
+	if _, err := fmt.Fprint(w, "OK"); err != nil {
+		log.Panicf("failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package p contains an HTTP Cloud Function.
+package p
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const (
+	cWebhookQueueEnv     = "WEBHOOK_QUEUE"
+	cWebhookQueuePubSub  = "pubsub"
+	cWebhookTopicEnv     = "WEBHOOK_TOPIC"
+	cDefaultWebhookTopic = "webhook-events"
+	cWebhookWorkers      = 2
+	cWebhookQueueSize    = 100
+)
+
+var errWebhookQueueFull = errors.New("webhook queue is full")
+
+// webhookDelivery is a verified comment event, handed over to a worker so the
+// delivery is acknowledged before GitHub gives up on it.
+type webhookDelivery struct {
+	ID      string               `json:"id"`
+	Event   string               `json:"event"`
+	Payload *commentEventPayload `json:"payload"`
+}
+
+// webhookQueue hands webhook deliveries over to a worker.
+type webhookQueue interface {
+	Enqueue(ctx context.Context, delivery *webhookDelivery) error
+}
+
+// memoryWebhookQueue handles deliveries by a pool of goroutines in the same
+// process, for self hosted servers.
+type memoryWebhookQueue struct {
+	deliveries chan *webhookDelivery
+	startOnce  sync.Once
+	pending    sync.WaitGroup
+}
+
+// pubSubWebhookQueue publishes deliveries to a Pub/Sub topic, whose push
+// subscription delivers them to the WebhookWorker function.
+type pubSubWebhookQueue struct {
+	topic string
+}
+
+var (
+	webhookQueueOnce sync.Once
+	webhookEvents    webhookQueue
+)
+
+// getWebhookQueue returns the Pub/Sub queue when WEBHOOK_QUEUE is pubsub or
+// when running as a Cloud Function, and the in-process queue otherwise.
+func getWebhookQueue() webhookQueue {
+	backend := os.Getenv(cWebhookQueueEnv)
+
+	// the webhook and webhook_worker functions are separate deployments
+	if isCloudFunction() && backend != "" && backend != cWebhookQueuePubSub {
+		log.Panicf("%s=%s can't be used by Cloud Functions, use %s", cWebhookQueueEnv, backend, cWebhookQueuePubSub)
+	}
+
+	webhookQueueOnce.Do(func() {
+		if backend == cWebhookQueuePubSub || (backend == "" && isCloudFunction()) {
+			topic := os.Getenv(cWebhookTopicEnv)
+			if topic == "" {
+				topic = cDefaultWebhookTopic
+			}
+
+			webhookEvents = &pubSubWebhookQueue{topic: topic}
+
+			return
+		}
+
+		webhookEvents = &memoryWebhookQueue{deliveries: make(chan *webhookDelivery, cWebhookQueueSize)}
+	})
+
+	return webhookEvents
+}
+
+func (q *memoryWebhookQueue) Enqueue(_ context.Context, delivery *webhookDelivery) error {
+	q.startOnce.Do(func() {
+		for i := 0; i < cWebhookWorkers; i++ {
+			go q.work()
+		}
+	})
+
+	q.pending.Add(1)
+
+	select {
+	case q.deliveries <- delivery:
+		return nil
+	default:
+		q.pending.Done()
+		return errWebhookQueueFull
+	}
+}
+
+func (q *memoryWebhookQueue) work() {
+	for delivery := range q.deliveries {
+		q.handle(delivery)
+	}
+}
+
+// handle recovers from the panics of the delivery, a failed delivery must not
+// stop the worker.
+func (q *memoryWebhookQueue) handle(delivery *webhookDelivery) {
+	ctx, cancel := withStageTimeout(context.Background(), stageRequest)
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("failed to handle delivery %s: %v", delivery.ID, err)
+		}
+
+		cancel()
+		q.pending.Done()
+	}()
+
+	handleCommentEvent(ctx, delivery.Event, delivery.Payload)
+}
+
+// wait blocks until all the queued deliveries are handled or ctx is done.
+func (q *memoryWebhookQueue) wait(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		q.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *pubSubWebhookQueue) Enqueue(ctx context.Context, delivery *webhookDelivery) error {
+	return publishMessage(ctx, q.topic, delivery)
+}
+
+// WebhookWorker handles the webhook deliveries published by the Webhook
+// function, pushed by the subscription of the webhook topic. A redelivered
+// event finds its comment already expanded, and messages that can't be
+// decoded are acknowledged, they would fail on every delivery.
+func WebhookWorker(w http.ResponseWriter, r *http.Request) {
+	var delivery webhookDelivery
+
+	messageID, err := decodePushMessage(r, &delivery)
+	if err != nil || delivery.Payload == nil {
+		log.Printf("dropping malformed delivery %s: %v", messageID, err)
+		writeWorkerAck(w)
+
+		return
+	}
+
+	ctx, cancel := withStageTimeout(r.Context(), stageRequest)
+	defer cancel()
+
+	handleCommentEvent(ctx, delivery.Event, delivery.Payload)
+
+	writeWorkerAck(w)
+}
+
+// WaitForPendingWebhooks blocks until the webhook deliveries queued in this
+// process are handled, so a self hosted server can shut down gracefully.
+func WaitForPendingWebhooks(ctx context.Context) error {
+	if memoryQueue, ok := getWebhookQueue().(*memoryWebhookQueue); ok {
+		return memoryQueue.wait(ctx)
+	}
+
+	return nil
+}
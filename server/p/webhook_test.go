@@ -0,0 +1,250 @@
+package p
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const cTestWebhookSecret = "webhook-secret"
+
+// recordingWebhookQueue keeps the deliveries instead of handling them, and
+// fails them with err when it's set.
+type recordingWebhookQueue struct {
+	deliveries []*webhookDelivery
+	err        error
+}
+
+func (q *recordingWebhookQueue) Enqueue(_ context.Context, delivery *webhookDelivery) error {
+	if q.err != nil {
+		return q.err
+	}
+
+	q.deliveries = append(q.deliveries, delivery)
+
+	return nil
+}
+
+func resetWebhookQueue(t *testing.T) {
+	webhookQueueOnce = sync.Once{}
+	webhookEvents = nil
+
+	t.Cleanup(func() {
+		webhookQueueOnce = sync.Once{}
+		webhookEvents = nil
+	})
+}
+
+// useWebhookQueue makes queue the webhook queue for the duration of the test.
+func useWebhookQueue(t *testing.T, queue webhookQueue) {
+	resetWebhookQueue(t)
+
+	webhookQueueOnce.Do(func() {
+		webhookEvents = queue
+	})
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return cWebhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookRequest(eventName, body, signature string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	r.Header.Set(cWebhookEventHeader, eventName)
+	r.Header.Set(cWebhookDeliveryHeader, "delivery-1")
+
+	if signature != "" {
+		r.Header.Set(cWebhookSignatureHeader, signature)
+	}
+
+	return r
+}
+
+func TestIsValidWebhookSignature(t *testing.T) {
+	payload := []byte(`{"action":"created"}`)
+	valid := signWebhookPayload(cTestWebhookSecret, payload)
+
+	tests := []struct {
+		name      string
+		secret    string
+		payload   []byte
+		signature string
+		want      bool
+	}{
+		{"valid", cTestWebhookSecret, payload, valid, true},
+		{"tampered payload", cTestWebhookSecret, []byte(`{"action":"edited"}`), valid, false},
+		{"tampered signature", cTestWebhookSecret, payload, valid[:len(valid)-2] + "00", false},
+		{"other secret", cTestWebhookSecret, payload, signWebhookPayload("other", payload), false},
+		{"missing", cTestWebhookSecret, payload, "", false},
+		{"wrong prefix", cTestWebhookSecret, payload, "sha1=" + strings.TrimPrefix(valid, cWebhookSignaturePrefix), false},
+		{"not hex", cTestWebhookSecret, payload, cWebhookSignaturePrefix + "not-hex", false},
+		{"no secret configured", "", payload, signWebhookPayload("", payload), false},
+	}
+
+	for _, test := range tests {
+		setTestEnv(t, cWebhookSecretEnv, test.secret)
+
+		if got := isValidWebhookSignature(test.payload, test.signature); got != test.want {
+			t.Errorf("%s: isValidWebhookSignature() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWebhookQueuesExpandableComments(t *testing.T) {
+	setTestEnv(t, cWebhookSecretEnv, cTestWebhookSecret)
+
+	queue := &recordingWebhookQueue{}
+	useWebhookQueue(t, queue)
+
+	comment := func(action, body, senderType string) string {
+		return `{"action":"` + action + `","comment":{"body":"` + body + `"},"sender":{"type":"` + senderType + `"}}`
+	}
+
+	tests := []struct {
+		name      string
+		eventName string
+		body      string
+		want      int
+		queued    bool
+	}{
+		{"new comment", "issue_comment", comment("created", "looks good $lgtm$", "User"), http.StatusAccepted, true},
+		{"edited comment", "discussion_comment", comment("edited", "$lgtm$", "User"), http.StatusAccepted, true},
+		{"no references", "issue_comment", comment("created", "looks good", "User"), http.StatusOK, false},
+		{"deleted comment", "issue_comment", comment("deleted", "$lgtm$", "User"), http.StatusOK, false},
+		{"bot comment", "issue_comment", comment("created", "$lgtm$", cGithubBotSenderType), http.StatusOK, false},
+		{"other event", "push", `{}`, http.StatusOK, false},
+		{"invalid payload", "issue_comment", `{`, http.StatusBadRequest, false},
+	}
+
+	for _, test := range tests {
+		queue.deliveries = nil
+
+		w := httptest.NewRecorder()
+		Webhook(w, newWebhookRequest(test.eventName, test.body, signWebhookPayload(cTestWebhookSecret, []byte(test.body))))
+
+		if w.Code != test.want || (len(queue.deliveries) == 1) != test.queued {
+			t.Errorf("%s: Webhook() = %d with %d deliveries queued, want %d, queued %v",
+				test.name, w.Code, len(queue.deliveries), test.want, test.queued)
+		}
+	}
+
+	queue.deliveries = nil
+
+	w := httptest.NewRecorder()
+	body := comment("created", "$lgtm$", "User")
+	Webhook(w, newWebhookRequest("issue_comment", body, signWebhookPayload(cTestWebhookSecret, []byte(body))))
+
+	if len(queue.deliveries) != 1 || queue.deliveries[0].ID != "delivery-1" || queue.deliveries[0].Event != "issue_comment" {
+		t.Errorf("Webhook() queued %+v, want the delivery and its event", queue.deliveries)
+	}
+}
+
+func TestWebhookRejectsDeliveries(t *testing.T) {
+	setTestEnv(t, cWebhookSecretEnv, cTestWebhookSecret)
+
+	queue := &recordingWebhookQueue{err: errWebhookQueueFull}
+	useWebhookQueue(t, queue)
+
+	body := `{"action":"created","comment":{"body":"$lgtm$"},"sender":{"type":"User"}}`
+	signature := signWebhookPayload(cTestWebhookSecret, []byte(body))
+
+	w := httptest.NewRecorder()
+	Webhook(w, newWebhookRequest("issue_comment", body, ""))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Webhook() without a signature = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	w = httptest.NewRecorder()
+	Webhook(w, newWebhookRequest("issue_comment", body, signature))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Webhook() with a full queue = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+
+	w = httptest.NewRecorder()
+	Webhook(w, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET Webhook() = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestMemoryWebhookQueue(t *testing.T) {
+	queue := &memoryWebhookQueue{deliveries: make(chan *webhookDelivery, 1)}
+	useWebhookQueue(t, queue)
+
+	// a delivery whose handling panics doesn't stop the workers
+	if err := queue.Enqueue(context.Background(), &webhookDelivery{ID: "broken"}); err != nil {
+		t.Fatalf("Enqueue() = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := WaitForPendingWebhooks(ctx); err != nil {
+		t.Fatalf("WaitForPendingWebhooks() = %v", err)
+	}
+
+	delivery := &webhookDelivery{ID: "ignored", Event: "issue_comment", Payload: &commentEventPayload{Action: "deleted"}}
+	if err := queue.Enqueue(context.Background(), delivery); err != nil {
+		t.Fatalf("Enqueue() = %v", err)
+	}
+
+	if err := WaitForPendingWebhooks(ctx); err != nil {
+		t.Errorf("WaitForPendingWebhooks() = %v", err)
+	}
+}
+
+func TestWebhookWorkerAcknowledgesMalformedMessages(t *testing.T) {
+	for _, data := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("{")), ""} {
+		body, err := json.Marshal(map[string]interface{}{"message": map[string]string{"data": data, "messageId": "1"}})
+		if err != nil {
+			t.Fatalf("failed to marshal push request: %v", err)
+		}
+
+		w := httptest.NewRecorder()
+		WebhookWorker(w, httptest.NewRequest(http.MethodPost, "/webhook_worker", bytes.NewReader(body)))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("WebhookWorker(%q) = %d, want %d", data, w.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestGetWebhookQueue(t *testing.T) {
+	tests := []struct {
+		function string
+		backend  string
+		want     bool
+	}{
+		{"", "", false},
+		{"", cWebhookQueuePubSub, true},
+		{"webhook", "", true},
+	}
+
+	for _, test := range tests {
+		setTestEnv(t, "FUNCTION_TARGET", test.function)
+		setTestEnv(t, cWebhookQueueEnv, test.backend)
+		resetWebhookQueue(t)
+
+		_, isPubSub := getWebhookQueue().(*pubSubWebhookQueue)
+		if isPubSub != test.want {
+			t.Errorf("getWebhookQueue() with FUNCTION_TARGET=%q %s=%q is Pub/Sub %v, want %v",
+				test.function, cWebhookQueueEnv, test.backend, isPubSub, test.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package p
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+const (
+	cWebPHeaderSize   = 12
+	cWebPChunkHeader  = 8
+	cWebPAnimationBit = 0x02
+	cVP8XSize         = 10
+	cANIMSize         = 6
+	cANMFHeaderSize   = 16
+)
+
+func readUint24LE(b []byte) int64 {
+	return int64(b[0]) | int64(b[1])<<8 | int64(b[2])<<16
+}
+
+// walkWebPChunks calls fn for every RIFF chunk of a WebP file and returns
+// errImageIsCorrupted if a chunk runs past the end of the file.
+func walkWebPChunks(buf []byte, fn func(fourCC string, data []byte)) error {
+	if len(buf) < cWebPHeaderSize || !bytes.Equal(buf[:4], []byte("RIFF")) || !bytes.Equal(buf[8:12], []byte("WEBP")) {
+		return errImageIsCorrupted
+	}
+
+	for offset := cWebPHeaderSize; offset < len(buf); {
+		if offset+cWebPChunkHeader > len(buf) {
+			return errImageIsCorrupted
+		}
+
+		fourCC := string(buf[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(buf[offset+4:]))
+		start := offset + cWebPChunkHeader
+
+		if size < 0 || start+size > len(buf) {
+			return errImageIsCorrupted
+		}
+
+		fn(fourCC, buf[start:start+size])
+
+		// chunks are padded to an even size
+		offset = start + size + size%2
+	}
+
+	return nil
+}
+
+// decodeWebPMetadata fully decodes still WebP images. Animated WebP files are
+// not supported by the decoder, so for those we validate the chunk structure
+// and read the animation parameters from the ANIM and ANMF chunks.
+func decodeWebPMetadata(buf []byte) (*decodedImage, error) {
+	var (
+		isAnimated    bool
+		width, height int64
+		frames        int64
+		durationMs    int64
+		plays         int64
+	)
+
+	err := walkWebPChunks(buf, func(fourCC string, data []byte) {
+		switch {
+		case fourCC == "VP8X" && len(data) >= cVP8XSize:
+			isAnimated = data[0]&cWebPAnimationBit != 0
+			width = readUint24LE(data[4:]) + 1
+			height = readUint24LE(data[7:]) + 1
+		case fourCC == "ANIM" && len(data) >= cANIMSize:
+			plays = int64(binary.LittleEndian.Uint16(data[4:]))
+		case fourCC == "ANMF" && len(data) >= cANMFHeaderSize:
+			frames++
+			durationMs += readUint24LE(data[12:])
+		}
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !isAnimated {
+		return decodeStaticImageMetadata(buf)
+	}
+
+	if frames == 0 || width <= 0 || height <= 0 {
+		return nil, errImageIsCorrupted
+	}
+
+	if err := checkPixelBudget(width, height, frames); err != nil {
+		return nil, err
+	}
+
+	return &decodedImage{
+		imageMetadata: imageMetadata{
+			Width:      width,
+			Height:     height,
+			Frames:     frames,
+			DurationMs: durationMs,
+			LoopCount:  loopCountFromPlays(plays),
+		},
+	}, nil
+}
@@ -3,24 +3,48 @@
 rm ~/Downloads/cloudfunction-$1.zip
 
 case $1 in
-	add)
-        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/add.go ./p/gist.go ./p/add_utils.go ./p/query_utils.go ./p/utils.go ./p/gist.go
+	add|add_status|add_worker)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/add.go ./p/add_status.go ./p/add_worker.go ./p/jobs.go ./p/pubsub.go ./p/names.go ./p/namespaces.go ./p/caller.go ./p/gist.go ./p/add_utils.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go ./p/formats.go ./p/webp.go ./p/svg.go ./p/avif.go ./p/media.go ./p/variants.go ./p/hashes.go ./p/optimize.go ./p/cache.go ./p/redis_cache.go ./p/autocomplete.go
         break
 		;;
 	client_error)
-		zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/client_error.go ./p/utils.go
+		zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/client_error.go ./p/utils.go ./p/media_urls.go ./p/timeouts.go
 		break
 		;;
     query)
-        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/query.go ./p/query_utils.go ./p/utils.go
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/query.go ./p/cursor.go ./p/autocomplete.go ./p/query_cache.go ./p/cache.go ./p/redis_cache.go ./p/names.go ./p/namespaces.go ./p/caller.go ./p/personal.go ./p/collections.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
         break
         ;;    
     report)
-        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/report.go ./p/add_utils.go ./p/utils.go
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/report.go ./p/add_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go ./p/formats.go ./p/webp.go ./p/svg.go ./p/avif.go ./p/hashes.go ./p/cache.go ./p/redis_cache.go ./p/autocomplete.go ./p/query_utils.go ./p/namespaces.go ./p/caller.go ./p/names.go
         break
         ;;    
     usage)
-        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/usage.go ./p/utils.go
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/usage.go ./p/usage_buffer.go ./p/pubsub.go ./p/personal.go ./p/collections.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    usage_flush)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/usage_flush.go ./p/usage_buffer.go ./p/pubsub.go ./p/usage.go ./p/personal.go ./p/collections.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    collection)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/collections.go ./p/personal.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    expand)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/expand.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    star|unstar)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/personal.go ./p/collections.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    webhook|webhook_worker)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/webhook.go ./p/webhook_queue.go ./p/pubsub.go ./p/github_app.go ./p/expand.go ./p/usage.go ./p/usage_buffer.go ./p/personal.go ./p/collections.go ./p/namespaces.go ./p/names.go ./p/caller.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go
+        break
+        ;;    
+    api)
+        zip -j ~/Downloads/cloudfunction-$1.zip go.mod ./p/api.go ./p/openapi.go ./p/query.go ./p/cursor.go ./p/autocomplete.go ./p/query_cache.go ./p/cache.go ./p/redis_cache.go ./p/usage.go ./p/usage_buffer.go ./p/report.go ./p/personal.go ./p/collections.go ./p/add.go ./p/add_status.go ./p/add_worker.go ./p/jobs.go ./p/pubsub.go ./p/names.go ./p/namespaces.go ./p/caller.go ./p/gist.go ./p/add_utils.go ./p/query_utils.go ./p/utils.go ./p/media_urls.go ./p/fetch.go ./p/timeouts.go ./p/formats.go ./p/webp.go ./p/svg.go ./p/avif.go ./p/media.go ./p/variants.go ./p/hashes.go ./p/optimize.go
         break
         ;;    
   esac
@@ -0,0 +1,295 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package macrospb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// MacroServiceClient is the client API for MacroService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MacroServiceClient interface {
+	// Search returns a page of the macros whose name contains the text, most
+	// used first.
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*MacroList, error)
+	// Get returns a single macro, MacroNotFound (25) if there is none.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Suggest returns a page of the most used macros.
+	Suggest(ctx context.Context, in *SuggestRequest, opts ...grpc.CallOption) (*MacroList, error)
+	// Add adds a macro and waits for its add job to be done.
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error)
+	// RecordUsage counts a usage of a macro.
+	RecordUsage(ctx context.Context, in *RecordUsageRequest, opts ...grpc.CallOption) (*CodeResponse, error)
+	// Report reports that the image of a macro is broken.
+	Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*CodeResponse, error)
+}
+
+type macroServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMacroServiceClient(cc grpc.ClientConnInterface) MacroServiceClient {
+	return &macroServiceClient{cc}
+}
+
+func (c *macroServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*MacroList, error) {
+	out := new(MacroList)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/Search", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *macroServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *macroServiceClient) Suggest(ctx context.Context, in *SuggestRequest, opts ...grpc.CallOption) (*MacroList, error) {
+	out := new(MacroList)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/Suggest", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *macroServiceClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error) {
+	out := new(AddResponse)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/Add", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *macroServiceClient) RecordUsage(ctx context.Context, in *RecordUsageRequest, opts ...grpc.CallOption) (*CodeResponse, error) {
+	out := new(CodeResponse)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/RecordUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *macroServiceClient) Report(ctx context.Context, in *ReportRequest, opts ...grpc.CallOption) (*CodeResponse, error) {
+	out := new(CodeResponse)
+	err := c.cc.Invoke(ctx, "/githubmacros.v1.MacroService/Report", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MacroServiceServer is the server API for MacroService service.
+// All implementations must embed UnimplementedMacroServiceServer
+// for forward compatibility
+type MacroServiceServer interface {
+	// Search returns a page of the macros whose name contains the text, most
+	// used first.
+	Search(context.Context, *SearchRequest) (*MacroList, error)
+	// Get returns a single macro, MacroNotFound (25) if there is none.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Suggest returns a page of the most used macros.
+	Suggest(context.Context, *SuggestRequest) (*MacroList, error)
+	// Add adds a macro and waits for its add job to be done.
+	Add(context.Context, *AddRequest) (*AddResponse, error)
+	// RecordUsage counts a usage of a macro.
+	RecordUsage(context.Context, *RecordUsageRequest) (*CodeResponse, error)
+	// Report reports that the image of a macro is broken.
+	Report(context.Context, *ReportRequest) (*CodeResponse, error)
+	mustEmbedUnimplementedMacroServiceServer()
+}
+
+// UnimplementedMacroServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMacroServiceServer struct {
+}
+
+func (UnimplementedMacroServiceServer) Search(context.Context, *SearchRequest) (*MacroList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedMacroServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedMacroServiceServer) Suggest(context.Context, *SuggestRequest) (*MacroList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Suggest not implemented")
+}
+func (UnimplementedMacroServiceServer) Add(context.Context, *AddRequest) (*AddResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedMacroServiceServer) RecordUsage(context.Context, *RecordUsageRequest) (*CodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RecordUsage not implemented")
+}
+func (UnimplementedMacroServiceServer) Report(context.Context, *ReportRequest) (*CodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Report not implemented")
+}
+func (UnimplementedMacroServiceServer) mustEmbedUnimplementedMacroServiceServer() {}
+
+// UnsafeMacroServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MacroServiceServer will
+// result in compilation errors.
+type UnsafeMacroServiceServer interface {
+	mustEmbedUnimplementedMacroServiceServer()
+}
+
+func RegisterMacroServiceServer(s grpc.ServiceRegistrar, srv MacroServiceServer) {
+	s.RegisterService(&MacroService_ServiceDesc, srv)
+}
+
+func _MacroService_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/Search",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MacroService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MacroService_Suggest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SuggestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).Suggest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/Suggest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).Suggest(ctx, req.(*SuggestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MacroService_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/Add",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MacroService_RecordUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RecordUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).RecordUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/RecordUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).RecordUsage(ctx, req.(*RecordUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MacroService_Report_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MacroServiceServer).Report(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/githubmacros.v1.MacroService/Report",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MacroServiceServer).Report(ctx, req.(*ReportRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MacroService_ServiceDesc is the grpc.ServiceDesc for MacroService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MacroService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "githubmacros.v1.MacroService",
+	HandlerType: (*MacroServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Search",
+			Handler:    _MacroService_Search_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _MacroService_Get_Handler,
+		},
+		{
+			MethodName: "Suggest",
+			Handler:    _MacroService_Suggest_Handler,
+		},
+		{
+			MethodName: "Add",
+			Handler:    _MacroService_Add_Handler,
+		},
+		{
+			MethodName: "RecordUsage",
+			Handler:    _MacroService_RecordUsage_Handler,
+		},
+		{
+			MethodName: "Report",
+			Handler:    _MacroService_Report_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "macros.proto",
+}